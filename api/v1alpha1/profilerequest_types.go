@@ -0,0 +1,151 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProfileRequestSpec defines the pods to profile and how to profile them.
+// +kubebuilder:validation:XValidation:rule="has(self.podName) != has(self.selector)",message="exactly one of podName and selector must be specified"
+type ProfileRequestSpec struct {
+	// PodName is the name of the pod to profile in the namespace of the ProfileRequest.
+	// +optional
+	PodName string `json:"podName,omitempty"`
+
+	// Selector selects the running pods to profile in the namespace of the ProfileRequest.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Container is the name of the container to profile.
+	// The default container of the pod is profiled if empty.
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// Duration is the duration of recording.
+	// +kubebuilder:default="30s"
+	// +optional
+	Duration metav1.Duration `json:"duration,omitempty"`
+
+	// Format is the output format of the result.
+	// +kubebuilder:validation:Enum=pprof;folded;script
+	// +kubebuilder:default=pprof
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Type is the type of the profile.
+	// +kubebuilder:validation:Enum=cpu;off-cpu
+	// +kubebuilder:default=cpu
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// Frequency is the sampling frequency in Hz. The default of necoperf-daemon is used if zero.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Frequency int32 `json:"frequency,omitempty"`
+
+	// CallGraph is the call graph recording method. The default of necoperf-daemon is used if empty.
+	// +kubebuilder:validation:Enum=fp;dwarf;lbr
+	// +optional
+	CallGraph string `json:"callGraph,omitempty"`
+}
+
+// ProfilePhase is the phase of a ProfileRequest or of a profiled pod.
+type ProfilePhase string
+
+const (
+	// ProfilePhasePending is the phase of a profiled pod whose job is about to be started.
+	ProfilePhasePending   = ProfilePhase("Pending")
+	ProfilePhaseRunning   = ProfilePhase("Running")
+	ProfilePhaseSucceeded = ProfilePhase("Succeeded")
+	ProfilePhaseFailed    = ProfilePhase("Failed")
+)
+
+// Condition types of ProfileRequest.
+const (
+	// ConditionStarted is true when the profiling jobs have been started on necoperf-daemon.
+	ConditionStarted = "Started"
+	// ConditionCompleted is true when all the profiling jobs have finished.
+	// Its reason is Succeeded if all of them have succeeded, and Failed otherwise.
+	ConditionCompleted = "Completed"
+)
+
+// ProfileTarget is the status of a profiled pod.
+type ProfileTarget struct {
+	// Pod is the name of the pod.
+	Pod string `json:"pod"`
+
+	// Node is the name of the node on which the pod is running.
+	// +optional
+	Node string `json:"node,omitempty"`
+
+	// Daemon is the address of necoperf-daemon which profiles the pod.
+	// +optional
+	Daemon string `json:"daemon,omitempty"`
+
+	// JobID is the ID of the profiling job on necoperf-daemon.
+	// The result can be fetched by `necoperf-cli profile fetch` with it until the retention period of necoperf-daemon passes.
+	// +optional
+	JobID string `json:"jobID,omitempty"`
+
+	// Phase is the phase of the profiling job.
+	// +optional
+	Phase ProfilePhase `json:"phase,omitempty"`
+
+	// Message describes the error or where the result is.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// ConfigMap is the name of the ConfigMap in which the result is stored.
+	// It is empty if the result is too large to be stored in a ConfigMap.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// Size is the size of the result in bytes.
+	// +optional
+	Size int64 `json:"size,omitempty"`
+}
+
+// ProfileRequestStatus defines the observed state of ProfileRequest.
+type ProfileRequestStatus struct {
+	// Phase is the phase of the ProfileRequest.
+	// +optional
+	Phase ProfilePhase `json:"phase,omitempty"`
+
+	// Targets are the statuses of the profiled pods.
+	// +optional
+	Targets []ProfileTarget `json:"targets,omitempty"`
+
+	// Conditions are the conditions of the ProfileRequest.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ProfileRequest is the Schema for the profilerequests API.
+// necoperf-controller profiles the pods once when it is created, and its spec cannot be changed.
+type ProfileRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+	Spec   ProfileRequestSpec   `json:"spec,omitempty"`
+	Status ProfileRequestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProfileRequestList contains a list of ProfileRequest.
+type ProfileRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProfileRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ProfileRequest{}, &ProfileRequestList{})
+}
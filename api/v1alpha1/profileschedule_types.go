@@ -0,0 +1,85 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProfileScheduleSpec defines when and how to profile the pods.
+type ProfileScheduleSpec struct {
+	// Schedule is the schedule in the cron format, e.g. "0 21 * * *".
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// TimeZone is the name of the time zone of the schedule, e.g. "Asia/Tokyo".
+	// The time zone of necoperf-controller is used if empty.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Suspend stops creating ProfileRequests while it is true.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// HistoryLimit is the number of the past ProfileRequests to keep.
+	// The older ones are deleted with their results when they have finished.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=7
+	// +optional
+	HistoryLimit int32 `json:"historyLimit,omitempty"`
+
+	// Template is the spec of the ProfileRequests created on the schedule.
+	Template ProfileRequestSpec `json:"template"`
+}
+
+// ProfileScheduleRun is a ProfileRequest created by a ProfileSchedule.
+type ProfileScheduleRun struct {
+	// Name is the name of the ProfileRequest.
+	Name string `json:"name"`
+
+	// ScheduleTime is the time at which the ProfileRequest was scheduled.
+	ScheduleTime metav1.Time `json:"scheduleTime"`
+
+	// Phase is the phase of the ProfileRequest.
+	// +optional
+	Phase ProfilePhase `json:"phase,omitempty"`
+}
+
+// ProfileScheduleStatus defines the observed state of ProfileSchedule.
+type ProfileScheduleStatus struct {
+	// LastScheduleTime is the time at which the last ProfileRequest was scheduled.
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// Runs are the kept ProfileRequests from the oldest.
+	// +optional
+	Runs []ProfileScheduleRun `json:"runs,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="SCHEDULE",type="string",JSONPath=".spec.schedule"
+//+kubebuilder:printcolumn:name="SUSPEND",type="boolean",JSONPath=".spec.suspend"
+//+kubebuilder:printcolumn:name="LAST SCHEDULE",type="date",JSONPath=".status.lastScheduleTime"
+//+kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ProfileSchedule is the Schema for the profileschedules API.
+// necoperf-controller creates a ProfileRequest from the template on the schedule.
+type ProfileSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProfileScheduleSpec   `json:"spec,omitempty"`
+	Status ProfileScheduleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProfileScheduleList contains a list of ProfileSchedule.
+type ProfileScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProfileSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ProfileSchedule{}, &ProfileScheduleList{})
+}
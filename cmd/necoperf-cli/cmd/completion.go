@@ -110,3 +110,15 @@ func namespaceCompletionFunc(cmd *cobra.Command, args []string, toComplete strin
 
 	return namespaces, cobra.ShellCompDirectiveNoFileComp
 }
+
+func formatCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"script", "pprof", "folded", "flamegraph", "raw"}, cobra.ShellCompDirectiveNoFileComp
+}
+
+func profileTypeCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"cpu", "off-cpu"}, cobra.ShellCompDirectiveNoFileComp
+}
+
+func compressionCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"none", "gzip", "zstd"}, cobra.ShellCompDirectiveNoFileComp
+}
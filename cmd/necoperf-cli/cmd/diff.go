@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/cybozu-go/necoperf/internal/diff"
+	"github.com/cybozu-go/necoperf/internal/flamegraph"
+	"github.com/spf13/cobra"
+)
+
+var diffConfig struct {
+	top        int
+	flameGraph string
+}
+
+func NewDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff BEFORE AFTER",
+		Short: "Compare two profiling results to find the functions which got hotter",
+		Long: `Compare two profiling results saved in the script or pprof format to find the functions which got hotter.
+The samples are normalized by the total of each result, and the functions are ranked by the change of their shares.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			logger := newLogger()
+
+			before, err := diff.Load(args[0])
+			if err != nil {
+				return err
+			}
+			after, err := diff.Load(args[1])
+			if err != nil {
+				return err
+			}
+
+			if len(diffConfig.flameGraph) != 0 {
+				countName, err := diff.CountName(args[0], args[1])
+				if err != nil {
+					return fmt.Errorf("cannot render the differential flame graph: %w", err)
+				}
+				f, err := os.Create(diffConfig.flameGraph)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				opts := flamegraph.Options{
+					Title:     fmt.Sprintf("%s -> %s", filepath.Base(args[0]), filepath.Base(args[1])),
+					CountName: countName,
+				}
+				if err := flamegraph.RenderDiff(f, before, after, opts); err != nil {
+					return err
+				}
+				logger.Info("differential flame graph is rendered", "path", diffConfig.flameGraph)
+			}
+
+			functions := diff.Compare(before, after)
+			if diffConfig.top > 0 && len(functions) > diffConfig.top {
+				functions = functions[:diffConfig.top]
+			}
+			return printDiff(cmd.OutOrStdout(), functions)
+		},
+	}
+	cmd.Flags().IntVar(&diffConfig.top, "top", 20, "Number of the functions to show. All the changed functions are shown if 0")
+	cmd.Flags().StringVar(&diffConfig.flameGraph, "flamegraph", "", "Path to which the differential flame graph is rendered in SVG")
+
+	return cmd
+}
+
+// printDiff prints the changes of the shares of the functions as a table.
+func printDiff(w io.Writer, functions []diff.Function) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SELF BEFORE\tSELF AFTER\tSELF DELTA\tTOTAL BEFORE\tTOTAL AFTER\tTOTAL DELTA\tFUNCTION")
+	for _, f := range functions {
+		fmt.Fprintf(tw, "%.2f%%\t%.2f%%\t%+.2f%%\t%.2f%%\t%.2f%%\t%+.2f%%\t%s\n",
+			f.SelfBefore*100, f.SelfAfter*100, f.SelfDelta()*100,
+			f.TotalBefore*100, f.TotalAfter*100, f.TotalDelta()*100, f.Name)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/spf13/cobra"
+)
+
+var jobConfig struct {
+	flamegraph  bool
+	keepPartial bool
+}
+
+func NewProfileStartCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "start PODNAME",
+		Short:             "Start a profiling job in the background",
+		Long:              "Start a profiling job in the background and print its job ID",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: validArgsCompletionFunc,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			podName := args[0]
+			logger := newLogger()
+
+			ctx := context.Background()
+			client, containerIDs, err := connectForProfile(ctx, logger, podName)
+			if err != nil {
+				return err
+			}
+
+			jobID, err := client.StartProfile(ctx, containerIDs)
+			if err != nil {
+				return err
+			}
+			logger.Info("profiling job is started", "jobID", jobID)
+			fmt.Fprintln(cmd.OutOrStdout(), jobID)
+
+			return nil
+		},
+	}
+	addProfileFlags(cmd)
+
+	return cmd
+}
+
+func NewProfileStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "status PODNAME JOBID",
+		Short:             "Show the status of a profiling job",
+		Long:              "Show the status of a profiling job",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: validArgsCompletionFunc,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			logger := newLogger()
+
+			ctx := context.Background()
+			client, _, _, err := connect(ctx, logger, args[0])
+			if err != nil {
+				return err
+			}
+
+			st, err := client.GetProfileStatus(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			printStatus(cmd, st)
+
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func NewProfileFetchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "fetch PODNAME JOBID",
+		Short:             "Fetch the result of a profiling job",
+		Long:              "Fetch the result of a profiling job. If the result has been partially fetched, fetching is resumed",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: validArgsCompletionFunc,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			podName, jobID := args[0], args[1]
+			logger := newLogger()
+
+			ctx := context.Background()
+			client, _, _, err := connect(ctx, logger, podName)
+			if err != nil {
+				return err
+			}
+			if err := setStreamOptions(client); err != nil {
+				return err
+			}
+
+			name := podName + "-" + jobID
+			path, err := client.FetchProfile(ctx, jobID, config.outputDir, name)
+			if err != nil {
+				return err
+			}
+			logger.Info("fetch is finished", "output directory", path)
+
+			if client.OutputFormat == rpc.OutputFormat_OUTPUT_FORMAT_RAW {
+				if _, err := extractArchive(logger, client, config.outputDir, name); err != nil {
+					return err
+				}
+			}
+
+			if jobConfig.flamegraph {
+				svgPath, err := client.SaveFlameGraph(config.outputDir, name)
+				if err != nil {
+					return err
+				}
+				logger.Info("flame graph is rendered", "path", svgPath)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&jobConfig.flamegraph, "flamegraph", false, "Render a flame graph from the fetched folded stacks")
+	addStreamFlags(cmd)
+
+	return cmd
+}
+
+func NewProfileCancelCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "cancel PODNAME JOBID",
+		Short:             "Cancel a profiling job",
+		Long:              "Cancel a profiling job. With --keep-partial, the job stops recording and the data recorded so far can be fetched",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: validArgsCompletionFunc,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			logger := newLogger()
+
+			ctx := context.Background()
+			client, _, _, err := connect(ctx, logger, args[0])
+			if err != nil {
+				return err
+			}
+
+			st, err := client.CancelProfile(ctx, args[1], jobConfig.keepPartial)
+			if err != nil {
+				return err
+			}
+			printStatus(cmd, st)
+
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&jobConfig.keepPartial, "keep-partial", false, "Stop recording and keep the data recorded so far")
+
+	return cmd
+}
+
+func printStatus(cmd *cobra.Command, st *rpc.ProfileStatus) {
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "Job ID:      %s\n", st.GetJobId())
+	fmt.Fprintf(w, "Phase:       %s\n", strings.TrimPrefix(st.GetPhase().String(), "PROFILE_PHASE_"))
+	if st.GetQueuePosition() != 0 {
+		fmt.Fprintf(w, "Queue:       %d\n", st.GetQueuePosition())
+	}
+	fmt.Fprintf(w, "Progress:    %.0f%%\n", st.GetProgress()*100)
+	fmt.Fprintf(w, "Samples:     %d\n", st.GetSamples())
+	fmt.Fprintf(w, "Type:        %s\n", strings.TrimPrefix(st.GetProfileType().String(), "PROFILE_TYPE_"))
+	fmt.Fprintf(w, "Format:      %s\n", strings.TrimPrefix(st.GetOutputFormat().String(), "OUTPUT_FORMAT_"))
+	fmt.Fprintf(w, "Created:     %s\n", st.GetCreateTime().AsTime().Local().Format(time.RFC3339))
+	if st.GetFinishTime() != nil {
+		fmt.Fprintf(w, "Finished:    %s\n", st.GetFinishTime().AsTime().Local().Format(time.RFC3339))
+		fmt.Fprintf(w, "Expires:     %s\n", st.GetExpireTime().AsTime().Local().Format(time.RFC3339))
+	}
+	if st.GetPhase() == rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED {
+		fmt.Fprintf(w, "Size:        %d bytes\n", st.GetSize())
+	}
+	if len(st.GetMessage()) != 0 {
+		fmt.Fprintf(w, "Message:     %s\n", st.GetMessage())
+	}
+}
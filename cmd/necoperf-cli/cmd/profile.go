@@ -2,13 +2,21 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/cybozu-go/necoperf/internal/client"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/cybozu-go/necoperf/internal/tlsconfig"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	k8sConfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 var config struct {
@@ -16,73 +24,326 @@ var config struct {
 	podName       string
 	outputDir     string
 	containerName string
+	allContainers bool
 	necoperfNS    string
 	timeout       time.Duration
+	format        string
+	profileType   string
+	compression   string
+	chunkSize     uint32
+
+	selector       string
+	deployment     string
+	statefulSet    string
+	maxConcurrency int
+	merge          bool
+
+	caFile        string
+	certFile      string
+	keyFile       string
+	tlsServerName string
+	tokenFile     string
+	sendToken     bool
+
+	frequency      uint32
+	callGraph      string
+	dwarfStackSize uint32
+	systemWide     bool
+	cgroup         bool
 }
 
 func NewProfileCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:               "profile",
+		Use:               "profile [PODNAME]",
 		Short:             "Perform CPU profiling on the target container",
-		Long:              "Perform CPU profiling on the target container",
-		Args:              cobra.ExactArgs(1),
+		Long:              "Perform CPU profiling on the target container, or on the containers of all the selected pods",
+		Args:              profileArgs,
 		ValidArgsFunction: validArgsCompletionFunc,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
-			config.podName = args[0]
-			handler := slog.NewTextHandler(os.Stderr, nil)
-			logger := slog.New(handler)
+			logger := newLogger()
 
-			client, err := client.New(logger, config.timeout)
-			if err != nil {
-				return err
-			}
-			ds, err := client.SetupDiscovery()
-			if err != nil {
-				return err
+			ctx := context.Background()
+			if len(args) == 0 {
+				return profileReplicas(ctx, cmd, logger)
 			}
+			config.podName = args[0]
 
-			ctx := context.Background()
-			pod, err := ds.GetPod(ctx, config.namespace, config.podName)
+			client, containerIDs, err := connectForProfile(ctx, logger, config.podName)
 			if err != nil {
 				return err
 			}
-			containerID, err := ds.GetContainerID(pod, config.containerName)
-			if err != nil {
+			if err := setStreamOptions(client); err != nil {
 				return err
 			}
-			logger.Info("get container id", "podName", config.podName, "containerID", containerID)
 
-			pods, err := ds.GetPodList(ctx, config.necoperfNS)
-			if err != nil {
-				return err
-			}
-			addr, err := ds.DiscoveryServerAddr(pods, pod.Status.HostIP)
+			printer := newProgressPrinter("")
+			client.Progress = printer.print
+			err = client.Profile(ctx, config.podName, containerIDs, config.outputDir)
+			printer.done()
 			if err != nil {
 				return err
 			}
-			err = client.SetupGrpcClient(addr)
-			if err != nil {
-				return err
+			logger.Info("profile is finished", "output directory", client.OutputPath(config.outputDir, config.podName))
+
+			if client.OutputFormat == rpc.OutputFormat_OUTPUT_FORMAT_RAW {
+				if _, err := extractArchive(logger, client, config.outputDir, config.podName); err != nil {
+					return err
+				}
 			}
-			logger.Info("connect grpc server", "addr", addr)
 
-			err = client.Profile(ctx, config.podName, containerID, config.outputDir)
-			if err != nil {
-				return err
+			if config.format == "flamegraph" {
+				svgPath, err := client.SaveFlameGraph(config.outputDir, config.podName)
+				if err != nil {
+					return err
+				}
+				logger.Info("flame graph is rendered", "path", svgPath)
 			}
-			logger.Info("profile is finished", "output directory", filepath.Join(config.outputDir, config.podName+".script"))
 
 			return nil
 		},
 	}
-	cmd.Flags().StringVar(&config.necoperfNS, "necoperf-namespace", "necoperf", "Namespace in which necoperf-daemon is running")
-	cmd.Flags().StringVarP(&config.namespace, "namespace", "n", "default", "Namespace in pod being profiled is running")
-	cmd.Flags().StringVarP(&config.containerName, "container", "c", "", "Specify the container name to profile")
-	cmd.Flags().DurationVar(&config.timeout, "timeout", 30*time.Second, "Time to run cpu profiling on server")
-	cmd.Flags().StringVar(&config.outputDir, "output-dir", "/tmp", "Directory to output profiling result")
+	cmd.PersistentFlags().StringVar(&config.outputDir, "output-dir", "/tmp", "Directory to output profiling result")
+	addConnectionFlags(cmd, cmd.PersistentFlags())
+	addProfileFlags(cmd)
+	addStreamFlags(cmd)
+	addReplicaFlags(cmd)
+
+	cmd.AddCommand(NewProfileStartCommand())
+	cmd.AddCommand(NewProfileStatusCommand())
+	cmd.AddCommand(NewProfileFetchCommand())
+	cmd.AddCommand(NewProfileCancelCommand())
+
+	return cmd
+}
+
+// addConnectionFlags adds the flags to find the target pod and to connect to necoperf-daemon to flags of cmd.
+func addConnectionFlags(cmd *cobra.Command, flags *pflag.FlagSet) {
+	flags.StringVar(&config.necoperfNS, "necoperf-namespace", "necoperf", "Namespace in which necoperf-daemon is running")
+	flags.StringVarP(&config.namespace, "namespace", "n", "default", "Namespace in pod being profiled is running")
+	flags.StringVar(&config.caFile, "ca", "", "Path to the CA certificate to verify necoperf-daemon. If set, the connection is made over TLS")
+	flags.StringVar(&config.certFile, "cert", "", "Path to the client certificate for mutual TLS")
+	flags.StringVar(&config.keyFile, "key", "", "Path to the private key of the client certificate")
+	flags.StringVar(&config.tlsServerName, "tls-server-name", "", "Server name to verify the certificate of necoperf-daemon instead of its address")
+	flags.StringVar(&config.tokenFile, "token-file", "", "Path to the bearer token sent to necoperf-daemon over TLS")
+	flags.BoolVar(&config.sendToken, "send-token", false, "Send the bearer token of kubeconfig to necoperf-daemon verified by --ca")
 	cmd.RegisterFlagCompletionFunc("namespace", namespaceCompletionFunc)
+}
+
+// addContainerFlags adds the flags to specify the target containers in the pod.
+func addContainerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&config.containerName, "container", "c", "", "Specify the container name to profile")
+	cmd.Flags().BoolVar(&config.allContainers, "all-containers", false, "Profile all the running containers in the pod at the same time")
+	cmd.MarkFlagsMutuallyExclusive("container", "all-containers")
 	cmd.RegisterFlagCompletionFunc("container", containerCompletionFunc)
+}
 
-	return cmd
+// addProfileFlags adds the flags to specify the target container and how to profile it.
+func addProfileFlags(cmd *cobra.Command) {
+	addContainerFlags(cmd)
+	cmd.Flags().DurationVar(&config.timeout, "timeout", 30*time.Second, "Time to run cpu profiling on server")
+	cmd.Flags().StringVar(&config.format, "format", "script", "Output format of profiling result (script, pprof, folded, flamegraph or raw)")
+	cmd.Flags().StringVar(&config.profileType, "type", "cpu", "Type of the profile (cpu or off-cpu). off-cpu profiles the time for which the threads are blocked")
+	cmd.Flags().Uint32Var(&config.frequency, "frequency", resource.DefaultFrequency, "Sampling frequency in Hz")
+	cmd.Flags().StringVar(&config.callGraph, "call-graph", resource.DefaultCallGraph, "Call graph recording method (fp, dwarf or lbr)")
+	cmd.Flags().Uint32Var(&config.dwarfStackSize, "dwarf-stack-size", 0, "Stack dump size in bytes for the dwarf call graph (default 8192)")
+	cmd.Flags().BoolVar(&config.systemWide, "system-wide", true, "Collect samples from all CPUs")
+	cmd.Flags().BoolVar(&config.cgroup, "cgroup", false, "Profile all the processes in the cgroup of the container, not only its init process")
+	cmd.RegisterFlagCompletionFunc("format", formatCompletionFunc)
+	cmd.RegisterFlagCompletionFunc("type", profileTypeCompletionFunc)
+}
+
+// addStreamFlags adds the flags to specify how to receive the profiling result.
+func addStreamFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.compression, "compression", "zstd", "Compression of the profiling result on the wire (none, gzip or zstd)")
+	cmd.Flags().Uint32Var(&config.chunkSize, "chunk-size", 0, "Maximum size in bytes of each message of the profiling result (default 65536)")
+	cmd.RegisterFlagCompletionFunc("compression", compressionCompletionFunc)
+}
+
+// setStreamOptions sets the options specified by the flags added by addStreamFlags to the client.
+func setStreamOptions(c *client.Client) error {
+	compression, err := client.ParseCompression(config.compression)
+	if err != nil {
+		return err
+	}
+	c.Compression = compression
+	c.ChunkSize = config.chunkSize
+	return nil
+}
+
+func newLogger() *slog.Logger {
+	handler := slog.NewTextHandler(os.Stderr, nil)
+	return slog.New(handler)
+}
+
+// newClient creates a client with the TLS options specified by the flags.
+func newClient(logger *slog.Logger) (*client.Client, error) {
+	c, err := client.New(logger, config.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.caFile) != 0 || len(config.certFile) != 0 || len(config.keyFile) != 0 || len(config.tlsServerName) != 0 {
+		tlsConfig, err := tlsconfig.ClientConfig(config.caFile, config.certFile, config.keyFile, config.tlsServerName)
+		if err != nil {
+			return nil, err
+		}
+		c.TLSConfig = tlsConfig
+	}
+
+	// The tokens are sent only when requested, since necoperf-daemon is chosen from the pod list.
+	switch {
+	case len(config.tokenFile) != 0 && config.sendToken:
+		return nil, errors.New("--token-file and --send-token cannot be used together")
+	case len(config.tokenFile) != 0 && c.TLSConfig == nil:
+		return nil, errors.New("--token-file requires TLS")
+	case len(config.tokenFile) != 0:
+		c.TokenFile = config.tokenFile
+	case config.sendToken && len(config.caFile) == 0:
+		return nil, errors.New("--send-token requires --ca")
+	case config.sendToken:
+		// Send the token of kubeconfig so that necoperf-daemon can authorize the user.
+		cfg, err := k8sConfig.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		c.Token = cfg.BearerToken
+		c.TokenFile = cfg.BearerTokenFile
+	}
+	return c, nil
+}
+
+// connect connects to necoperf-daemon running on the same node as the pod.
+func connect(ctx context.Context, logger *slog.Logger, podName string) (*client.Client, *resource.Discovery, *corev1.Pod, error) {
+	client, err := newClient(logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ds, err := client.SetupDiscovery()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pod, err := ds.GetPod(ctx, config.namespace, podName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pods, err := ds.GetPodList(ctx, config.necoperfNS)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := connectDaemon(logger, client, ds, pods, pod); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return client, ds, pod, nil
+}
+
+// connectDaemon sets up the client to connect to the daemon in daemons running on the same node as the pod.
+func connectDaemon(logger *slog.Logger, client *client.Client, ds *resource.Discovery, daemons *corev1.PodList, pod *corev1.Pod) error {
+	addr, err := ds.DiscoveryServerAddr(daemons, pod.Status.HostIP)
+	if err != nil {
+		return err
+	}
+	err = client.SetupGrpcClient(addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("connect grpc server", "addr", addr)
+
+	return nil
+}
+
+// connectForProfile connects to necoperf-daemon and sets up the client with the profiling options.
+// It returns the IDs of the containers to profile.
+func connectForProfile(ctx context.Context, logger *slog.Logger, podName string) (*client.Client, []string, error) {
+	format, err := parseOutputFormat(config.format)
+	if err != nil {
+		return nil, nil, err
+	}
+	profileType, err := parseProfileType(config.profileType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, ds, pod, err := connect(ctx, logger, podName)
+	if err != nil {
+		return nil, nil, err
+	}
+	setProfileOptions(client, format, profileType)
+
+	containerIDs, err := targetContainerIDs(logger, ds, pod)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, containerIDs, nil
+}
+
+// extractArchive extracts the raw profile saved in dataDir as name, and shows how to read it by perf report.
+func extractArchive(logger *slog.Logger, c *client.Client, dataDir, name string) (string, error) {
+	dir, err := c.ExtractArchive(dataDir, name)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("raw profile is extracted", "path", dir, "report", client.ReportCommand(dir))
+	return dir, nil
+}
+
+// setProfileOptions sets the options specified by the flags added by addProfileFlags to the client.
+func setProfileOptions(c *client.Client, format rpc.OutputFormat, profileType rpc.ProfileType) {
+	c.OutputFormat = format
+	c.ProfileType = profileType
+	c.Cgroup = config.cgroup
+	c.RecordOptions = &rpc.PerfRecordOptions{
+		Frequency:      config.frequency,
+		CallGraph:      config.callGraph,
+		DwarfStackSize: config.dwarfStackSize,
+		SystemWide:     proto.Bool(config.systemWide),
+	}
+}
+
+// targetContainerIDs returns the IDs of the containers in the pod specified by the flags.
+func targetContainerIDs(logger *slog.Logger, ds *resource.Discovery, pod *corev1.Pod) ([]string, error) {
+	if config.allContainers {
+		containerIDs, err := ds.GetContainerIDs(pod)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("get container ids", "podName", pod.Name, "containerIDs", containerIDs)
+		return containerIDs, nil
+	}
+
+	containerID, err := ds.GetContainerID(pod, config.containerName)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("get container id", "podName", pod.Name, "containerID", containerID)
+
+	return []string{containerID}, nil
+}
+
+func parseOutputFormat(format string) (rpc.OutputFormat, error) {
+	switch format {
+	case "script":
+		return rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT, nil
+	case "pprof":
+		return rpc.OutputFormat_OUTPUT_FORMAT_PPROF, nil
+	case "folded", "flamegraph":
+		return rpc.OutputFormat_OUTPUT_FORMAT_FOLDED, nil
+	case "raw":
+		return rpc.OutputFormat_OUTPUT_FORMAT_RAW, nil
+	}
+	return 0, fmt.Errorf("unknown output format %q", format)
+}
+
+func parseProfileType(profileType string) (rpc.ProfileType, error) {
+	switch profileType {
+	case "cpu":
+		return rpc.ProfileType_PROFILE_TYPE_CPU, nil
+	case "off-cpu":
+		return rpc.ProfileType_PROFILE_TYPE_OFF_CPU, nil
+	}
+	return 0, fmt.Errorf("unknown profile type %q", profileType)
 }
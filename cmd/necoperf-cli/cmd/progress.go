@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+)
+
+const progressBarWidth = 30
+
+// progressPrinter renders the progress events sent by necoperf-daemon.
+// On a terminal, it keeps rewriting one status line with a progress bar.
+// Otherwise, it prints a line only when the phase or the queue position changes.
+type progressPrinter struct {
+	w        io.Writer
+	prefix   string
+	terminal bool
+	// last is the line printed last in the line mode.
+	last string
+	// active is true while the status line is shown on the terminal.
+	active bool
+}
+
+// newProgressPrinter returns progressPrinter writing to stderr.
+// The prefix is printed at the head of each line to tell the pods apart.
+// Since the status line cannot be shared by the pods profiled concurrently, the line mode is used if the prefix is set.
+func newProgressPrinter(prefix string) *progressPrinter {
+	terminal := false
+	if fi, err := os.Stderr.Stat(); err == nil && len(prefix) == 0 {
+		terminal = fi.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressPrinter{
+		w:        os.Stderr,
+		prefix:   prefix,
+		terminal: terminal,
+	}
+}
+
+func (p *progressPrinter) print(event *rpc.PerfProfileResponse) {
+	var line, status string
+	switch e := event.GetEvent().(type) {
+	case *rpc.PerfProfileResponse_Queued:
+		line = fmt.Sprintf("queued: position %d", e.Queued.GetPosition())
+		status = line
+	case *rpc.PerfProfileResponse_Recording:
+		r := e.Recording
+		line = "recording"
+		status = fmt.Sprintf("recording %s %s/%s %d samples",
+			progressBar(r.GetElapsed().AsDuration(), r.GetTimeout().AsDuration()),
+			r.GetElapsed().AsDuration().Round(time.Second), r.GetTimeout().AsDuration(), r.GetSamples())
+	case *rpc.PerfProfileResponse_Converting:
+		line = fmt.Sprintf("converting %d samples", e.Converting.GetSamples())
+		status = line
+	default:
+		return
+	}
+
+	if p.terminal {
+		// Clear the line before rewriting it, since the new status may be shorter.
+		fmt.Fprintf(p.w, "\r\033[K%s", status)
+		p.active = true
+		return
+	}
+	if line != p.last {
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, line)
+		p.last = line
+	}
+}
+
+// done ends the status line so that the following output starts at a new line.
+func (p *progressPrinter) done() {
+	if p.active {
+		fmt.Fprintln(p.w)
+		p.active = false
+	}
+}
+
+// progressBar renders the ratio of elapsed to total.
+func progressBar(elapsed, total time.Duration) string {
+	filled := progressBarWidth
+	if total > 0 && elapsed < total {
+		filled = int(int64(progressBarWidth) * int64(elapsed) / int64(total))
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
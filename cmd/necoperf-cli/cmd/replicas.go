@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/cybozu-go/necoperf/internal/client"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const defaultMergedName = "merged"
+
+// replicaResult is the result of profiling a pod selected by the flags.
+type replicaResult struct {
+	pod  string
+	node string
+	path string
+	err  error
+}
+
+// addReplicaFlags adds the flags to select multiple pods to profile.
+func addReplicaFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&config.selector, "selector", "l", "", "Profile all the running pods matching the label selector")
+	cmd.Flags().StringVar(&config.deployment, "deployment", "", "Profile all the running pods of the Deployment")
+	cmd.Flags().StringVar(&config.statefulSet, "statefulset", "", "Profile all the running pods of the StatefulSet")
+	cmd.Flags().IntVar(&config.maxConcurrency, "max-concurrency", 4, "Maximum number of pods profiled at the same time")
+	cmd.Flags().BoolVar(&config.merge, "merge", false, "Merge the results of all the pods into one profile (pprof, folded or flamegraph format only)")
+	cmd.MarkFlagsMutuallyExclusive("selector", "deployment", "statefulset")
+}
+
+// profileArgs validates that either PODNAME or one of the flags to select pods is specified.
+func profileArgs(cmd *cobra.Command, args []string) error {
+	selected := len(config.selector) != 0 || len(config.deployment) != 0 || len(config.statefulSet) != 0
+	switch {
+	case selected && len(args) != 0:
+		return errors.New("PODNAME cannot be specified with --selector, --deployment or --statefulset")
+	case !selected && len(args) != 1:
+		return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
+	case !selected && config.merge:
+		return errors.New("--merge requires --selector, --deployment or --statefulset")
+	}
+	return nil
+}
+
+// replicaSelector returns the selector of the pods specified by the flags,
+// and the name of the merged result.
+func replicaSelector(ctx context.Context, ds *resource.Discovery) (labels.Selector, string, error) {
+	switch {
+	case len(config.deployment) != 0:
+		selector, err := ds.GetWorkloadSelector(ctx, config.namespace, "Deployment", config.deployment)
+		return selector, config.deployment, err
+	case len(config.statefulSet) != 0:
+		selector, err := ds.GetWorkloadSelector(ctx, config.namespace, "StatefulSet", config.statefulSet)
+		return selector, config.statefulSet, err
+	}
+
+	selector, err := labels.Parse(config.selector)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid selector %q: %w", config.selector, err)
+	}
+	return selector, defaultMergedName, nil
+}
+
+// profileReplicas profiles the selected pods concurrently, each through necoperf-daemon on its node.
+// The result of each pod is saved to a directory named after the pod.
+func profileReplicas(ctx context.Context, cmd *cobra.Command, logger *slog.Logger) error {
+	format, err := parseOutputFormat(config.format)
+	if err != nil {
+		return err
+	}
+	profileType, err := parseProfileType(config.profileType)
+	if err != nil {
+		return err
+	}
+	if config.merge && (format == rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT || format == rpc.OutputFormat_OUTPUT_FORMAT_RAW) {
+		return errors.New("--merge requires pprof, folded or flamegraph format")
+	}
+	if config.maxConcurrency < 1 {
+		return fmt.Errorf("--max-concurrency must be positive: %d", config.maxConcurrency)
+	}
+	if _, err := client.ParseCompression(config.compression); err != nil {
+		return err
+	}
+
+	c, err := client.New(logger, config.timeout)
+	if err != nil {
+		return err
+	}
+	setProfileOptions(c, format, profileType)
+	ds, err := c.SetupDiscovery()
+	if err != nil {
+		return err
+	}
+
+	selector, mergedName, err := replicaSelector(ctx, ds)
+	if err != nil {
+		return err
+	}
+	pods, err := ds.ListRunningPods(ctx, config.namespace, selector)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return fmt.Errorf("no running pod matches %q in namespace %q", selector.String(), config.namespace)
+	}
+	daemons, err := ds.GetPodList(ctx, config.necoperfNS)
+	if err != nil {
+		return err
+	}
+	logger.Info("profile pods", "selector", selector.String(), "pods", len(pods))
+
+	results := make([]replicaResult, len(pods))
+	var g errgroup.Group
+	g.SetLimit(config.maxConcurrency)
+	for i := range pods {
+		g.Go(func() error {
+			results[i] = profileReplica(ctx, logger.With("podName", pods[i].Name), ds, daemons, &pods[i], format, profileType)
+			return nil
+		})
+	}
+	g.Wait()
+
+	var paths []string
+	for _, r := range results {
+		if r.err == nil {
+			paths = append(paths, r.path)
+		}
+	}
+	printReplicaResults(cmd, results)
+
+	if config.merge && len(paths) != 0 {
+		path, err := c.MergeProfiles(paths, config.outputDir, mergedName)
+		if err != nil {
+			return err
+		}
+		logger.Info("profiles are merged", "path", path, "pods", len(paths))
+
+		if config.format == "flamegraph" {
+			svgPath, err := c.SaveFlameGraph(config.outputDir, mergedName)
+			if err != nil {
+				return err
+			}
+			logger.Info("flame graph is rendered", "path", svgPath)
+		}
+	}
+
+	if failed := len(results) - len(paths); failed != 0 {
+		return fmt.Errorf("profiling failed on %d of %d pods", failed, len(results))
+	}
+	return nil
+}
+
+// profileReplica profiles the pod and saves the result to the directory named after the pod.
+func profileReplica(ctx context.Context, logger *slog.Logger, ds *resource.Discovery, daemons *corev1.PodList, pod *corev1.Pod, format rpc.OutputFormat, profileType rpc.ProfileType) replicaResult {
+	result := replicaResult{
+		pod:  pod.Name,
+		node: pod.Spec.NodeName,
+	}
+
+	c, err := newClient(logger)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	setProfileOptions(c, format, profileType)
+	if err := setStreamOptions(c); err != nil {
+		result.err = err
+		return result
+	}
+	if err := connectDaemon(logger, c, ds, daemons, pod); err != nil {
+		result.err = err
+		return result
+	}
+	defer c.Close()
+
+	containerIDs, err := targetContainerIDs(logger, ds, pod)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	c.Progress = newProgressPrinter(pod.Name + ": ").print
+	dataDir := filepath.Join(config.outputDir, pod.Name)
+	if err := c.Profile(ctx, pod.Name, containerIDs, dataDir); err != nil {
+		result.err = err
+		return result
+	}
+	result.path = c.OutputPath(dataDir, pod.Name)
+
+	if format == rpc.OutputFormat_OUTPUT_FORMAT_RAW {
+		dir, err := extractArchive(logger, c, dataDir, pod.Name)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		result.path = dir
+	}
+
+	if config.format == "flamegraph" {
+		if _, err := c.SaveFlameGraph(dataDir, pod.Name); err != nil {
+			result.err = err
+			return result
+		}
+	}
+
+	return result
+}
+
+func printReplicaResults(cmd *cobra.Command, results []replicaResult) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tNODE\tRESULT")
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "%s\t%s\tfailed: %v\n", r.pod, r.node, r.err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.pod, r.node, r.path)
+	}
+	w.Flush()
+
+	var succeeded int
+	for _, r := range results {
+		if r.err == nil {
+			succeeded++
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "\n%d succeeded, %d failed\n", succeeded, len(results)-succeeded)
+}
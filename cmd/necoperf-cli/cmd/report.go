@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cybozu-go/necoperf/internal/report"
+	"github.com/spf13/cobra"
+)
+
+var reportConfig struct {
+	top  int
+	json bool
+}
+
+func NewReportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report FILE",
+		Short: "Summarize a profiling result in the script format",
+		Long: `Summarize a profiling result in the script format offline.
+It shows the top functions by the self and the total samples, and the samples per thread, command and DSO.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			r, err := report.Build(f, reportConfig.top)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", args[0], err)
+			}
+
+			if reportConfig.json {
+				data, err := json.MarshalIndent(r, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(data))
+				return nil
+			}
+			return r.Write(cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().IntVar(&reportConfig.top, "top", 10, "Number of the entries to show in each table. All the entries are shown if 0")
+	cmd.Flags().BoolVar(&reportConfig.json, "json", false, "Output the report in JSON")
+
+	return cmd
+}
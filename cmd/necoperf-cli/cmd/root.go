@@ -21,6 +21,9 @@ func NewRootCommand() *cobra.Command {
 func Execute() {
 	rootCmd := NewRootCommand()
 	rootCmd.AddCommand(NewProfileCommand())
+	rootCmd.AddCommand(NewStatCommand())
+	rootCmd.AddCommand(NewDiffCommand())
+	rootCmd.AddCommand(NewReportCommand())
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var statConfig struct {
+	events []string
+	output string
+}
+
+func NewStatCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "stat PODNAME",
+		Short:             "Count hardware and software events of the target container",
+		Long:              "Count hardware and software events of the target container by perf stat, such as instructions per cycle and cache misses",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: validArgsCompletionFunc,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			podName := args[0]
+			logger := newLogger()
+
+			if statConfig.output != "table" && statConfig.output != "json" {
+				return fmt.Errorf("unknown output format %q", statConfig.output)
+			}
+
+			ctx := context.Background()
+			client, ds, pod, err := connect(ctx, logger, podName)
+			if err != nil {
+				return err
+			}
+			containerIDs, err := targetContainerIDs(logger, ds, pod)
+			if err != nil {
+				return err
+			}
+
+			resp, err := client.Stat(ctx, containerIDs, statConfig.events)
+			if err != nil {
+				return err
+			}
+
+			if statConfig.output == "json" {
+				data, err := protojson.MarshalOptions{Multiline: true}.Marshal(resp)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(data))
+				return nil
+			}
+			return printStat(cmd.OutOrStdout(), resp)
+		},
+	}
+	addConnectionFlags(cmd, cmd.Flags())
+	addContainerFlags(cmd)
+	cmd.Flags().DurationVar(&config.timeout, "duration", 10*time.Second, "Time to count the events. The upper limit is 1m")
+	cmd.Flags().StringSliceVarP(&statConfig.events, "events", "e", nil, "Comma separated events to count (default: the events chosen by necoperf-daemon)")
+	cmd.Flags().StringVarP(&statConfig.output, "output", "o", "table", "Output format (table or json)")
+
+	return cmd
+}
+
+// printStat prints the counters as a table like the output of perf stat.
+func printStat(w io.Writer, resp *rpc.StatResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "EVENT\tVALUE\tUNIT\tMETRIC\tRUNNING")
+	for _, c := range resp.GetCounters() {
+		value := strconv.FormatFloat(c.GetValue(), 'f', -1, 64)
+		switch {
+		case !c.GetSupported():
+			value = "<not supported>"
+		case !c.GetCounted():
+			value = "<not counted>"
+		}
+		metric := ""
+		if len(c.GetMetricUnit()) != 0 {
+			metric = fmt.Sprintf("%.2f %s", c.GetMetricValue(), c.GetMetricUnit())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.2f%%\n", c.GetEvent(), value, c.GetUnit(), metric, c.GetRunningRatio()*100)
+	}
+	fmt.Fprintf(tw, "\nCounted for %s\n", resp.GetElapsed().AsDuration().Round(time.Millisecond))
+	return tw.Flush()
+}
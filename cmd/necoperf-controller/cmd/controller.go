@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	necoperfv1alpha1 "github.com/cybozu-go/necoperf/api/v1alpha1"
+	necoperf "github.com/cybozu-go/necoperf/internal/client"
+	"github.com/cybozu-go/necoperf/internal/controller"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/tlsconfig"
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+var (
+	metricsAddr   string
+	probeAddr     string
+	leaderElect   bool
+	necoperfNS    string
+	caFile        string
+	certFile      string
+	keyFile       string
+	tlsServerName string
+	tokenFile     string
+)
+
+func NewControllerCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "start",
+		Short: "Starts the controller of ProfileRequest and ProfileSchedule",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handler := slog.NewTextHandler(os.Stderr, nil)
+			logger := slog.New(handler)
+			ctrl.SetLogger(logr.FromSlogHandler(handler))
+
+			var tlsConfig *tls.Config
+			if len(caFile) != 0 || len(certFile) != 0 || len(keyFile) != 0 || len(tlsServerName) != 0 {
+				c, err := tlsconfig.ClientConfig(caFile, certFile, keyFile, tlsServerName)
+				if err != nil {
+					return err
+				}
+				tlsConfig = c
+			}
+			if len(tokenFile) != 0 && tlsConfig == nil {
+				return errors.New("--token-file requires TLS")
+			}
+
+			cfg, err := ctrl.GetConfig()
+			if err != nil {
+				return err
+			}
+			// Send the token of the service account so that necoperf-daemon can authorize the controller.
+			token := tokenFile
+			if len(token) == 0 && tlsConfig != nil {
+				token = cfg.BearerTokenFile
+			}
+
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				return err
+			}
+			if err := necoperfv1alpha1.AddToScheme(scheme); err != nil {
+				return err
+			}
+
+			mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+				Scheme:                 scheme,
+				Metrics:                metricsserver.Options{BindAddress: metricsAddr},
+				HealthProbeBindAddress: probeAddr,
+				LeaderElection:         leaderElect,
+				LeaderElectionID:       "necoperf-controller",
+			})
+			if err != nil {
+				return err
+			}
+			if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+				return err
+			}
+			if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+				return err
+			}
+
+			// The pods are read without the cache not to watch all the pods in the cluster.
+			k8sClient, err := client.New(cfg, client.Options{Scheme: scheme})
+			if err != nil {
+				return err
+			}
+			ds, err := resource.NewDiscovery(logger, k8sClient)
+			if err != nil {
+				return err
+			}
+
+			r := &controller.ProfileRequestReconciler{
+				Client:            mgr.GetClient(),
+				Logger:            logger,
+				Discovery:         ds,
+				NecoperfNamespace: necoperfNS,
+				NewClient: func(logger *slog.Logger, timeout time.Duration) (*necoperf.Client, error) {
+					c, err := necoperf.New(logger, timeout)
+					if err != nil {
+						return nil, err
+					}
+					c.TLSConfig = tlsConfig
+					c.TokenFile = token
+					return c, nil
+				},
+			}
+			if err := r.SetupWithManager(mgr); err != nil {
+				return err
+			}
+			sr := &controller.ProfileScheduleReconciler{
+				Client:   mgr.GetClient(),
+				Logger:   logger,
+				Recorder: mgr.GetEventRecorderFor("necoperf-controller"),
+			}
+			if err := sr.SetupWithManager(mgr); err != nil {
+				return err
+			}
+
+			return mgr.Start(ctrl.SetupSignalHandler())
+		},
+	}
+	cmd.Flags().StringVar(&metricsAddr, "metrics-bind-address", ":8080", "Address on which the metrics server listens")
+	cmd.Flags().StringVar(&probeAddr, "health-probe-bind-address", ":8081", "Address on which the health probe server listens")
+	cmd.Flags().BoolVar(&leaderElect, "leader-elect", true, "Enable leader election so that only one controller is active")
+	cmd.Flags().StringVar(&necoperfNS, "necoperf-namespace", "necoperf", "Namespace in which necoperf-daemon is running")
+	cmd.Flags().StringVar(&caFile, "ca", "", "Path to the CA certificate to verify necoperf-daemon. If set, the connection is made over TLS")
+	cmd.Flags().StringVar(&certFile, "cert", "", "Path to the client certificate for mutual TLS")
+	cmd.Flags().StringVar(&keyFile, "key", "", "Path to the private key of the client certificate")
+	cmd.Flags().StringVar(&tlsServerName, "tls-server-name", "", "Server name to verify the certificate of necoperf-daemon instead of its address")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "Path to the bearer token sent to necoperf-daemon over TLS (default: the token of the service account)")
+
+	return cmd
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRootCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "necoperf-controller",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	return cmd
+}
+
+func Execute() {
+	rootCmd := NewRootCommand()
+	rootCmd.AddCommand(NewControllerCommand())
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
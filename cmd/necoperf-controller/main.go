@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/cybozu-go/necoperf/cmd/necoperf-controller/cmd"
+)
+
+func main() {
+	cmd.Execute()
+}
@@ -1,19 +1,29 @@
 package cmd
 
 import (
+	"errors"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/cybozu-go/necoperf/internal/constants"
 	"github.com/cybozu-go/necoperf/internal/daemon"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/sink"
+	"github.com/cybozu-go/necoperf/internal/tlsconfig"
 	"github.com/spf13/cobra"
 )
 
 var (
-	port            int
-	runtimeEndpoint string
-	workDir         string
-	metricsPort     int
+	config          daemon.Config
+	tlsCert         string
+	tlsKey          string
+	tlsClientCA     string
+	quotaConfigPath string
+	continuousSink  string
+	continuousApp   string
+	triggerSink     string
+	triggerApp      string
 )
 
 func NewDaemonCommand() *cobra.Command {
@@ -23,7 +33,42 @@ func NewDaemonCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			handler := slog.NewTextHandler(os.Stderr, nil)
 			logger := slog.New(handler)
-			daemon, err := daemon.New(logger, port, metricsPort, runtimeEndpoint, workDir)
+
+			if len(tlsCert) != 0 || len(tlsKey) != 0 {
+				reloader, err := tlsconfig.NewReloader(logger, tlsCert, tlsKey, tlsClientCA)
+				if err != nil {
+					return err
+				}
+				config.TLSConfig = reloader.ServerConfig()
+			} else if len(tlsClientCA) != 0 {
+				return errors.New("--tls-client-ca requires --tls-cert and --tls-key")
+			}
+			if config.Authorization && config.TLSConfig == nil {
+				return errors.New("--authorization requires --tls-cert and --tls-key not to send tokens in plaintext")
+			}
+
+			if len(quotaConfigPath) != 0 {
+				if err := daemon.LoadQuotaConfig(quotaConfigPath, &config.Quota); err != nil {
+					return err
+				}
+			}
+
+			if len(continuousSink) != 0 {
+				s, err := sink.New(continuousSink, continuousApp)
+				if err != nil {
+					return err
+				}
+				config.Continuous.Sink = s
+			}
+			if len(triggerSink) != 0 {
+				s, err := sink.New(triggerSink, triggerApp)
+				if err != nil {
+					return err
+				}
+				config.Trigger.Sink = s
+			}
+
+			daemon, err := daemon.New(logger, config)
 			if err != nil {
 				return err
 			}
@@ -31,10 +76,37 @@ func NewDaemonCommand() *cobra.Command {
 			return daemon.Start()
 		},
 	}
-	cmd.Flags().IntVar(&port, "port", constants.NecoPerfGrpcServerPort, "Port number on which the grpc server runs")
-	cmd.Flags().IntVar(&metricsPort, "metrics-port", constants.NecoPerfMetricsPort, "Port number on which the metrics server runs")
-	cmd.Flags().StringVar(&runtimeEndpoint, "runtime-endpoint", "unix:///run/containerd/containerd.sock", "Container runtime endpoint to connect to")
-	cmd.Flags().StringVar(&workDir, "work-dir", "/var/necoperf", "Directory for storing profiling result")
+	cmd.Flags().IntVar(&config.Port, "port", constants.NecoPerfGrpcServerPort, "Port number on which the grpc server runs")
+	cmd.Flags().IntVar(&config.MetricsPort, "metrics-port", constants.NecoPerfMetricsPort, "Port number on which the metrics server runs")
+	cmd.Flags().StringVar(&config.RuntimeEndpoint, "runtime-endpoint", "unix:///run/containerd/containerd.sock", "Container runtime endpoint to connect to")
+	cmd.Flags().StringVar(&config.WorkDir, "work-dir", "/var/necoperf", "Directory for storing profiling result")
+	cmd.Flags().StringVar(&config.PerfPath, "perf-path", "perf", "Path to the perf binary. If it does not contain a slash, it is searched in PATH")
+	cmd.Flags().StringVar(&config.CgroupRoot, "cgroup-root", resource.DefaultCgroupRoot, "Mount point of the cgroup hierarchy of the host, in which the processes of the containers are listed")
+	cmd.Flags().StringVar(&config.Symbols.Dir, "debuginfo-dir", "", "Directory of the debug files laid out like debuginfod, in which the one of each build ID is at buildid/<build ID>/debuginfo")
+	cmd.Flags().StringVar(&config.Symbols.URL, "debuginfod-url", "", "URL of the debuginfod server from which the debug files are downloaded")
+	cmd.Flags().DurationVar(&config.SymbolRetention, "symbol-retention", 7*24*time.Hour, "Duration to keep the binaries not used for symbol resolution in the build-ID cache (0 means forever)")
+	cmd.Flags().DurationVar(&config.Retention, "retention", 1*time.Hour, "Duration to keep the results of profiling jobs after they finish")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "Path to the server certificate to serve gRPC over TLS. It is reloaded when updated")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "Path to the private key of the server certificate")
+	cmd.Flags().StringVar(&tlsClientCA, "tls-client-ca", "", "Path to the CA certificate to verify client certificates. If set, clients must present a certificate")
+	cmd.Flags().BoolVar(&config.Authorization, "authorization", false, "Authorize the callers with their bearer tokens by TokenReview and SubjectAccessReview")
+	cmd.Flags().IntVar(&config.Quota.MaxSessions, "max-sessions-per-namespace", 0, "Maximum number of concurrent profiling sessions per namespace (0 means unlimited)")
+	cmd.Flags().DurationVar(&config.Quota.ProfileTimePerHour.Duration, "profile-time-per-namespace", 0, "Maximum total recording time per namespace in the last hour (0 means unlimited)")
+	cmd.Flags().IntVar(&config.Quota.MaxQueueLength, "max-queue-length", 0, "Maximum number of profiling sessions waiting for perf (0 means unlimited)")
+	cmd.Flags().DurationVar(&config.Quota.MaxQueueWait.Duration, "max-queue-wait", 0, "Maximum time for a profiling session to wait for perf (0 means unlimited)")
+	cmd.Flags().StringVar(&quotaConfigPath, "quota-config", "", "Path to the YAML file of the quotas. The values in the file override the flags")
+	cmd.Flags().StringVar(&continuousSink, "continuous-sink", "", "Directory or Pyroscope URL to which the profiles of the continuous profiling are sent. If set, the annotated pods are profiled continuously")
+	cmd.Flags().StringVar(&continuousApp, "continuous-app-name", "necoperf", "Application name of the profiles sent to Pyroscope by the continuous profiling")
+	cmd.Flags().DurationVar(&config.Continuous.Interval, "continuous-interval", 1*time.Minute, "Interval between the sampling windows of the continuous profiling")
+	cmd.Flags().DurationVar(&config.Continuous.Duration, "continuous-duration", 10*time.Second, "Length of each sampling window of the continuous profiling")
+	cmd.Flags().IntVar(&config.Continuous.Frequency, "continuous-frequency", 19, "Sampling frequency in Hz of the continuous profiling")
+	cmd.Flags().StringVar(&triggerSink, "trigger-sink", "", "Directory or Pyroscope URL to which the triggered profiles are sent. If set, the pods annotated with a CPU threshold are profiled when exceeding it")
+	cmd.Flags().StringVar(&triggerApp, "trigger-app-name", "necoperf-trigger", "Application name of the profiles sent to Pyroscope by the triggered profiling")
+	cmd.Flags().DurationVar(&config.Trigger.CheckInterval, "trigger-check-interval", 1*time.Second, "Interval between the checks of the CPU usage of the triggered profiling")
+	cmd.Flags().DurationVar(&config.Trigger.Sustain, "trigger-sustain", 10*time.Second, "Duration for which the CPU usage must stay above the threshold to trigger the profiling")
+	cmd.Flags().DurationVar(&config.Trigger.Cooldown, "trigger-cooldown", 10*time.Minute, "Minimum time between the triggered profiling of a container")
+	cmd.Flags().DurationVar(&config.Trigger.Duration, "trigger-duration", 10*time.Second, "Length of each recording of the triggered profiling")
+	cmd.Flags().IntVar(&config.Trigger.Frequency, "trigger-frequency", resource.DefaultFrequency, "Sampling frequency in Hz of the triggered profiling")
 
 	return cmd
 }
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+)
+
+// ExtractArchive extracts the raw profile saved in dataDir as name into the directory of the same name
+// and removes the archive. It returns the path of the directory, which contains perf.data and
+// the build-ID cache for perf report. See ReportCommand.
+// The binaries which necoperf-daemon could not include in the archive are logged.
+func (c *Client) ExtractArchive(dataDir, name string) (string, error) {
+	path := outputPath(dataDir, name, rpc.OutputFormat_OUTPUT_FORMAT_RAW)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	dir := filepath.Join(dataDir, name)
+	if err := extractTar(f, dir); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	if err := c.reportMissing(filepath.Join(dir, constants.ArchiveMissingFileName)); err != nil {
+		return "", err
+	}
+	return dir, os.Remove(path)
+}
+
+// reportMissing logs the binaries listed in the file in the format of perf buildid-list.
+func (c *Client) reportMissing(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		buildID, binary, _ := strings.Cut(line, " ")
+		c.logger.Warn("binary is not included in the raw profile, so its symbols may not be resolved", "buildID", buildID, "path", binary)
+	}
+	return nil
+}
+
+// ReportCommand returns the command line of perf report to read the raw profile extracted into dir.
+func ReportCommand(dir string) string {
+	return fmt.Sprintf("perf --buildid-dir %s report -i %s",
+		filepath.Join(dir, constants.ArchiveBuildIDDirName), filepath.Join(dir, constants.ProfilingFileName))
+}
+
+// extractTar extracts the regular files and the symbolic links in the archive into dir.
+// The entries and the links pointing outside of dir are rejected. The files are opened in dir by os.Root,
+// so that the links extracted earlier cannot lead the later entries outside of dir.
+func extractTar(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path in the archive: %s", hdr.Name)
+		}
+		if err := root.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(root, name, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// The links are relative like the ones in the build-ID cache of perf.
+			target := filepath.Join(filepath.Dir(name), hdr.Linkname)
+			if filepath.IsAbs(hdr.Linkname) || !filepath.IsLocal(target) {
+				return fmt.Errorf("invalid link in the archive: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			root.Remove(name)
+			if err := root.Symlink(hdr.Linkname, name); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry in the archive: %s", hdr.Name)
+		}
+	}
+}
+
+func writeFile(root *os.Root, name string, r io.Reader) error {
+	f, err := root.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tarEntry struct {
+	name     string
+	link     string
+	contents string
+}
+
+func writeTar(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.contents))}
+		if len(e.link) != 0 {
+			hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: e.link, Mode: 0777}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	c := &Client{}
+
+	t.Run("extracted", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTar(t, filepath.Join(dir, "pod.tar"), []tarEntry{
+			{name: "perf.data", contents: "data"},
+			{name: ".debug/.build-id/11/2233", link: "../../usr/bin/app/112233"},
+			{name: ".debug/usr/bin/app/112233/elf", contents: "app"},
+		})
+
+		out, err := c.ExtractArchive(dir, "pod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, filepath.Join(dir, "pod"), out)
+
+		data, err := os.ReadFile(filepath.Join(out, "perf.data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "data", string(data))
+		// The cached file is read through the link like perf does.
+		data, err = os.ReadFile(filepath.Join(out, ".debug/.build-id/11/2233/elf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "app", string(data))
+
+		_, err = os.Stat(filepath.Join(dir, "pod.tar"))
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, "perf --buildid-dir "+out+"/.debug report -i "+out+"/perf.data", ReportCommand(out))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		var logs bytes.Buffer
+		c := &Client{logger: slog.New(slog.NewTextHandler(&logs, nil))}
+		dir := t.TempDir()
+		writeTar(t, filepath.Join(dir, "pod.tar"), []tarEntry{
+			{name: "perf.data", contents: "data"},
+			{name: "missing-buildids", contents: "112233 /usr/bin/app\n445566 /usr/lib/libc.so.6\n"},
+		})
+
+		if _, err := c.ExtractArchive(dir, "pod"); err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, logs.String(), "buildID=112233 path=/usr/bin/app")
+		assert.Contains(t, logs.String(), "buildID=445566 path=/usr/lib/libc.so.6")
+	})
+
+	tests := map[string]struct {
+		entry tarEntry
+		err   string
+	}{
+		"pathOutside": {
+			entry: tarEntry{name: "../perf.data", contents: "data"},
+			err:   "invalid path in the archive: ../perf.data",
+		},
+		"absolutePath": {
+			entry: tarEntry{name: "/etc/passwd", contents: "data"},
+			err:   "invalid path in the archive: /etc/passwd",
+		},
+		"linkOutside": {
+			entry: tarEntry{name: ".debug/.build-id/11/2233", link: "../../../../etc"},
+			err:   "invalid link in the archive: .debug/.build-id/11/2233 -> ../../../../etc",
+		},
+		"absoluteLink": {
+			entry: tarEntry{name: ".debug/.build-id/11/2233", link: "/etc"},
+			err:   "invalid link in the archive: .debug/.build-id/11/2233 -> /etc",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTar(t, filepath.Join(dir, "pod.tar"), []tarEntry{tt.entry})
+
+			_, err := c.ExtractArchive(dir, "pod")
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	t.Run("chainedLinks", func(t *testing.T) {
+		// Each link is inside the directory by its name, but x/l/l2 points to the parent of it on disk.
+		dir := t.TempDir()
+		writeTar(t, filepath.Join(dir, "pod.tar"), []tarEntry{
+			{name: "x/l", link: ".."},
+			{name: "x/l/l2", link: ".."},
+			{name: "x/l/l2/evil", contents: "evil"},
+		})
+
+		_, err := c.ExtractArchive(dir, "pod")
+		assert.Error(t, err)
+		assert.NoFileExists(t, filepath.Join(dir, "evil"))
+	})
+}
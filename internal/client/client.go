@@ -2,16 +2,21 @@ package client
 
 import (
 	"context"
-	"io"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/cybozu-go/necoperf/internal/flamegraph"
+	"github.com/cybozu-go/necoperf/internal/perfscript"
 	"github.com/cybozu-go/necoperf/internal/resource"
 	"github.com/cybozu-go/necoperf/internal/rpc"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -20,9 +25,26 @@ import (
 )
 
 type Client struct {
-	logger  *slog.Logger
-	client  rpc.NecoPerfClient
-	Timeout time.Duration
+	logger        *slog.Logger
+	conn          *grpc.ClientConn
+	client        rpc.NecoPerfClient
+	Timeout       time.Duration
+	RecordOptions *rpc.PerfRecordOptions
+	OutputFormat  rpc.OutputFormat
+	ProfileType   rpc.ProfileType
+	// Cgroup profiles all the processes in the cgroups of the containers instead of only their init processes.
+	Cgroup bool
+	// ChunkSize is the maximum size of data in each response. The default of the server is used when zero.
+	ChunkSize   uint32
+	Compression rpc.Compression
+	// TLSConfig is used to connect to the server over TLS. The connection is not encrypted when nil.
+	TLSConfig *tls.Config
+	// Token is the bearer token sent to the server. TokenFile is used instead if it is not empty.
+	// They are sent only over TLS.
+	Token     string
+	TokenFile string
+	// Progress is called with the progress events sent by the server while profiling, if it is not nil.
+	Progress func(event *rpc.PerfProfileResponse)
 }
 
 // https://github.com/grpc-ecosystem/go-grpc-middleware/blob/main/interceptors/logging/examples/slog/example_test.go
@@ -39,14 +61,68 @@ func New(logger *slog.Logger, timeout time.Duration) (*Client, error) {
 	}, nil
 }
 
+// OutputPath returns the path of the file to which the profiling result is saved.
+func (c *Client) OutputPath(dataDir, name string) string {
+	return outputPath(dataDir, name, c.OutputFormat)
+}
+
+func outputPath(dataDir, name string, format rpc.OutputFormat) string {
+	ext := ".script"
+	switch format {
+	case rpc.OutputFormat_OUTPUT_FORMAT_PPROF:
+		ext = ".pb.gz"
+	case rpc.OutputFormat_OUTPUT_FORMAT_FOLDED:
+		ext = ".folded"
+	case rpc.OutputFormat_OUTPUT_FORMAT_RAW:
+		ext = ".tar"
+	}
+	return filepath.Join(dataDir, name+ext)
+}
+
+// SaveFlameGraph renders the folded stacks saved in dataDir as a flame graph
+// and returns the path of the SVG file.
+func (c *Client) SaveFlameGraph(dataDir, name string) (string, error) {
+	in, err := os.Open(outputPath(dataDir, name, rpc.OutputFormat_OUTPUT_FORMAT_FOLDED))
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	folded, err := perfscript.ParseFolded(in)
+	if err != nil {
+		return "", err
+	}
+
+	svgPath := filepath.Join(dataDir, name+".svg")
+	out, err := os.Create(svgPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	opts := flamegraph.Options{
+		Title: name,
+	}
+	if c.ProfileType == rpc.ProfileType_PROFILE_TYPE_OFF_CPU {
+		opts.Color = flamegraph.ColdColor
+		opts.CountName = "ns"
+	}
+	err = flamegraph.Render(out, folded, opts)
+	if err != nil {
+		os.Remove(svgPath)
+		return "", err
+	}
+
+	return svgPath, nil
+}
+
 func (c *Client) Save(dataDir, podName string) (*os.File, error) {
 	err := os.MkdirAll(dataDir, 0755)
 	if err != nil {
 		return nil, err
 	}
 
-	profileFilePath := filepath.Join(dataDir, podName+".script")
-	f, err := os.Create(profileFilePath)
+	f, err := os.Create(c.OutputPath(dataDir, podName))
 	if err != nil {
 		return nil, err
 	}
@@ -54,12 +130,30 @@ func (c *Client) Save(dataDir, podName string) (*os.File, error) {
 	return f, nil
 }
 
-func (c *Client) Profile(ctx context.Context, podName, containerID, DataDir string) error {
+func (c *Client) newRequest(containerIDs []string) *rpc.PerfProfileRequest {
 	t := durationpb.New(c.Timeout)
 	req := &rpc.PerfProfileRequest{
-		ContainerId: containerID,
-		Timeout:     t,
+		Timeout:       t,
+		RecordOptions: c.RecordOptions,
+		OutputFormat:  c.OutputFormat,
+		ChunkSize:     c.ChunkSize,
+		Compression:   c.Compression,
+		ProfileType:   c.ProfileType,
+		Cgroup:        c.Cgroup,
 	}
+	// Multiple containers are not set to container_id so that
+	// necoperf-daemon which does not support them rejects the request.
+	if len(containerIDs) == 1 {
+		req.ContainerId = containerIDs[0]
+	} else {
+		req.ContainerIds = containerIDs
+	}
+	return req
+}
+
+// Profile profiles the containers in one session and saves the result to DataDir.
+func (c *Client) Profile(ctx context.Context, podName string, containerIDs []string, DataDir string) error {
+	req := c.newRequest(containerIDs)
 
 	stream, err := c.client.Profile(ctx, req)
 	if err != nil {
@@ -72,24 +166,156 @@ func (c *Client) Profile(ctx context.Context, podName, containerID, DataDir stri
 	}
 	defer f.Close()
 
-	for {
-		resp, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
+	if err := receive(stream, f, c.Progress); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// StartProfile starts a profiling job of the containers on the server and returns its ID.
+func (c *Client) StartProfile(ctx context.Context, containerIDs []string) (string, error) {
+	resp, err := c.client.StartProfile(ctx, c.newRequest(containerIDs))
+	if err != nil {
+		return "", err
+	}
+	return resp.GetJobId(), nil
+}
+
+// Stat counts the events of the containers for Timeout.
+// The default events of the server are counted if events is empty.
+func (c *Client) Stat(ctx context.Context, containerIDs []string, events []string) (*rpc.StatResponse, error) {
+	return c.client.Stat(ctx, &rpc.StatRequest{
+		ContainerIds: containerIDs,
+		Duration:     durationpb.New(c.Timeout),
+		Events:       events,
+	})
+}
+
+func (c *Client) GetProfileStatus(ctx context.Context, jobID string) (*rpc.ProfileStatus, error) {
+	return c.client.GetProfileStatus(ctx, &rpc.ProfileJobRequest{
+		JobId: jobID,
+	})
+}
+
+func (c *Client) CancelProfile(ctx context.Context, jobID string, keepPartial bool) (*rpc.ProfileStatus, error) {
+	return c.client.CancelProfile(ctx, &rpc.CancelProfileRequest{
+		JobId:       jobID,
+		KeepPartial: keepPartial,
+	})
+}
+
+// partialFetch identifies the result being downloaded to a partial file.
+type partialFetch struct {
+	JobID string `json:"jobId"`
+	Size  int64  `json:"size"`
+}
+
+// FetchProfile saves the result of the job to dataDir and returns the path of the saved file.
+// The result is downloaded to PATH.part and renamed to PATH when completed.
+// If PATH.part has been partially downloaded for the same result of the same job, fetching is resumed from its end.
+// ProfileType and OutputFormat are set to the ones of the job so that the result is handled accordingly.
+func (c *Client) FetchProfile(ctx context.Context, jobID, dataDir, name string) (string, error) {
+	st, err := c.GetProfileStatus(ctx, jobID)
+	if err != nil {
+		return "", err
+	}
+	if st.GetPhase() != rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED {
+		return "", fmt.Errorf("job %q has not succeeded: %s", jobID, st.GetPhase())
+	}
+	c.ProfileType = st.GetProfileType()
+	c.OutputFormat = st.GetOutputFormat()
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", err
+	}
+	path := outputPath(dataDir, name, st.GetOutputFormat())
+	partPath := path + ".part"
+	infoPath := partPath + ".json"
+	info := partialFetch{JobID: jobID, Size: st.GetSize()}
+
+	f, offset, err := openPartial(partPath, infoPath, info)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if offset > 0 {
+		c.logger.Info("resume fetching", "jobID", jobID, "offset", offset)
+	}
+
+	if offset < info.Size {
+		stream, err := c.client.FetchProfile(ctx, &rpc.FetchProfileRequest{
+			JobId:       jobID,
+			Offset:      offset,
+			ChunkSize:   c.ChunkSize,
+			Compression: c.Compression,
+		})
 		if err != nil {
-			os.Remove(f.Name())
-			return err
+			return "", err
 		}
+		if err := receive(stream, f, nil); err != nil {
+			return "", err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
 
-		_, err = f.Write(resp.Data)
+	fi, err := os.Stat(partPath)
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() != info.Size {
+		// The partial file cannot be resumed since its content is unknown.
+		os.Remove(partPath)
+		os.Remove(infoPath)
+		return "", fmt.Errorf("size of the result of job %q is %d, but %d bytes are received", jobID, info.Size, fi.Size())
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return "", err
+	}
+	os.Remove(infoPath)
+	return path, nil
+}
+
+// openPartial opens the partial file to append the result identified by info, and returns the offset to resume from.
+// The partial file is truncated unless it is of the same result.
+func openPartial(partPath, infoPath string, info partialFetch) (*os.File, int64, error) {
+	var saved partialFetch
+	data, err := os.ReadFile(infoPath)
+	resumable := err == nil && json.Unmarshal(data, &saved) == nil && saved == info
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resumable {
+		data, err := json.Marshal(info)
 		if err != nil {
-			os.Remove(f.Name())
-			return err
+			return nil, 0, err
 		}
+		if err := os.WriteFile(infoPath, data, 0644); err != nil {
+			return nil, 0, err
+		}
+		flag |= os.O_TRUNC
 	}
 
-	return nil
+	f, err := os.OpenFile(partPath, flag, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if fi.Size() > info.Size {
+		// The partial file is broken, so it is downloaded again from the beginning.
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, 0, nil
+	}
+	return f, fi.Size(), nil
 }
 
 func (c *Client) SetupDiscovery() (*resource.Discovery, error) {
@@ -118,9 +344,12 @@ func (c *Client) SetupGrpcClient(addr string) error {
 	opts := []logging.Option{
 		logging.WithLogOnEvents(logging.StartCall, logging.FinishCall),
 	}
+	creds := insecure.NewCredentials()
+	if c.TLSConfig != nil {
+		creds = credentials.NewTLS(c.TLSConfig)
+	}
 
-	conn, err := grpc.NewClient(
-		addr,
+	dialOpts := []grpc.DialOption{
 		grpc.WithChainUnaryInterceptor(
 			logging.UnaryClientInterceptor(InterceptorLogger(c.logger), opts...),
 		),
@@ -128,16 +357,33 @@ func (c *Client) SetupGrpcClient(addr string) error {
 			logging.StreamClientInterceptor(InterceptorLogger(c.logger), opts...),
 		),
 		grpc.WithTransportCredentials(
-			insecure.NewCredentials(),
+			creds,
 		),
 		grpc.WithKeepaliveParams(
 			kp,
 		),
-	)
+	}
+	if len(c.Token) != 0 || len(c.TokenFile) != 0 {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&tokenCredentials{
+			token:     c.Token,
+			tokenFile: c.TokenFile,
+		}))
+	}
+
+	conn, err := grpc.NewClient(addr, dialOpts...)
 	if err != nil {
 		return err
 	}
+	c.conn = conn
 	c.client = rpc.NewNecoPerfClient(conn)
 
 	return nil
 }
+
+// Close closes the connection set up by SetupGrpcClient.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// fakeFetchClient serves the result of a succeeded job.
+type fakeFetchClient struct {
+	rpc.NecoPerfClient
+	jobID string
+	data  []byte
+	// offsets are the offsets requested by FetchProfile.
+	offsets []int64
+}
+
+func (c *fakeFetchClient) GetProfileStatus(ctx context.Context, in *rpc.ProfileJobRequest, opts ...grpc.CallOption) (*rpc.ProfileStatus, error) {
+	return &rpc.ProfileStatus{
+		JobId:        c.jobID,
+		Phase:        rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED,
+		OutputFormat: rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT,
+		Size:         int64(len(c.data)),
+	}, nil
+}
+
+func (c *fakeFetchClient) FetchProfile(ctx context.Context, in *rpc.FetchProfileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[rpc.PerfProfileResponse], error) {
+	c.offsets = append(c.offsets, in.GetOffset())
+	return &fakeStream{chunks: [][]byte{c.data[in.GetOffset():]}}, nil
+}
+
+func newTestFetchClient(t *testing.T, jobID, data string) (*Client, *fakeFetchClient) {
+	t.Helper()
+
+	c, err := New(slog.New(slog.DiscardHandler), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeFetchClient{jobID: jobID, data: []byte(data)}
+	c.client = fake
+	return c, fake
+}
+
+func TestFetchProfile(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// setup prepares the files of app.script in the directory.
+		setup  func(t *testing.T, dir string)
+		offset int64
+	}{
+		"new": {
+			setup: func(t *testing.T, dir string) {},
+		},
+		"resume": {
+			setup: func(t *testing.T, dir string) {
+				writeTestFile(t, filepath.Join(dir, "app.script.part"), "result")
+				writeTestFile(t, filepath.Join(dir, "app.script.part.json"), `{"jobId":"job-1","size":13}`)
+			},
+			offset: 6,
+		},
+		"otherJob": {
+			setup: func(t *testing.T, dir string) {
+				writeTestFile(t, filepath.Join(dir, "app.script.part"), "garbage")
+				writeTestFile(t, filepath.Join(dir, "app.script.part.json"), `{"jobId":"job-0","size":13}`)
+			},
+		},
+		"noInfo": {
+			setup: func(t *testing.T, dir string) {
+				writeTestFile(t, filepath.Join(dir, "app.script.part"), "garbage")
+			},
+		},
+		"leftover": {
+			setup: func(t *testing.T, dir string) {
+				writeTestFile(t, filepath.Join(dir, "app.script"), "old")
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			tt.setup(t, dir)
+			c, fake := newTestFetchClient(t, "job-1", "result of job")
+
+			path, err := c.FetchProfile(context.Background(), "job-1", dir, "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, filepath.Join(dir, "app.script"), path)
+			assert.Equal(t, []int64{tt.offset}, fake.offsets)
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "result of job", string(data))
+			assert.NoFileExists(t, path+".part")
+			assert.NoFileExists(t, path+".part.json")
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
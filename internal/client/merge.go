@@ -0,0 +1,91 @@
+package client
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/google/pprof/profile"
+)
+
+// MergeProfiles merges the profiling results saved at paths into one aggregate result
+// and saves it to dataDir as name. It returns the path of the merged result.
+// Only the pprof and folded formats can be merged.
+func (c *Client) MergeProfiles(paths []string, dataDir, name string) (string, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", err
+	}
+	path := c.OutputPath(dataDir, name)
+
+	switch c.OutputFormat {
+	case rpc.OutputFormat_OUTPUT_FORMAT_PPROF:
+		return path, mergePprof(paths, path)
+	case rpc.OutputFormat_OUTPUT_FORMAT_FOLDED:
+		return path, mergeFolded(paths, path)
+	}
+	return "", fmt.Errorf("output format %s cannot be merged", c.OutputFormat)
+}
+
+func mergePprof(paths []string, outPath string) error {
+	profiles := make([]*profile.Profile, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		p, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		profiles = append(profiles, p)
+	}
+
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := merged.Write(out); err != nil {
+		os.Remove(outPath)
+		return err
+	}
+	return nil
+}
+
+func mergeFolded(paths []string, outPath string) error {
+	merged := make(perfscript.Folded)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		folded, err := perfscript.ParseFolded(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for stack, value := range folded {
+			merged[stack] += value
+		}
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := merged.Write(out); err != nil {
+		os.Remove(outPath)
+		return err
+	}
+	return nil
+}
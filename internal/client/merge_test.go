@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	script, err := os.ReadFile("../perfscript/testdata/cpu-clock.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := perfscript.Parse(bytes.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := perfscript.NewProfileBuilder(99)
+	folded := make(perfscript.Folded)
+	for _, s := range samples {
+		b.Add(s)
+		folded.Add(s, 1)
+	}
+	pprofData := &bytes.Buffer{}
+	if err := b.Profile().Write(pprofData); err != nil {
+		t.Fatal(err)
+	}
+	foldedData := &bytes.Buffer{}
+	if err := folded.Write(foldedData); err != nil {
+		t.Fatal(err)
+	}
+
+	var pprofPaths, foldedPaths []string
+	for _, name := range []string{"pod-a", "pod-b"} {
+		path := filepath.Join(dir, name+".pb.gz")
+		if err := os.WriteFile(path, pprofData.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pprofPaths = append(pprofPaths, path)
+
+		path = filepath.Join(dir, name+".folded")
+		if err := os.WriteFile(path, foldedData.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		foldedPaths = append(foldedPaths, path)
+	}
+
+	t.Run("pprof", func(t *testing.T) {
+		c := &Client{OutputFormat: rpc.OutputFormat_OUTPUT_FORMAT_PPROF}
+		path, err := c.MergeProfiles(pprofPaths, filepath.Join(dir, "merged"), "all")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, filepath.Join(dir, "merged", "all.pb.gz"), path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		p, err := profile.Parse(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var total int64
+		for _, s := range p.Sample {
+			total += s.Value[0]
+		}
+		assert.Equal(t, int64(2*len(samples)), total)
+	})
+
+	t.Run("folded", func(t *testing.T) {
+		c := &Client{OutputFormat: rpc.OutputFormat_OUTPUT_FORMAT_FOLDED}
+		path, err := c.MergeProfiles(foldedPaths, filepath.Join(dir, "merged"), "all")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		merged, err := perfscript.ParseFolded(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 2*folded.Total(), merged.Total())
+	})
+
+	t.Run("script", func(t *testing.T) {
+		c := &Client{OutputFormat: rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT}
+		_, err := c.MergeProfiles(nil, dir, "all")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+)
+
+// streamReader reads the data sent by the stream.
+// The other events are passed to progress if it is not nil.
+type streamReader struct {
+	stream   grpc.ServerStreamingClient[rpc.PerfProfileResponse]
+	progress func(*rpc.PerfProfileResponse)
+	buf      []byte
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		resp, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := resp.GetEvent().(*rpc.PerfProfileResponse_Data); !ok && r.progress != nil {
+			r.progress(resp)
+		}
+		r.buf = resp.GetData()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// receive writes the data sent by the stream to w, and passes the progress events to progress.
+// The data is decompressed according to the compression notified by the header.
+func receive(stream grpc.ServerStreamingClient[rpc.PerfProfileResponse], w io.Writer, progress func(*rpc.PerfProfileResponse)) error {
+	md, err := stream.Header()
+	if err != nil {
+		return err
+	}
+
+	// necoperf-daemon which does not support compression does not notify it.
+	compression := rpc.Compression_COMPRESSION_NONE
+	if v := md.Get(constants.CompressionHeader); len(v) != 0 {
+		c, ok := rpc.Compression_value[v[0]]
+		if !ok {
+			return fmt.Errorf("compression %q is not supported", v[0])
+		}
+		compression = rpc.Compression(c)
+	}
+
+	r, err := decompress(&streamReader{stream: stream, progress: progress}, compression)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func decompress(r io.Reader, compression rpc.Compression) (io.ReadCloser, error) {
+	switch compression {
+	case rpc.Compression_COMPRESSION_NONE:
+		return io.NopCloser(r), nil
+	case rpc.Compression_COMPRESSION_GZIP:
+		return gzip.NewReader(r)
+	case rpc.Compression_COMPRESSION_ZSTD:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("compression %s is not supported", compression)
+}
+
+// ParseCompression returns the compression of the given name.
+func ParseCompression(name string) (rpc.Compression, error) {
+	switch name {
+	case "none":
+		return rpc.Compression_COMPRESSION_NONE, nil
+	case "gzip":
+		return rpc.Compression_COMPRESSION_GZIP, nil
+	case "zstd":
+		return rpc.Compression_COMPRESSION_ZSTD, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", name)
+}
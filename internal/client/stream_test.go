@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeStream struct {
+	grpc.ClientStream
+	header metadata.MD
+	// events are sent before chunks.
+	events []*rpc.PerfProfileResponse
+	chunks [][]byte
+}
+
+func (s *fakeStream) Header() (metadata.MD, error) {
+	return s.header, nil
+}
+
+func (s *fakeStream) Recv() (*rpc.PerfProfileResponse, error) {
+	if len(s.events) != 0 {
+		event := s.events[0]
+		s.events = s.events[1:]
+		return event, nil
+	}
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return &rpc.PerfProfileResponse{
+		Event: &rpc.PerfProfileResponse_Data{Data: chunk},
+	}, nil
+}
+
+func (s *fakeStream) Context() context.Context {
+	return context.Background()
+}
+
+// split splits the data into chunks of the given size.
+func split(data []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
+
+func TestReceive(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("main;foo;bar 1\n"), 1000)
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(data)
+	gw.Close()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := enc.EncodeAll(data, nil)
+	enc.Close()
+
+	tests := map[string]struct {
+		header metadata.MD
+		sent   []byte
+		err    string
+	}{
+		"noHeader": {
+			sent: data,
+		},
+		"none": {
+			header: metadata.Pairs(constants.CompressionHeader, "COMPRESSION_NONE"),
+			sent:   data,
+		},
+		"gzip": {
+			header: metadata.Pairs(constants.CompressionHeader, "COMPRESSION_GZIP"),
+			sent:   gzipped.Bytes(),
+		},
+		"zstd": {
+			header: metadata.Pairs(constants.CompressionHeader, "COMPRESSION_ZSTD"),
+			sent:   zstded,
+		},
+		"unknown": {
+			header: metadata.Pairs(constants.CompressionHeader, "COMPRESSION_UNKNOWN"),
+			sent:   data,
+			err:    `compression "COMPRESSION_UNKNOWN" is not supported`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			stream := &fakeStream{
+				header: tt.header,
+				chunks: split(tt.sent, 100),
+			}
+
+			var buf bytes.Buffer
+			err := receive(stream, &buf, nil)
+			if len(tt.err) != 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, data, buf.Bytes())
+		})
+	}
+}
+
+func TestReceiveProgress(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("main;foo;bar 1\n"), 100)
+	events := []*rpc.PerfProfileResponse{
+		{Event: &rpc.PerfProfileResponse_Queued{Queued: &rpc.ProfileQueued{Position: 1}}},
+		{Event: &rpc.PerfProfileResponse_Recording{Recording: &rpc.ProfileRecording{Samples: 10}}},
+		{Event: &rpc.PerfProfileResponse_Converting{Converting: &rpc.ProfileConverting{Samples: 20}}},
+	}
+	stream := &fakeStream{
+		events: events,
+		chunks: split(data, 100),
+	}
+
+	var received []*rpc.PerfProfileResponse
+	var buf bytes.Buffer
+	err := receive(stream, &buf, func(event *rpc.PerfProfileResponse) {
+		received = append(received, event)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, events, received)
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// tokenCredentials sends the bearer token with each request to be authorized by the server.
+type tokenCredentials struct {
+	token     string
+	tokenFile string
+}
+
+func (t *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token := t.token
+	// The file is read for each request so that the rotated token is used.
+	if len(t.tokenFile) != 0 {
+		data, err := os.ReadFile(t.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	return map[string]string{
+		"authorization": "Bearer " + token,
+	}, nil
+}
+
+// RequireTransportSecurity returns true not to send the token in plaintext.
+func (t *tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
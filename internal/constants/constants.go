@@ -3,15 +3,75 @@ package constants
 const (
 	RecordSubcommand  = "record"
 	ScriptSubcommand  = "script"
+	StatSubcommand    = "stat"
 	ProfilingFileName = "perf.data"
 	ScriptFileName    = "perf.script"
+	ProfileDirName    = "profile"
+	ScriptDirName     = "script"
 	CpuClockEvent     = "cpu-clock:"
 	CyclesEvent       = "cycles:"
 )
 
+// Subcommands of perf and the directory in the work directory for the build-ID cache used to resolve the symbols.
+// ArchiveBuildIDDirName is the directory of the build-ID cache in the raw profile archive, and
+// ArchiveMissingFileName is the file in it listing the binaries with samples which are not in the cache.
+const (
+	BuildIDListSubcommand  = "buildid-list"
+	BuildIDCacheSubcommand = "buildid-cache"
+	BuildIDDirName         = "buildid"
+	ArchiveBuildIDDirName  = ".debug"
+	ArchiveMissingFileName = "missing-buildids"
+)
+
+// Scheduler tracepoints recorded for the off-CPU profile.
+const (
+	SchedSwitchEvent      = "sched:sched_switch"
+	SchedStatSleepEvent   = "sched:sched_stat_sleep"
+	SchedStatBlockedEvent = "sched:sched_stat_blocked"
+	SchedStatIOWaitEvent  = "sched:sched_stat_iowait"
+)
+
+// CompressionHeader is the key of the header metadata in which necoperf-daemon
+// notifies the compression of the streamed data.
+const CompressionHeader = "necoperf-compression"
+
 const (
 	LabelAppName    = "app.kubernetes.io/name"
 	AppNameNecoPerf = "necoperf-daemon"
+
+	AnnotationDefaultContainer = "kubectl.kubernetes.io/default-container"
+
+	// AnnotationContinuousProfiling opts the pods in to the continuous profiling by necoperf-daemon with "true".
+	AnnotationContinuousProfiling = "necoperf.cybozu.com/continuous-profiling"
+
+	// AnnotationCPUThreshold makes necoperf-daemon profile the containers of the pod when the CPU usage of a container
+	// stays above the value in cores, e.g. "1.5" or "500m".
+	AnnotationCPUThreshold = "necoperf.cybozu.com/cpu-threshold"
+
+	// LabelProfileRequest is set to the ConfigMaps storing the results of a ProfileRequest with its name.
+	LabelProfileRequest = "necoperf.cybozu.com/profile-request"
+
+	// LabelProfileSchedule is set to the ProfileRequests created by a ProfileSchedule with its name.
+	LabelProfileSchedule = "necoperf.cybozu.com/profile-schedule"
+
+	// AnnotationScheduleTime is set to the ProfileRequests created by a ProfileSchedule with the scheduled time in RFC 3339.
+	AnnotationScheduleTime = "necoperf.cybozu.com/schedule-time"
+)
+
+// Labels set by kubelet to pod sandboxes and containers of the container runtime.
+// CRILabelContainerName is set only to containers.
+const (
+	CRILabelPodName       = "io.kubernetes.pod.name"
+	CRILabelPodNamespace  = "io.kubernetes.pod.namespace"
+	CRILabelPodUID        = "io.kubernetes.pod.uid"
+	CRILabelContainerName = "io.kubernetes.container.name"
+)
+
+// The callers of the API must be allowed to perform ProfileVerb on pods/ProfileSubresource
+// when necoperf-daemon authorizes them.
+const (
+	ProfileVerb        = "create"
+	ProfileSubresource = "profile"
 )
 
 const (
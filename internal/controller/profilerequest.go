@@ -0,0 +1,452 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	necoperfv1alpha1 "github.com/cybozu-go/necoperf/api/v1alpha1"
+	necoperf "github.com/cybozu-go/necoperf/internal/client"
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// pollInterval is the interval to check the statuses of the running jobs.
+	pollInterval = 5 * time.Second
+
+	// MaxConfigMapDataSize is the maximum size of the result stored in a ConfigMap.
+	// It leaves room for the metadata in the 1MiB limit of objects.
+	MaxConfigMapDataSize = 900 * 1024
+)
+
+// ProfileRequestReconciler profiles the pods selected by ProfileRequests with necoperf-daemon.
+// It starts the profiling jobs on the daemons running on the nodes of the pods,
+// and stores each result in a ConfigMap owned by the ProfileRequest when the job has succeeded.
+type ProfileRequestReconciler struct {
+	client.Client
+	Logger *slog.Logger
+	// Discovery finds the pods and the daemons. It should not use the cache of the manager
+	// not to watch all the pods in the cluster.
+	Discovery *resource.Discovery
+	// NecoperfNamespace is the namespace in which necoperf-daemon is running.
+	NecoperfNamespace string
+	// NewClient creates a client which connects to necoperf-daemon with the options of the controller.
+	NewClient func(logger *slog.Logger, timeout time.Duration) (*necoperf.Client, error)
+}
+
+//+kubebuilder:rbac:groups=necoperf.cybozu.com,resources=profilerequests,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=necoperf.cybozu.com,resources=profilerequests/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=pods/profile,verbs=create
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=create
+
+func (r *ProfileRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pr := &necoperfv1alpha1.ProfileRequest{}
+	if err := r.Get(ctx, req.NamespacedName, pr); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pr.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	switch pr.Status.Phase {
+	case "":
+		return r.start(ctx, pr)
+	case necoperfv1alpha1.ProfilePhaseRunning:
+		return r.poll(ctx, pr)
+	}
+	return ctrl.Result{}, nil
+}
+
+// start starts the profiling jobs of the pods.
+func (r *ProfileRequestReconciler) start(ctx context.Context, pr *necoperfv1alpha1.ProfileRequest) (ctrl.Result, error) {
+	logger := r.Logger.With("namespace", pr.Namespace, "name", pr.Name)
+
+	pods, err := r.targetPods(ctx, pr)
+	if apierrors.IsNotFound(err) {
+		return ctrl.Result{}, r.fail(ctx, pr, err.Error())
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(pods) == 0 {
+		return ctrl.Result{}, r.fail(ctx, pr, "no running pod matches the selector")
+	}
+
+	daemons, err := r.Discovery.GetPodList(ctx, r.NecoperfNamespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The targets are recorded before starting the jobs, so that the jobs are not started again
+	// if the status fails to be updated after they are started. See poll.
+	pr.Status.Targets = make([]necoperfv1alpha1.ProfileTarget, len(pods))
+	for i := range pods {
+		pr.Status.Targets[i] = necoperfv1alpha1.ProfileTarget{
+			Pod:   pods[i].Name,
+			Node:  pods[i].Spec.NodeName,
+			Phase: necoperfv1alpha1.ProfilePhasePending,
+		}
+	}
+	pr.Status.Phase = necoperfv1alpha1.ProfilePhaseRunning
+	if err := r.Status().Update(ctx, pr); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for i := range pods {
+		target := &pr.Status.Targets[i]
+		r.startJob(ctx, pr, daemons, &pods[i], target)
+		if target.Phase == necoperfv1alpha1.ProfilePhaseRunning {
+			logger.Info("profiling job is started", "pod", target.Pod, "daemon", target.Daemon, "jobID", target.JobID)
+		}
+	}
+
+	setStarted(pr)
+	complete(pr)
+	if err := r.Status().Update(ctx, pr); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if pr.Status.Phase != necoperfv1alpha1.ProfilePhaseRunning {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: pr.Spec.Duration.Duration}, nil
+}
+
+// targetPods returns the pods specified by the ProfileRequest.
+func (r *ProfileRequestReconciler) targetPods(ctx context.Context, pr *necoperfv1alpha1.ProfileRequest) ([]corev1.Pod, error) {
+	if len(pr.Spec.PodName) != 0 {
+		pod, err := r.Discovery.GetPod(ctx, pr.Namespace, pr.Spec.PodName)
+		if err != nil {
+			return nil, err
+		}
+		return []corev1.Pod{*pod}, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(pr.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return r.Discovery.ListRunningPods(ctx, pr.Namespace, selector)
+}
+
+// startJob starts the profiling job of the pod on the daemon running on the same node, and updates the target.
+// The target is failed if the job cannot be started.
+func (r *ProfileRequestReconciler) startJob(ctx context.Context, pr *necoperfv1alpha1.ProfileRequest, daemons *corev1.PodList, pod *corev1.Pod, target *necoperfv1alpha1.ProfileTarget) {
+	target.Phase = necoperfv1alpha1.ProfilePhaseFailed
+
+	containerID, err := r.Discovery.GetContainerID(pod, pr.Spec.Container)
+	if err != nil {
+		target.Message = err.Error()
+		return
+	}
+	addr, err := r.Discovery.DiscoveryServerAddr(daemons, pod.Status.HostIP)
+	if err != nil {
+		target.Message = err.Error()
+		return
+	}
+	target.Daemon = addr
+
+	c, err := r.connect(addr, pr.Spec.Duration.Duration)
+	if err != nil {
+		target.Message = err.Error()
+		return
+	}
+	defer c.Close()
+	if err := setProfileOptions(c, &pr.Spec); err != nil {
+		target.Message = err.Error()
+		return
+	}
+
+	jobID, err := c.StartProfile(ctx, []string{containerID})
+	if err != nil {
+		target.Message = err.Error()
+		return
+	}
+	target.JobID = jobID
+	target.Phase = necoperfv1alpha1.ProfilePhaseRunning
+}
+
+// poll checks the statuses of the running jobs and stores the results of the succeeded ones.
+// The targets left pending by start are failed, since their jobs may have been started without recording the IDs.
+func (r *ProfileRequestReconciler) poll(ctx context.Context, pr *necoperfv1alpha1.ProfileRequest) (ctrl.Result, error) {
+	logger := r.Logger.With("namespace", pr.Namespace, "name", pr.Name)
+
+	pending := false
+	for i := range pr.Status.Targets {
+		target := &pr.Status.Targets[i]
+		if target.Phase == necoperfv1alpha1.ProfilePhasePending {
+			target.Phase = necoperfv1alpha1.ProfilePhaseFailed
+			target.Message = "the job was not recorded since the status failed to be updated"
+			pending = true
+		}
+	}
+	if pending {
+		setStarted(pr)
+	}
+
+	var errs []error
+	for i := range pr.Status.Targets {
+		target := &pr.Status.Targets[i]
+		if target.Phase != necoperfv1alpha1.ProfilePhaseRunning {
+			continue
+		}
+		if err := r.pollJob(ctx, pr, target); err != nil {
+			errs = append(errs, fmt.Errorf("failed to check the job of pod %s: %w", target.Pod, err))
+			continue
+		}
+		if target.Phase != necoperfv1alpha1.ProfilePhaseRunning {
+			logger.Info("profiling job is finished", "pod", target.Pod, "jobID", target.JobID, "phase", target.Phase)
+		}
+	}
+
+	complete(pr)
+	if err := r.Status().Update(ctx, pr); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(errs) != 0 {
+		return ctrl.Result{}, errors.Join(errs...)
+	}
+
+	if pr.Status.Phase != necoperfv1alpha1.ProfilePhaseRunning {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: pollInterval}, nil
+}
+
+// pollJob updates the target according to the status of its job.
+// It returns an error only when the status cannot be checked temporarily.
+func (r *ProfileRequestReconciler) pollJob(ctx context.Context, pr *necoperfv1alpha1.ProfileRequest, target *necoperfv1alpha1.ProfileTarget) error {
+	c, err := r.connect(target.Daemon, pr.Spec.Duration.Duration)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	st, err := c.GetProfileStatus(ctx, target.JobID)
+	if status.Code(err) == codes.NotFound {
+		target.Phase = necoperfv1alpha1.ProfilePhaseFailed
+		target.Message = "the job is not found on necoperf-daemon"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch st.GetPhase() {
+	case rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED:
+		target.Size = st.GetSize()
+		if st.GetSize() > MaxConfigMapDataSize {
+			target.Phase = necoperfv1alpha1.ProfilePhaseSucceeded
+			target.Message = fmt.Sprintf("the result is too large to be stored in a ConfigMap; fetch it by necoperf-cli profile fetch -n %s %s %s", pr.Namespace, target.Pod, target.JobID)
+			return nil
+		}
+		name, err := r.storeResult(ctx, c, pr, target)
+		if err != nil {
+			return err
+		}
+		target.Phase = necoperfv1alpha1.ProfilePhaseSucceeded
+		target.ConfigMap = name
+		target.Message = fmt.Sprintf("the result is stored in ConfigMap %s", name)
+	case rpc.ProfilePhase_PROFILE_PHASE_FAILED, rpc.ProfilePhase_PROFILE_PHASE_CANCELLED:
+		target.Phase = necoperfv1alpha1.ProfilePhaseFailed
+		target.Message = st.GetMessage()
+	}
+	return nil
+}
+
+// storeResult fetches the result of the job and stores it in a ConfigMap owned by the ProfileRequest.
+// It returns the name of the ConfigMap.
+func (r *ProfileRequestReconciler) storeResult(ctx context.Context, c *necoperf.Client, pr *necoperfv1alpha1.ProfileRequest, target *necoperfv1alpha1.ProfileTarget) (string, error) {
+	dir, err := os.MkdirTemp("", "necoperf-controller-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	path, err := c.FetchProfile(ctx, target.JobID, dir, target.Pod)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pr.Namespace,
+			Name:      configMapName(pr.Name, target.Pod),
+			Labels: map[string]string{
+				constants.LabelProfileRequest: pr.Name,
+			},
+		},
+		BinaryData: map[string][]byte{
+			filepath.Base(path): data,
+		},
+	}
+	if err := controllerutil.SetControllerReference(pr, cm, r.Scheme()); err != nil {
+		return "", err
+	}
+	// The ConfigMap may have been created before the status failed to be updated.
+	if err := r.Create(ctx, cm); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+
+	return cm.Name, nil
+}
+
+// configMapName returns the name of the ConfigMap for the result of the pod.
+// It is shortened with a hash if it is too long.
+func configMapName(requestName, podName string) string {
+	name := requestName + "-" + podName
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:10]
+	return name[:validation.DNS1123SubdomainMaxLength-len(suffix)-1] + "-" + suffix
+}
+
+// connect creates a client connected to necoperf-daemon at addr.
+func (r *ProfileRequestReconciler) connect(addr string, timeout time.Duration) (*necoperf.Client, error) {
+	c, err := r.NewClient(r.Logger, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetupGrpcClient(addr); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// fail marks the ProfileRequest as failed without starting any job.
+func (r *ProfileRequestReconciler) fail(ctx context.Context, pr *necoperfv1alpha1.ProfileRequest, message string) error {
+	pr.Status.Phase = necoperfv1alpha1.ProfilePhaseFailed
+	meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+		Type:    necoperfv1alpha1.ConditionStarted,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Failed",
+		Message: message,
+	})
+	meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+		Type:    necoperfv1alpha1.ConditionCompleted,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Failed",
+		Message: message,
+	})
+	return r.Status().Update(ctx, pr)
+}
+
+// setStarted sets the Started condition by the number of the targets whose jobs have been started.
+func setStarted(pr *necoperfv1alpha1.ProfileRequest) {
+	started := 0
+	for _, target := range pr.Status.Targets {
+		if len(target.JobID) != 0 {
+			started++
+		}
+	}
+
+	if started == 0 {
+		meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+			Type:    necoperfv1alpha1.ConditionStarted,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Failed",
+			Message: "no profiling job could be started",
+		})
+		return
+	}
+	meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+		Type:    necoperfv1alpha1.ConditionStarted,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Started",
+		Message: fmt.Sprintf("%d of %d profiling jobs are started", started, len(pr.Status.Targets)),
+	})
+}
+
+// complete sets the phase and the Completed condition if all the jobs have finished.
+func complete(pr *necoperfv1alpha1.ProfileRequest) {
+	succeeded := 0
+	for _, target := range pr.Status.Targets {
+		switch target.Phase {
+		case necoperfv1alpha1.ProfilePhaseRunning:
+			return
+		case necoperfv1alpha1.ProfilePhaseSucceeded:
+			succeeded++
+		}
+	}
+
+	message := fmt.Sprintf("%d of %d profiling jobs have succeeded", succeeded, len(pr.Status.Targets))
+	if succeeded == len(pr.Status.Targets) {
+		pr.Status.Phase = necoperfv1alpha1.ProfilePhaseSucceeded
+		meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+			Type:    necoperfv1alpha1.ConditionCompleted,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Succeeded",
+			Message: message,
+		})
+		return
+	}
+	pr.Status.Phase = necoperfv1alpha1.ProfilePhaseFailed
+	meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+		Type:    necoperfv1alpha1.ConditionCompleted,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Failed",
+		Message: message,
+	})
+}
+
+// setProfileOptions sets the options of the ProfileRequest to the client.
+func setProfileOptions(c *necoperf.Client, spec *necoperfv1alpha1.ProfileRequestSpec) error {
+	switch spec.Format {
+	case "", "pprof":
+		c.OutputFormat = rpc.OutputFormat_OUTPUT_FORMAT_PPROF
+	case "folded":
+		c.OutputFormat = rpc.OutputFormat_OUTPUT_FORMAT_FOLDED
+	case "script":
+		c.OutputFormat = rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT
+	default:
+		return fmt.Errorf("unknown output format %q", spec.Format)
+	}
+
+	switch spec.Type {
+	case "", "cpu":
+		c.ProfileType = rpc.ProfileType_PROFILE_TYPE_CPU
+	case "off-cpu":
+		c.ProfileType = rpc.ProfileType_PROFILE_TYPE_OFF_CPU
+	default:
+		return fmt.Errorf("unknown profile type %q", spec.Type)
+	}
+
+	c.RecordOptions = &rpc.PerfRecordOptions{
+		Frequency: uint32(spec.Frequency),
+		CallGraph: spec.CallGraph,
+	}
+	c.Compression = rpc.Compression_COMPRESSION_ZSTD
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ProfileRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&necoperfv1alpha1.ProfileRequest{}).
+		Complete(r)
+}
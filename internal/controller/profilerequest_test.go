@@ -0,0 +1,496 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	necoperfv1alpha1 "github.com/cybozu-go/necoperf/api/v1alpha1"
+	necoperf "github.com/cybozu-go/necoperf/internal/client"
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const (
+	testNamespace = "test"
+	necoperfNS    = "necoperf"
+	hostIP        = "10.0.0.1"
+)
+
+type fakeJob struct {
+	req    *rpc.PerfProfileRequest
+	status *rpc.ProfileStatus
+	data   []byte
+}
+
+// fakeDaemon is a NecoPerf server which keeps the jobs in memory.
+// The ID of each job is the container ID of the request.
+type fakeDaemon struct {
+	rpc.UnimplementedNecoPerfServer
+
+	mu   sync.Mutex
+	jobs map[string]*fakeJob
+	// starts is the number of the calls to StartProfile.
+	starts int
+}
+
+func (d *fakeDaemon) StartProfile(ctx context.Context, req *rpc.PerfProfileRequest) (*rpc.StartProfileResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.starts++
+	id := req.GetContainerId()
+	d.jobs[id] = &fakeJob{
+		req: req,
+		status: &rpc.ProfileStatus{
+			JobId:        id,
+			Phase:        rpc.ProfilePhase_PROFILE_PHASE_RECORDING,
+			OutputFormat: req.GetOutputFormat(),
+			ProfileType:  req.GetProfileType(),
+		},
+	}
+	return &rpc.StartProfileResponse{JobId: id}, nil
+}
+
+func (d *fakeDaemon) GetProfileStatus(ctx context.Context, req *rpc.ProfileJobRequest) (*rpc.ProfileStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	job, ok := d.jobs[req.GetJobId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %q is not found", req.GetJobId())
+	}
+	return job.status, nil
+}
+
+func (d *fakeDaemon) FetchProfile(req *rpc.FetchProfileRequest, stream grpc.ServerStreamingServer[rpc.PerfProfileResponse]) error {
+	d.mu.Lock()
+	job, ok := d.jobs[req.GetJobId()]
+	d.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "job %q is not found", req.GetJobId())
+	}
+
+	// The result is sent without compression as the daemons which do not support it.
+	return stream.Send(&rpc.PerfProfileResponse{
+		Event: &rpc.PerfProfileResponse_Data{Data: job.data[req.GetOffset():]},
+	})
+}
+
+// finish makes the job finish in the phase with the result.
+func (d *fakeDaemon) finish(id string, phase rpc.ProfilePhase, data []byte, size int64, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	job := d.jobs[id]
+	job.data = data
+	job.status.Phase = phase
+	job.status.Size = size
+	job.status.Message = message
+}
+
+func (d *fakeDaemon) startCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.starts
+}
+
+func (d *fakeDaemon) request(id string) *rpc.PerfProfileRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	job, ok := d.jobs[id]
+	if !ok {
+		return nil
+	}
+	return job.req
+}
+
+// newFakeDaemon starts a fake daemon on a TCP port and returns its port.
+func newFakeDaemon(t *testing.T) (*fakeDaemon, int32) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDaemon{jobs: make(map[string]*fakeJob)}
+	s := grpc.NewServer()
+	rpc.RegisterNecoPerfServer(s, d)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return d, int32(lis.Addr().(*net.TCPAddr).Port)
+}
+
+func newPod(name, containerID string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+		Status: corev1.PodStatus{
+			Phase:  corev1.PodRunning,
+			HostIP: hostIP,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:        "app",
+				ContainerID: "containerd://" + containerID,
+			}},
+		},
+	}
+}
+
+func newDaemonPod(port int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: necoperfNS,
+			Name:      "necoperf-daemon",
+			Labels: map[string]string{
+				constants.LabelAppName: constants.AppNameNecoPerf,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "necoperf-daemon",
+				Ports: []corev1.ContainerPort{{
+					Name:          constants.NecoperfGrpcPortName,
+					ContainerPort: port,
+				}},
+			}},
+		},
+		Status: corev1.PodStatus{
+			HostIP: hostIP,
+			PodIP:  "127.0.0.1",
+		},
+	}
+}
+
+func newTestReconciler(t *testing.T, objs ...client.Object) *ProfileRequestReconciler {
+	t.Helper()
+
+	return newTestReconcilerWithInterceptor(t, interceptor.Funcs{}, objs...)
+}
+
+// newTestReconcilerWithInterceptor creates the reconciler whose client calls are intercepted by funcs.
+func newTestReconcilerWithInterceptor(t *testing.T, funcs interceptor.Funcs, objs ...client.Object) *ProfileRequestReconciler {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := necoperfv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&necoperfv1alpha1.ProfileRequest{}).
+		WithInterceptorFuncs(funcs).
+		Build()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ds, err := resource.NewDiscovery(logger, k8sClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ProfileRequestReconciler{
+		Client:            k8sClient,
+		Logger:            logger,
+		Discovery:         ds,
+		NecoperfNamespace: necoperfNS,
+		NewClient:         necoperf.New,
+	}
+}
+
+func reconcile(t *testing.T, r *ProfileRequestReconciler, name string) (ctrl.Result, *necoperfv1alpha1.ProfileRequest) {
+	t.Helper()
+
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: testNamespace, Name: name}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := &necoperfv1alpha1.ProfileRequest{}
+	if err := r.Get(ctx, key, pr); err != nil {
+		t.Fatal(err)
+	}
+	return result, pr
+}
+
+func TestReconcilePod(t *testing.T) {
+	t.Parallel()
+
+	d, port := newFakeDaemon(t)
+	pr := &necoperfv1alpha1.ProfileRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "req"},
+		Spec: necoperfv1alpha1.ProfileRequestSpec{
+			PodName:   "app-0",
+			Duration:  metav1.Duration{Duration: 10 * time.Second},
+			Format:    "folded",
+			Type:      "off-cpu",
+			Frequency: 49,
+			CallGraph: "fp",
+		},
+	}
+	r := newTestReconciler(t, pr, newPod("app-0", "c0", nil), newDaemonPod(port))
+
+	result, pr := reconcile(t, r, "req")
+	assert.Equal(t, 10*time.Second, result.RequeueAfter)
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseRunning, pr.Status.Phase)
+	assert.True(t, meta.IsStatusConditionTrue(pr.Status.Conditions, necoperfv1alpha1.ConditionStarted))
+	assert.Equal(t, []necoperfv1alpha1.ProfileTarget{{
+		Pod:    "app-0",
+		Node:   "node-1",
+		Daemon: fmt.Sprintf("127.0.0.1:%d", port),
+		JobID:  "c0",
+		Phase:  necoperfv1alpha1.ProfilePhaseRunning,
+	}}, pr.Status.Targets)
+
+	req := d.request("c0")
+	if assert.NotNil(t, req) {
+		assert.Equal(t, 10*time.Second, req.GetTimeout().AsDuration())
+		assert.Equal(t, rpc.OutputFormat_OUTPUT_FORMAT_FOLDED, req.GetOutputFormat())
+		assert.Equal(t, rpc.ProfileType_PROFILE_TYPE_OFF_CPU, req.GetProfileType())
+		assert.Equal(t, uint32(49), req.GetRecordOptions().GetFrequency())
+		assert.Equal(t, "fp", req.GetRecordOptions().GetCallGraph())
+	}
+
+	// The job is still recording.
+	result, pr = reconcile(t, r, "req")
+	assert.Equal(t, pollInterval, result.RequeueAfter)
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseRunning, pr.Status.Phase)
+
+	data := []byte("main;run 10\n")
+	d.finish("c0", rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED, data, int64(len(data)), "")
+	result, pr = reconcile(t, r, "req")
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseSucceeded, pr.Status.Phase)
+	assert.True(t, meta.IsStatusConditionTrue(pr.Status.Conditions, necoperfv1alpha1.ConditionCompleted))
+	assert.Equal(t, "Succeeded", meta.FindStatusCondition(pr.Status.Conditions, necoperfv1alpha1.ConditionCompleted).Reason)
+	assert.Equal(t, "req-app-0", pr.Status.Targets[0].ConfigMap)
+	assert.Equal(t, int64(len(data)), pr.Status.Targets[0].Size)
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: testNamespace, Name: "req-app-0"}, cm); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string][]byte{"app-0.folded": data}, cm.BinaryData)
+	assert.Equal(t, "req", cm.Labels[constants.LabelProfileRequest])
+	if assert.Len(t, cm.OwnerReferences, 1) {
+		assert.Equal(t, "ProfileRequest", cm.OwnerReferences[0].Kind)
+		assert.Equal(t, "req", cm.OwnerReferences[0].Name)
+	}
+}
+
+func TestReconcileSelector(t *testing.T) {
+	t.Parallel()
+
+	d, port := newFakeDaemon(t)
+	labels := map[string]string{"app": "test"}
+	pr := &necoperfv1alpha1.ProfileRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "req"},
+		Spec: necoperfv1alpha1.ProfileRequestSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Duration: metav1.Duration{Duration: 10 * time.Second},
+		},
+	}
+	other := newPod("other", "c9", nil)
+	r := newTestReconciler(t, pr, newPod("app-0", "c0", labels), newPod("app-1", "c1", labels), other, newDaemonPod(port))
+
+	_, pr = reconcile(t, r, "req")
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseRunning, pr.Status.Phase)
+	assert.Len(t, pr.Status.Targets, 2)
+	assert.Nil(t, d.request("c9"))
+	assert.Equal(t, rpc.OutputFormat_OUTPUT_FORMAT_PPROF, d.request("c0").GetOutputFormat())
+
+	d.finish("c0", rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED, nil, MaxConfigMapDataSize+1, "")
+	d.finish("c1", rpc.ProfilePhase_PROFILE_PHASE_FAILED, nil, 0, "perf record failed")
+	_, pr = reconcile(t, r, "req")
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseFailed, pr.Status.Phase)
+	assert.Equal(t, "Failed", meta.FindStatusCondition(pr.Status.Conditions, necoperfv1alpha1.ConditionCompleted).Reason)
+
+	targets := make(map[string]necoperfv1alpha1.ProfileTarget)
+	for _, target := range pr.Status.Targets {
+		targets[target.Pod] = target
+	}
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseSucceeded, targets["app-0"].Phase)
+	assert.Empty(t, targets["app-0"].ConfigMap)
+	assert.Contains(t, targets["app-0"].Message, "necoperf-cli profile fetch -n test app-0 c0")
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseFailed, targets["app-1"].Phase)
+	assert.Equal(t, "perf record failed", targets["app-1"].Message)
+}
+
+func TestReconcileFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		spec    necoperfv1alpha1.ProfileRequestSpec
+		objs    []client.Object
+		message string
+	}{
+		"podNotFound": {
+			spec:    necoperfv1alpha1.ProfileRequestSpec{PodName: "app-0"},
+			message: `pods "app-0" not found`,
+		},
+		"noMatchingPod": {
+			spec: necoperfv1alpha1.ProfileRequestSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			},
+			objs:    []client.Object{newPod("other", "c9", nil)},
+			message: "no running pod matches the selector",
+		},
+		"noDaemon": {
+			spec:    necoperfv1alpha1.ProfileRequestSpec{PodName: "app-0"},
+			objs:    []client.Object{newPod("app-0", "c0", nil)},
+			message: "no profiling job could be started",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pr := &necoperfv1alpha1.ProfileRequest{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "req"},
+				Spec:       tt.spec,
+			}
+			pr.Spec.Duration = metav1.Duration{Duration: 10 * time.Second}
+			r := newTestReconciler(t, append(tt.objs, pr)...)
+
+			result, pr := reconcile(t, r, "req")
+			assert.Zero(t, result.RequeueAfter)
+			assert.Equal(t, necoperfv1alpha1.ProfilePhaseFailed, pr.Status.Phase)
+			started := meta.FindStatusCondition(pr.Status.Conditions, necoperfv1alpha1.ConditionStarted)
+			if assert.NotNil(t, started) {
+				assert.Equal(t, metav1.ConditionFalse, started.Status)
+				assert.Equal(t, tt.message, started.Message)
+			}
+			assert.True(t, meta.IsStatusConditionTrue(pr.Status.Conditions, necoperfv1alpha1.ConditionCompleted))
+		})
+	}
+}
+
+func TestReconcileJobNotFound(t *testing.T) {
+	t.Parallel()
+
+	d, port := newFakeDaemon(t)
+	pr := &necoperfv1alpha1.ProfileRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "req"},
+		Spec: necoperfv1alpha1.ProfileRequestSpec{
+			PodName:  "app-0",
+			Duration: metav1.Duration{Duration: 10 * time.Second},
+		},
+	}
+	r := newTestReconciler(t, pr, newPod("app-0", "c0", nil), newDaemonPod(port))
+
+	reconcile(t, r, "req")
+	// necoperf-daemon has been restarted and lost the job.
+	d.mu.Lock()
+	delete(d.jobs, "c0")
+	d.mu.Unlock()
+
+	_, pr = reconcile(t, r, "req")
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseFailed, pr.Status.Phase)
+	assert.Equal(t, "the job is not found on necoperf-daemon", pr.Status.Targets[0].Message)
+}
+
+func TestReconcileStatusUpdateFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// failAt is the status update to fail, counted from 1.
+		failAt int
+		starts int
+		phase  necoperfv1alpha1.ProfilePhase
+	}{
+		// The pending target is not recorded, so the job is started by the retry.
+		"pending": {
+			failAt: 1,
+			starts: 1,
+			phase:  necoperfv1alpha1.ProfilePhaseRunning,
+		},
+		// The job has been started, but its ID is not recorded. It is not started again.
+		"started": {
+			failAt: 2,
+			starts: 1,
+			phase:  necoperfv1alpha1.ProfilePhaseFailed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d, port := newFakeDaemon(t)
+			pr := &necoperfv1alpha1.ProfileRequest{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "req"},
+				Spec: necoperfv1alpha1.ProfileRequestSpec{
+					PodName:  "app-0",
+					Duration: metav1.Duration{Duration: 10 * time.Second},
+				},
+			}
+			updates := 0
+			funcs := interceptor.Funcs{
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+					updates++
+					if updates == tt.failAt {
+						return errors.New("failed to update")
+					}
+					return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+				},
+			}
+			r := newTestReconcilerWithInterceptor(t, funcs, pr, newPod("app-0", "c0", nil), newDaemonPod(port))
+
+			key := types.NamespacedName{Namespace: testNamespace, Name: "req"}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+			assert.EqualError(t, err, "failed to update")
+
+			_, pr = reconcile(t, r, "req")
+			assert.Equal(t, tt.starts, d.startCount())
+			assert.Equal(t, tt.phase, pr.Status.Phase)
+			if assert.Len(t, pr.Status.Targets, 1) {
+				assert.Equal(t, tt.phase, pr.Status.Targets[0].Phase)
+			}
+		})
+	}
+}
+
+func TestConfigMapName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "req-app-0", configMapName("req", "app-0"))
+
+	long := configMapName(strings.Repeat("r", 200), strings.Repeat("p", 200))
+	assert.Len(t, long, 253)
+	assert.NotEqual(t, long, configMapName(strings.Repeat("r", 200), strings.Repeat("p", 199)+"q"))
+}
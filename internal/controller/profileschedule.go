@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	necoperfv1alpha1 "github.com/cybozu-go/necoperf/api/v1alpha1"
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ProfileScheduleReconciler creates ProfileRequests on the schedules of ProfileSchedules,
+// and deletes the finished ones beyond the history limit.
+type ProfileScheduleReconciler struct {
+	client.Client
+	Logger   *slog.Logger
+	Recorder record.EventRecorder
+	// Now returns the current time. time.Now is used if nil.
+	Now func() time.Time
+}
+
+//+kubebuilder:rbac:groups=necoperf.cybozu.com,resources=profileschedules,verbs=get;list;watch
+//+kubebuilder:rbac:groups=necoperf.cybozu.com,resources=profileschedules/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=necoperf.cybozu.com,resources=profilerequests,verbs=create;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *ProfileScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ps := &necoperfv1alpha1.ProfileSchedule{}
+	if err := r.Get(ctx, req.NamespacedName, ps); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if ps.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	logger := r.Logger.With("namespace", ps.Namespace, "name", ps.Name)
+
+	sched, err := parseSchedule(&ps.Spec)
+	if err != nil {
+		// Retrying does not help until the schedule is fixed.
+		r.Recorder.Event(ps, corev1.EventTypeWarning, "InvalidSchedule", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	runs, err := r.listRuns(ctx, ps)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := r.now()
+	if !ps.Spec.Suspend {
+		earliest := ps.CreationTimestamp.Time
+		if ps.Status.LastScheduleTime != nil {
+			earliest = ps.Status.LastScheduleTime.Time
+		}
+		if scheduled, ok := lastMissed(sched, earliest, now); ok {
+			pr, err := r.createRun(ctx, ps, scheduled)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			logger.Info("profile request is created", "profileRequest", pr.Name, "scheduleTime", scheduled)
+			r.Recorder.Eventf(ps, corev1.EventTypeNormal, "Scheduled", "Created ProfileRequest %s", pr.Name)
+			if !slices.ContainsFunc(runs, func(run *necoperfv1alpha1.ProfileRequest) bool { return run.Name == pr.Name }) {
+				runs = append(runs, pr)
+			}
+			ps.Status.LastScheduleTime = &metav1.Time{Time: scheduled}
+		}
+	}
+
+	runs, err = r.pruneRuns(ctx, ps, runs)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	ps.Status.Runs = make([]necoperfv1alpha1.ProfileScheduleRun, len(runs))
+	for i, run := range runs {
+		ps.Status.Runs[i] = necoperfv1alpha1.ProfileScheduleRun{
+			Name:         run.Name,
+			ScheduleTime: metav1.Time{Time: scheduleTime(run)},
+			Phase:        run.Status.Phase,
+		}
+	}
+	if err := r.Status().Update(ctx, ps); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The update of a suspended ProfileSchedule triggers the reconciliation.
+	next := sched.Next(now)
+	if ps.Spec.Suspend || next.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+func (r *ProfileScheduleReconciler) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// parseSchedule parses the cron schedule in the time zone of the spec.
+func parseSchedule(spec *necoperfv1alpha1.ProfileScheduleSpec) (cron.Schedule, error) {
+	sched, err := cron.ParseStandard(spec.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec.Schedule, err)
+	}
+	if len(spec.TimeZone) == 0 {
+		return sched, nil
+	}
+
+	loc, err := time.LoadLocation(spec.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", spec.TimeZone, err)
+	}
+	// @every schedules do not depend on the time zone.
+	if s, ok := sched.(*cron.SpecSchedule); ok {
+		s.Location = loc
+	}
+	return sched, nil
+}
+
+// lastMissed returns the last scheduled time after earliest and not after now.
+// Only the last one is returned when multiple times have been missed, e.g. while the controller is down.
+func lastMissed(sched cron.Schedule, earliest, now time.Time) (time.Time, bool) {
+	t := sched.Next(earliest)
+	if t.IsZero() || t.After(now) {
+		return time.Time{}, false
+	}
+	for {
+		next := sched.Next(t)
+		if next.IsZero() || next.After(now) {
+			return t, true
+		}
+		t = next
+	}
+}
+
+// listRuns returns the ProfileRequests created by the ProfileSchedule from the oldest.
+func (r *ProfileScheduleReconciler) listRuns(ctx context.Context, ps *necoperfv1alpha1.ProfileSchedule) ([]*necoperfv1alpha1.ProfileRequest, error) {
+	list := &necoperfv1alpha1.ProfileRequestList{}
+	err := r.List(ctx, list, client.InNamespace(ps.Namespace), client.MatchingLabels{
+		constants.LabelProfileSchedule: ps.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*necoperfv1alpha1.ProfileRequest
+	for i := range list.Items {
+		if metav1.IsControlledBy(&list.Items[i], ps) {
+			runs = append(runs, &list.Items[i])
+		}
+	}
+	slices.SortFunc(runs, func(a, b *necoperfv1alpha1.ProfileRequest) int {
+		return scheduleTime(a).Compare(scheduleTime(b))
+	})
+	return runs, nil
+}
+
+// scheduleTime returns the time at which the ProfileRequest was scheduled.
+// The creation time is used if the annotation is broken.
+func scheduleTime(pr *necoperfv1alpha1.ProfileRequest) time.Time {
+	t, err := time.Parse(time.RFC3339, pr.Annotations[constants.AnnotationScheduleTime])
+	if err != nil {
+		return pr.CreationTimestamp.Time
+	}
+	return t
+}
+
+// createRun creates the ProfileRequest scheduled at the time.
+// Its name has the scheduled time so that the same time is not profiled twice.
+func (r *ProfileScheduleReconciler) createRun(ctx context.Context, ps *necoperfv1alpha1.ProfileSchedule, scheduled time.Time) (*necoperfv1alpha1.ProfileRequest, error) {
+	pr := &necoperfv1alpha1.ProfileRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ps.Namespace,
+			Name:      fmt.Sprintf("%s-%s", ps.Name, scheduled.UTC().Format("20060102-1504")),
+			Labels: map[string]string{
+				constants.LabelProfileSchedule: ps.Name,
+			},
+			Annotations: map[string]string{
+				constants.AnnotationScheduleTime: scheduled.UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: *ps.Spec.Template.DeepCopy(),
+	}
+	if err := controllerutil.SetControllerReference(ps, pr, r.Scheme()); err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, pr); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// pruneRuns deletes the oldest finished ProfileRequests beyond the history limit, and returns the rest.
+// The results stored in ConfigMaps are deleted with them by the garbage collector.
+func (r *ProfileScheduleReconciler) pruneRuns(ctx context.Context, ps *necoperfv1alpha1.ProfileSchedule, runs []*necoperfv1alpha1.ProfileRequest) ([]*necoperfv1alpha1.ProfileRequest, error) {
+	excess := len(runs) - int(ps.Spec.HistoryLimit)
+	var kept []*necoperfv1alpha1.ProfileRequest
+	for _, run := range runs {
+		finished := run.Status.Phase == necoperfv1alpha1.ProfilePhaseSucceeded || run.Status.Phase == necoperfv1alpha1.ProfilePhaseFailed
+		if excess <= 0 || !finished {
+			kept = append(kept, run)
+			continue
+		}
+		if err := r.Delete(ctx, run); err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		r.Logger.Info("old profile request is deleted", "namespace", run.Namespace, "name", run.Name)
+		excess--
+	}
+	return kept, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ProfileScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&necoperfv1alpha1.ProfileSchedule{}).
+		Owns(&necoperfv1alpha1.ProfileRequest{}).
+		Complete(r)
+}
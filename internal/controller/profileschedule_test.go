@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	necoperfv1alpha1 "github.com/cybozu-go/necoperf/api/v1alpha1"
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var scheduleCreated = time.Date(2026, 10, 18, 20, 0, 0, 0, time.UTC)
+
+func newSchedule(spec necoperfv1alpha1.ProfileScheduleSpec) *necoperfv1alpha1.ProfileSchedule {
+	if spec.HistoryLimit == 0 {
+		spec.HistoryLimit = 7
+	}
+	spec.Template = necoperfv1alpha1.ProfileRequestSpec{
+		PodName:  "app-0",
+		Duration: metav1.Duration{Duration: 30 * time.Second},
+		Format:   "pprof",
+	}
+	return &necoperfv1alpha1.ProfileSchedule{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         testNamespace,
+			Name:              "nightly",
+			UID:               "nightly-uid",
+			CreationTimestamp: metav1.Time{Time: scheduleCreated},
+		},
+		Spec: spec,
+	}
+}
+
+// newRun creates a ProfileRequest of the ProfileSchedule scheduled at the time in the phase.
+func newRun(t *testing.T, r *ProfileScheduleReconciler, ps *necoperfv1alpha1.ProfileSchedule, scheduled time.Time, phase necoperfv1alpha1.ProfilePhase) {
+	t.Helper()
+
+	ctx := context.Background()
+	pr, err := r.createRun(ctx, ps, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr.Status.Phase = phase
+	if err := r.Status().Update(ctx, pr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestScheduleReconciler(t *testing.T, now *time.Time, objs ...client.Object) (*ProfileScheduleReconciler, *record.FakeRecorder) {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := necoperfv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&necoperfv1alpha1.ProfileSchedule{}, &necoperfv1alpha1.ProfileRequest{}).
+		Build()
+
+	recorder := record.NewFakeRecorder(10)
+	return &ProfileScheduleReconciler{
+		Client:   k8sClient,
+		Logger:   slog.New(slog.NewTextHandler(os.Stderr, nil)),
+		Recorder: recorder,
+		Now:      func() time.Time { return *now },
+	}, recorder
+}
+
+func reconcileSchedule(t *testing.T, r *ProfileScheduleReconciler) (ctrl.Result, *necoperfv1alpha1.ProfileSchedule) {
+	t.Helper()
+
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: testNamespace, Name: "nightly"}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &necoperfv1alpha1.ProfileSchedule{}
+	if err := r.Get(ctx, key, ps); err != nil {
+		t.Fatal(err)
+	}
+	return result, ps
+}
+
+func listRequests(t *testing.T, r *ProfileScheduleReconciler) map[string]necoperfv1alpha1.ProfileRequest {
+	t.Helper()
+
+	list := &necoperfv1alpha1.ProfileRequestList{}
+	if err := r.List(context.Background(), list); err != nil {
+		t.Fatal(err)
+	}
+	requests := make(map[string]necoperfv1alpha1.ProfileRequest)
+	for _, pr := range list.Items {
+		requests[pr.Name] = pr
+	}
+	return requests
+}
+
+func TestReconcileSchedule(t *testing.T) {
+	t.Parallel()
+
+	now := scheduleCreated.Add(30 * time.Minute)
+	ps := newSchedule(necoperfv1alpha1.ProfileScheduleSpec{Schedule: "0 21 * * *"})
+	r, recorder := newTestScheduleReconciler(t, &now, ps)
+
+	result, ps := reconcileSchedule(t, r)
+	assert.Equal(t, 30*time.Minute, result.RequeueAfter)
+	assert.Nil(t, ps.Status.LastScheduleTime)
+	assert.Empty(t, listRequests(t, r))
+
+	now = scheduleCreated.Add(time.Hour + 5*time.Second)
+	result, ps = reconcileSchedule(t, r)
+	assert.Equal(t, 24*time.Hour-5*time.Second, result.RequeueAfter)
+	scheduled := scheduleCreated.Add(time.Hour)
+	if assert.NotNil(t, ps.Status.LastScheduleTime) {
+		assert.True(t, scheduled.Equal(ps.Status.LastScheduleTime.Time))
+	}
+	if assert.Len(t, ps.Status.Runs, 1) {
+		assert.Equal(t, "nightly-20261018-2100", ps.Status.Runs[0].Name)
+	}
+	assert.Len(t, recorder.Events, 1)
+
+	requests := listRequests(t, r)
+	pr, ok := requests["nightly-20261018-2100"]
+	if assert.True(t, ok) {
+		assert.Equal(t, ps.Spec.Template, pr.Spec)
+		assert.Equal(t, "nightly", pr.Labels[constants.LabelProfileSchedule])
+		assert.Equal(t, "2026-10-18T21:00:00Z", pr.Annotations[constants.AnnotationScheduleTime])
+		assert.True(t, metav1.IsControlledBy(&pr, ps))
+	}
+
+	// The same time is not scheduled again.
+	reconcileSchedule(t, r)
+	assert.Len(t, listRequests(t, r), 1)
+
+	// Only the last one of the missed times is scheduled.
+	now = scheduleCreated.Add(72*time.Hour + time.Hour)
+	_, ps = reconcileSchedule(t, r)
+	requests = listRequests(t, r)
+	assert.Len(t, requests, 2)
+	assert.Contains(t, requests, "nightly-20261021-2100")
+	assert.Len(t, ps.Status.Runs, 2)
+}
+
+func TestReconcileScheduleTimeZone(t *testing.T) {
+	t.Parallel()
+
+	// 21:00 in Asia/Tokyo is 12:00 in UTC.
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	ps := newSchedule(necoperfv1alpha1.ProfileScheduleSpec{Schedule: "0 21 * * *", TimeZone: "Asia/Tokyo"})
+	r, _ := newTestScheduleReconciler(t, &now, ps)
+
+	result, _ := reconcileSchedule(t, r)
+	assert.Equal(t, 24*time.Hour, result.RequeueAfter)
+	assert.Contains(t, listRequests(t, r), "nightly-20261019-1200")
+}
+
+func TestReconcileScheduleHistory(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 22, 20, 0, 0, 0, time.UTC)
+	ps := newSchedule(necoperfv1alpha1.ProfileScheduleSpec{Schedule: "0 21 * * *", HistoryLimit: 2})
+	ps.Status.LastScheduleTime = &metav1.Time{Time: time.Date(2026, 10, 21, 21, 0, 0, 0, time.UTC)}
+	r, _ := newTestScheduleReconciler(t, &now, ps)
+
+	// The oldest one is still running.
+	newRun(t, r, ps, time.Date(2026, 10, 18, 21, 0, 0, 0, time.UTC), necoperfv1alpha1.ProfilePhaseRunning)
+	newRun(t, r, ps, time.Date(2026, 10, 19, 21, 0, 0, 0, time.UTC), necoperfv1alpha1.ProfilePhaseFailed)
+	newRun(t, r, ps, time.Date(2026, 10, 20, 21, 0, 0, 0, time.UTC), necoperfv1alpha1.ProfilePhaseSucceeded)
+	newRun(t, r, ps, time.Date(2026, 10, 21, 21, 0, 0, 0, time.UTC), necoperfv1alpha1.ProfilePhaseSucceeded)
+
+	_, ps = reconcileSchedule(t, r)
+	var names []string
+	for _, run := range ps.Status.Runs {
+		names = append(names, run.Name)
+	}
+	assert.Equal(t, []string{"nightly-20261018-2100", "nightly-20261021-2100"}, names)
+	assert.Equal(t, necoperfv1alpha1.ProfilePhaseRunning, ps.Status.Runs[0].Phase)
+	assert.Len(t, listRequests(t, r), 2)
+}
+
+func TestReconcileScheduleSuspend(t *testing.T) {
+	t.Parallel()
+
+	now := scheduleCreated.Add(2 * time.Hour)
+	ps := newSchedule(necoperfv1alpha1.ProfileScheduleSpec{Schedule: "0 21 * * *", Suspend: true})
+	r, _ := newTestScheduleReconciler(t, &now, ps)
+
+	result, ps := reconcileSchedule(t, r)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Nil(t, ps.Status.LastScheduleTime)
+	assert.Empty(t, listRequests(t, r))
+}
+
+func TestReconcileScheduleInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]necoperfv1alpha1.ProfileScheduleSpec{
+		"schedule": {Schedule: "0 25 * * *"},
+		"timeZone": {Schedule: "0 21 * * *", TimeZone: "Invalid/Zone"},
+	}
+
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			now := scheduleCreated.Add(2 * time.Hour)
+			r, recorder := newTestScheduleReconciler(t, &now, newSchedule(spec))
+
+			result, _ := reconcileSchedule(t, r)
+			assert.Zero(t, result.RequeueAfter)
+			assert.Empty(t, listRequests(t, r))
+			if assert.Len(t, recorder.Events, 1) {
+				assert.Contains(t, <-recorder.Events, "InvalidSchedule")
+			}
+		})
+	}
+}
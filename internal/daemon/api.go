@@ -1,100 +1,491 @@
 package daemon
 
 import (
+	"context"
 	"io"
 	"os"
+	"slices"
 	"time"
 
+	"github.com/cybozu-go/necoperf/internal/resource"
 	"github.com/cybozu-go/necoperf/internal/rpc"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
 )
 
 const (
-	maxTimeout = 10 * time.Minute // 10 minute
-	weight     = 1
+	maxTimeout    = 10 * time.Minute // 10 minute
+	weight        = 1
+	maxContainers = 16
 )
 
+type profileParams struct {
+	containers    []*resource.ContainerInfo
+	timeout       time.Duration
+	recordOptions resource.RecordOptions
+	outputFormat  rpc.OutputFormat
+	chunkSize     int
+	compression   rpc.Compression
+	// user is the name of the authenticated caller. It is empty if the authorization is disabled.
+	user string
+	// quota is the session admitted by the quotas.
+	quota *quotaSession
+	// cgroup is true to profile all the processes in the cgroups of the containers.
+	cgroup bool
+}
+
+func (p *profileParams) containerIDs() []string {
+	ids := make([]string, len(p.containers))
+	for i, c := range p.containers {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+func (p *profileParams) pids() []int {
+	pids := make([]int, 0, len(p.containers))
+	for _, c := range p.containers {
+		for _, pid := range c.Processes() {
+			if !slices.Contains(pids, pid) {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	return pids
+}
+
+// namespaces returns the namespaces of the pods of the containers.
+func (p *profileParams) namespaces() []string {
+	var namespaces []string
+	for _, c := range p.containers {
+		if !slices.Contains(namespaces, c.Namespace) {
+			namespaces = append(namespaces, c.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// containerNames returns the names of the containers by PID to tag the samples.
+// It returns nil when only one container is profiled.
+func (p *profileParams) containerNames() map[int]string {
+	if len(p.containers) < 2 {
+		return nil
+	}
+
+	names := make(map[int]string)
+	for _, c := range p.containers {
+		for _, pid := range c.Processes() {
+			names[pid] = c.Name
+		}
+	}
+	return names
+}
+
 func (d *DaemonServer) Profile(req *rpc.PerfProfileRequest, stream rpc.NecoPerf_ProfileServer) error {
-	eg, ctx := errgroup.WithContext(stream.Context())
-	containerID := req.GetContainerId()
-	if len(containerID) == 0 {
-		err := status.Error(codes.InvalidArgument, "container ID is not set")
+	ctx := stream.Context()
+	params, err := d.validateRequest(ctx, req)
+	if err != nil {
 		return err
 	}
+	params.quota, err = d.quotas.admit(params.namespaces(), params.timeout)
+	if err != nil {
+		return err
+	}
+	defer d.quotas.release(params.quota)
 
-	timeoutpb := req.GetTimeout()
-	if !timeoutpb.IsValid() {
-		err := status.Errorf(codes.InvalidArgument, "timeout is invalid value")
+	w, err := newStreamWriter(stream, params.chunkSize, params.compression)
+	if err != nil {
 		return err
 	}
+	progress := &streamProgress{
+		stream:  stream,
+		timeout: params.timeout,
+	}
+	if err := d.runProfile(ctx, ctx, params, progress, w); err != nil {
+		return err
+	}
+	return w.Close()
+}
 
-	timeout := timeoutpb.AsDuration()
-	if timeout > maxTimeout {
-		return status.Errorf(codes.InvalidArgument, "timeout is too long %q", timeout)
+func (d *DaemonServer) StartProfile(ctx context.Context, req *rpc.PerfProfileRequest) (*rpc.StartProfileResponse, error) {
+	params, err := d.validateRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	params.quota, err = d.quotas.admit(params.namespaces(), params.timeout)
+	if err != nil {
+		return nil, err
+	}
+	j, err := d.jobs.create(params)
+	if err != nil {
+		d.quotas.release(params.quota)
+		return nil, err
+	}
+	d.startJob(j)
+	d.logger.Info("profiling job is started", "jobID", j.id, "containerIDs", params.containerIDs())
+
+	return &rpc.StartProfileResponse{
+		JobId: j.id,
+	}, nil
+}
+
+func (d *DaemonServer) GetProfileStatus(ctx context.Context, req *rpc.ProfileJobRequest) (*rpc.ProfileStatus, error) {
+	j, err := d.readJob(ctx, req.GetJobId())
+	if err != nil {
+		return nil, err
 	}
 
-	pid, err := d.container.GetPidFromContainerID(ctx, containerID)
+	return j.status(d.jobs.retention), nil
+}
+
+func (d *DaemonServer) FetchProfile(req *rpc.FetchProfileRequest, stream rpc.NecoPerf_FetchProfileServer) error {
+	j, err := d.readJob(stream.Context(), req.GetJobId())
 	if err != nil {
 		return err
 	}
-	if pid < 1 {
-		err := status.Error(codes.Internal, "invalid PID is returned from CRI API")
+
+	st := j.status(d.jobs.retention)
+	if st.GetPhase() != rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED {
+		return status.Errorf(codes.FailedPrecondition, "job %q has not succeeded: %s", j.id, st.GetPhase())
+	}
+
+	offset := req.GetOffset()
+	if offset < 0 || offset > st.GetSize() {
+		return status.Errorf(codes.OutOfRange, "offset %d is out of range of the result size %d", offset, st.GetSize())
+	}
+
+	chunkSize, err := validateStreamOptions(req.GetChunkSize(), req.GetCompression())
+	if err != nil {
 		return err
 	}
 
-	err = d.semaphore.Acquire(ctx, weight)
+	f, err := os.Open(d.jobs.resultPath(j.id))
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
 
-	var scriptDataPath string
-	defer os.Remove(scriptDataPath)
+	w, err := newStreamWriter(stream, chunkSize, req.GetCompression())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return err
+	}
+	return w.Close()
+}
 
-	eg.Go(func() error {
-		defer d.semaphore.Release(weight)
+func (d *DaemonServer) CancelProfile(ctx context.Context, req *rpc.CancelProfileRequest) (*rpc.ProfileStatus, error) {
+	j, err := d.getJob(ctx, req.GetJobId())
+	if err != nil {
+		return nil, err
+	}
+
+	j.cancel(req.GetKeepPartial())
+	d.logger.Info("profiling job is cancelled", "jobID", j.id, "keepPartial", req.GetKeepPartial())
+
+	return j.status(d.jobs.retention), nil
+}
+
+func (d *DaemonServer) validateRequest(ctx context.Context, req *rpc.PerfProfileRequest) (*profileParams, error) {
+	user, err := d.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	containerIDs := containerIDsFromRequest(req)
+	refs := req.GetContainerRefs()
+	if len(containerIDs) == 0 && len(refs) == 0 {
+		err := status.Error(codes.InvalidArgument, "container ID is not set")
+		return nil, err
+	}
+	if n := len(containerIDs) + len(refs); n > maxContainers {
+		return nil, status.Errorf(codes.InvalidArgument, "too many containers: %d", n)
+	}
+	for _, ref := range refs {
+		if len(ref.GetNamespace()) == 0 || len(ref.GetPodName()) == 0 || len(ref.GetContainerName()) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "namespace, pod name and container name must be set in container reference")
+		}
+	}
+
+	timeoutpb := req.GetTimeout()
+	if !timeoutpb.IsValid() {
+		err := status.Errorf(codes.InvalidArgument, "timeout is invalid value")
+		return nil, err
+	}
+
+	timeout := timeoutpb.AsDuration()
+	if timeout > maxTimeout {
+		return nil, status.Errorf(codes.InvalidArgument, "timeout is too long %q", timeout)
+	}
 
-		profileDataPath, err := d.perfExecuter.ExecRecord(ctx, d.workDir, pid, timeout)
-		defer os.Remove(profileDataPath)
+	profileType := req.GetProfileType()
+	if _, ok := rpc.ProfileType_name[int32(profileType)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "profile type %d is not supported", profileType)
+	}
+
+	recordOptions := recordOptionsFromRequest(req.GetRecordOptions())
+	recordOptions.OffCPU = profileType == rpc.ProfileType_PROFILE_TYPE_OFF_CPU
+	if err := recordOptions.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record options: %v", err)
+	}
+
+	outputFormat := req.GetOutputFormat()
+	if _, ok := rpc.OutputFormat_name[int32(outputFormat)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "output format %d is not supported", outputFormat)
+	}
+
+	chunkSize, err := validateStreamOptions(req.GetChunkSize(), req.GetCompression())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, containerID := range containerIDs {
+		if err := d.authorizeContainer(ctx, user, containerID); err != nil {
+			return nil, err
+		}
+	}
+	for _, ref := range refs {
+		containerID, err := d.resolveContainerRef(ctx, user, ref)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if !slices.Contains(containerIDs, containerID) {
+			containerIDs = append(containerIDs, containerID)
 		}
+	}
 
-		scriptDataPath, err = d.perfExecuter.ExecScript(ctx, profileDataPath, d.workDir)
+	containers, err := d.getContainers(ctx, containerIDs)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetCgroup() {
+		for _, c := range containers {
+			if len(c.CgroupPath) == 0 {
+				return nil, status.Errorf(codes.FailedPrecondition, "cgroup of container %q is not known by the container runtime", c.ID)
+			}
+		}
+	}
+
+	params := &profileParams{
+		containers:    containers,
+		timeout:       timeout,
+		recordOptions: recordOptions,
+		outputFormat:  outputFormat,
+		chunkSize:     chunkSize,
+		compression:   req.GetCompression(),
+		cgroup:        req.GetCgroup(),
+	}
+	if user != nil {
+		params.user = user.Username
+	}
+	return params, nil
+}
+
+// getContainers returns the information of the containers after checking their PIDs.
+func (d *DaemonServer) getContainers(ctx context.Context, containerIDs []string) ([]*resource.ContainerInfo, error) {
+	containers := make([]*resource.ContainerInfo, 0, len(containerIDs))
+	for _, containerID := range containerIDs {
+		info, err := d.container.GetContainerInfo(ctx, containerID)
 		if err != nil {
+			return nil, err
+		}
+		if info.PID < 1 {
+			err := status.Error(codes.Internal, "invalid PID is returned from CRI API")
+			return nil, err
+		}
+		containers = append(containers, info)
+	}
+	return containers, nil
+}
+
+// runProfile records the profile, converts it into the requested format and writes the result to w.
+// When recordCtx is done but ctx is not, the recording is stopped and the data recorded so far is converted.
+func (d *DaemonServer) runProfile(ctx, recordCtx context.Context, params *profileParams, progress progressReporter, w io.Writer) error {
+	err := d.acquireWorker(ctx, progress)
+	if err != nil {
+		return err
+	}
+	defer d.semaphore.Release(weight)
+
+	// The processes are listed after waiting for perf so that the ones started in the meantime are included.
+	if params.cgroup {
+		if err := d.listCgroupProcesses(params); err != nil {
 			return err
 		}
+	}
 
-		return nil
+	progress.recording(0, 0)
+	recordStart := time.Now()
+	var samples int
+	profileDataPath, err := d.profiler.ExecRecord(recordCtx, d.workDir, params.pids(), params.timeout, params.recordOptions, func(n int) {
+		samples = n
+		progress.recording(time.Since(recordStart), n)
 	})
+	params.quota.setRecorded(time.Since(recordStart))
+	defer os.Remove(profileDataPath)
+	if err != nil && (len(profileDataPath) == 0 || ctx.Err() != nil) {
+		return err
+	}
+
+	progress.converting(samples)
+	d.symbolize(ctx, profileDataPath, params)
+	names := params.containerNames()
+	if params.outputFormat == rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT {
+		if err := writeContainerComments(w, params.containers, names); err != nil {
+			return err
+		}
+		return d.profiler.ExecScript(ctx, profileDataPath, w)
+	}
+	if params.outputFormat == rpc.OutputFormat_OUTPUT_FORMAT_RAW {
+		return d.profiler.ExecArchive(ctx, profileDataPath, w)
+	}
 
-	if err := eg.Wait(); err != nil {
+	// Convert the output of perf script while reading it, so that it is not kept in memory or on disk.
+	pr, pw := io.Pipe()
+	var g errgroup.Group
+	g.Go(func() error {
+		err := d.profiler.ExecScript(ctx, profileDataPath, pw)
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		err := convertScript(pr, w, params.outputFormat, params.recordOptions, names)
+		pr.CloseWithError(err)
 		return err
+	})
+	return g.Wait()
+}
+
+// symbolize makes the symbols of the binaries in the containers available to perf script.
+// The profile is still converted on failure, with the symbols which perf can resolve by itself.
+func (d *DaemonServer) symbolize(ctx context.Context, profileDataPath string, params *profileParams) {
+	if err := d.profiler.Symbolize(ctx, profileDataPath, params.pids()); err != nil {
+		d.logger.Warn("failed to symbolize the profile", "path", profileDataPath, "error", err)
 	}
+}
 
-	f, err := os.Open(scriptDataPath)
+// listCgroupProcesses sets the processes in the cgroups to the containers to be profiled.
+func (d *DaemonServer) listCgroupProcesses(params *profileParams) error {
+	for _, c := range params.containers {
+		pids, err := resource.CgroupProcesses(d.cgroupRoot, c.CgroupPath)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list the processes of container %q: %v", c.ID, err)
+		}
+		c.PIDs = pids
+		d.logger.Info("processes in the cgroup are profiled", "containerID", c.ID, "cgroup", c.CgroupPath, "pids", pids)
+	}
+	return nil
+}
+
+// acquireWorker waits for perf to be available within the maximum queue wait.
+// The position of the session in the queue is reported to progress while waiting.
+func (d *DaemonServer) acquireWorker(ctx context.Context, progress progressReporter) error {
+	if d.semaphore.TryAcquire(weight) {
+		return nil
+	}
+
+	id, err := d.quotas.enterQueue()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer d.quotas.leaveQueue(id)
+
+	waitCtx := ctx
+	if wait := d.quotas.config.MaxQueueWait.Duration; wait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, wait)
+		defer cancel()
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- d.semaphore.Acquire(waitCtx, weight)
+	}()
 
-	buf := make([]byte, 1024)
+	position := d.quotas.queuePosition(id)
+	progress.queued(position)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
 	for {
-		n, err := f.Read(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+		select {
+		case err := <-acquired:
+			if err != nil && ctx.Err() == nil {
+				return d.quotas.queueTimeoutError()
+			}
 			return err
+		case <-ticker.C:
+			if p := d.quotas.queuePosition(id); p != position {
+				position = p
+				progress.queued(position)
+			}
 		}
+	}
+}
 
-		if err := stream.Send(&rpc.PerfProfileResponse{
-			Data: buf[:n],
-		}); err != nil {
-			return err
+// resolveContainerRef returns the ID of the container specified by the reference after authorizing the user.
+func (d *DaemonServer) resolveContainerRef(ctx context.Context, user *authenticationv1.UserInfo, ref *rpc.ContainerRef) (string, error) {
+	pod := &resource.PodRef{
+		Namespace: ref.GetNamespace(),
+		Name:      ref.GetPodName(),
+	}
+	if err := d.authorizePod(ctx, user, pod); err != nil {
+		return "", err
+	}
+
+	containerID, err := d.container.FindContainerID(ctx, pod, ref.GetContainerName())
+	if err != nil {
+		return "", err
+	}
+	if len(containerID) != 0 {
+		return containerID, nil
+	}
+
+	found, err := d.container.HasPod(ctx, pod)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", status.Errorf(codes.NotFound, "pod %s is not on the node of this necoperf-daemon", pod.String())
+	}
+	return "", status.Errorf(codes.NotFound, "container %q is not running in pod %s", ref.GetContainerName(), pod.String())
+}
+
+// containerIDsFromRequest returns the IDs of the containers in the request without duplicates.
+func containerIDsFromRequest(req *rpc.PerfProfileRequest) []string {
+	var ids []string
+	for _, id := range append([]string{req.GetContainerId()}, req.GetContainerIds()...) {
+		if len(id) != 0 && !slices.Contains(ids, id) {
+			ids = append(ids, id)
 		}
 	}
+	return ids
+}
 
-	return nil
+func recordOptionsFromRequest(o *rpc.PerfRecordOptions) resource.RecordOptions {
+	opts := resource.DefaultRecordOptions()
+	if o == nil {
+		return opts
+	}
+
+	if o.GetFrequency() != 0 {
+		opts.Frequency = int(o.GetFrequency())
+	}
+	if len(o.GetCallGraph()) != 0 {
+		opts.CallGraph = o.GetCallGraph()
+	}
+	opts.DwarfStackSize = int(o.GetDwarfStackSize())
+	if opts.CallGraph == "dwarf" && opts.DwarfStackSize == 0 {
+		opts.DwarfStackSize = resource.DefaultDwarfStackSize
+	}
+	if o.SystemWide != nil {
+		opts.SystemWide = o.GetSystemWide()
+	}
+
+	return opts
 }
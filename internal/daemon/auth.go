@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// authorizer authenticates and authorizes the callers of the API.
+// It is implemented by resource.Authorizer.
+type authorizer interface {
+	Authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error)
+	Authorize(ctx context.Context, user *authenticationv1.UserInfo, pod *resource.PodRef) error
+}
+
+func (d *DaemonServer) setupAuthorizer() error {
+	if !d.authorization {
+		return nil
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return err
+	}
+	k8sClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return err
+	}
+
+	d.authorizer = resource.NewAuthorizer(d.logger, k8sClient)
+	return nil
+}
+
+// authenticate returns the user of the bearer token in the request metadata.
+// It returns nil if the authorization is disabled.
+func (d *DaemonServer) authenticate(ctx context.Context) (*authenticationv1.UserInfo, error) {
+	if d.authorizer == nil {
+		return nil, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "bearer token is not set")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "authorization is not a bearer token")
+	}
+
+	user, err := d.authorizer.Authenticate(ctx, token)
+	if err != nil {
+		d.logger.Info("failed to authenticate", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "failed to authenticate")
+	}
+	return user, nil
+}
+
+// authorizeContainer returns nil if the user is allowed to profile the pod of the container.
+// The details of the failure are not returned so as not to reveal the pods of other tenants.
+func (d *DaemonServer) authorizeContainer(ctx context.Context, user *authenticationv1.UserInfo, containerID string) error {
+	if d.authorizer == nil {
+		return nil
+	}
+
+	pod, err := d.container.GetPodRef(ctx, containerID)
+	if err != nil {
+		d.logger.Info("failed to get the pod of the container", "containerID", containerID, "error", err)
+		return status.Errorf(codes.PermissionDenied, "not allowed to profile container %q", containerID)
+	}
+	if err := d.authorizer.Authorize(ctx, user, pod); err != nil {
+		d.logger.Info("failed to authorize", "containerID", containerID, "error", err)
+		return status.Errorf(codes.PermissionDenied, "not allowed to profile container %q", containerID)
+	}
+	return nil
+}
+
+// authorizePod returns nil if the user is allowed to profile the pod.
+func (d *DaemonServer) authorizePod(ctx context.Context, user *authenticationv1.UserInfo, pod *resource.PodRef) error {
+	if d.authorizer == nil {
+		return nil
+	}
+
+	if err := d.authorizer.Authorize(ctx, user, pod); err != nil {
+		d.logger.Info("failed to authorize", "pod", pod.String(), "error", err)
+		return status.Errorf(codes.PermissionDenied, "not allowed to profile pod %s", pod.String())
+	}
+	return nil
+}
+
+// getJob returns the job if the caller is the user who started it.
+func (d *DaemonServer) getJob(ctx context.Context, id string) (*job, error) {
+	user, err := d.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := d.jobs.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && j.params.user != user.Username {
+		return nil, status.Errorf(codes.NotFound, "job %q is not found", id)
+	}
+	return j, nil
+}
+
+// readJob returns the job if the caller is the user who started it, or is allowed to profile all the pods of the job.
+// The latter lets the tenants read the results of the jobs started for them by necoperf-controller.
+// Only the user who started the job can cancel it by getJob.
+func (d *DaemonServer) readJob(ctx context.Context, id string) (*job, error) {
+	user, err := d.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := d.jobs.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || j.params.user == user.Username {
+		return j, nil
+	}
+	for _, c := range j.params.containers {
+		pod := &resource.PodRef{Namespace: c.Namespace, Name: c.PodName}
+		if len(pod.Namespace) == 0 || len(pod.Name) == 0 {
+			return nil, status.Errorf(codes.NotFound, "job %q is not found", id)
+		}
+		if err := d.authorizer.Authorize(ctx, user, pod); err != nil {
+			d.logger.Info("failed to authorize to read the job", "jobID", id, "pod", pod.String(), "error", err)
+			return nil, status.Errorf(codes.NotFound, "job %q is not found", id)
+		}
+	}
+	return j, nil
+}
@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// fakeAuthorizer authenticates the tokens in users,
+// and allows each user to profile the pods in the namespace of the same name, and admin to profile any pod.
+type fakeAuthorizer struct {
+	users map[string]string
+}
+
+func (a *fakeAuthorizer) Authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	name, ok := a.users[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return &authenticationv1.UserInfo{Username: name}, nil
+}
+
+func (a *fakeAuthorizer) Authorize(ctx context.Context, user *authenticationv1.UserInfo, pod *resource.PodRef) error {
+	if user.Username != pod.Namespace && user.Username != "admin" {
+		return errors.New("forbidden")
+	}
+	return nil
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func TestAuthorization(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDaemonServer(t, newFakeProfiler(t))
+	d.authorizer = &fakeAuthorizer{
+		users: map[string]string{
+			"test-token":  "test",
+			"other-token": "other",
+			"admin-token": "admin",
+		},
+	}
+	client, closer := server(ctx, d)
+	defer closer()
+
+	tests := map[string]struct {
+		ctx          context.Context
+		containerIDs []string
+		code         codes.Code
+	}{
+		"allowed": {
+			ctx:          withToken(ctx, "test-token"),
+			containerIDs: []string{containerID},
+			code:         codes.OK,
+		},
+		"noToken": {
+			ctx:          ctx,
+			containerIDs: []string{containerID},
+			code:         codes.Unauthenticated,
+		},
+		"invalidToken": {
+			ctx:          withToken(ctx, "invalid-token"),
+			containerIDs: []string{containerID},
+			code:         codes.Unauthenticated,
+		},
+		"otherNamespace": {
+			ctx:          withToken(ctx, "test-token"),
+			containerIDs: []string{containerID, "app"},
+			code:         codes.PermissionDenied,
+		},
+		"unknownContainer": {
+			ctx:          withToken(ctx, "test-token"),
+			containerIDs: []string{"unknown"},
+			code:         codes.PermissionDenied,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := client.StartProfile(tt.ctx, &rpc.PerfProfileRequest{
+				ContainerIds: tt.containerIDs,
+				Timeout:      durationpb.New(timeout),
+			})
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+
+	t.Run("jobOwner", func(t *testing.T) {
+		resp, err := client.StartProfile(withToken(ctx, "test-token"), &rpc.PerfProfileRequest{
+			ContainerId: containerID,
+			Timeout:     durationpb.New(timeout),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &rpc.ProfileJobRequest{JobId: resp.GetJobId()}
+
+		_, err = client.GetProfileStatus(withToken(ctx, "test-token"), req)
+		assert.NoError(t, err)
+
+		_, err = client.GetProfileStatus(withToken(ctx, "other-token"), req)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.CancelProfile(withToken(ctx, "other-token"), &rpc.CancelProfileRequest{JobId: resp.GetJobId()})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.CancelProfile(withToken(ctx, "test-token"), &rpc.CancelProfileRequest{JobId: resp.GetJobId()})
+		assert.NoError(t, err)
+	})
+	t.Run("podUser", func(t *testing.T) {
+		// The job started by another user can be read by the users allowed to profile the pod.
+		resp, err := client.StartProfile(withToken(ctx, "admin-token"), &rpc.PerfProfileRequest{
+			ContainerId: containerID,
+			Timeout:     durationpb.New(timeout),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &rpc.ProfileJobRequest{JobId: resp.GetJobId()}
+
+		_, err = client.GetProfileStatus(withToken(ctx, "test-token"), req)
+		assert.NoError(t, err)
+
+		_, err = client.GetProfileStatus(withToken(ctx, "other-token"), req)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.CancelProfile(withToken(ctx, "test-token"), &rpc.CancelProfileRequest{JobId: resp.GetJobId()})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.CancelProfile(withToken(ctx, "admin-token"), &rpc.CancelProfileRequest{JobId: resp.GetJobId()})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/sink"
+	"golang.org/x/sync/errgroup"
+)
+
+// continuousCallGraph is the call graph recording method of the continuous profiling.
+// The frame pointers are used since copying the stacks for dwarf is too expensive to run all the time.
+const continuousCallGraph = "fp"
+
+// ContinuousConfig is the configuration of the continuous profiling of the pods annotated with
+// constants.AnnotationContinuousProfiling. It is disabled if Sink is nil.
+type ContinuousConfig struct {
+	// Interval is the interval between the starts of the sampling windows.
+	Interval time.Duration
+	// Duration is the length of each sampling window.
+	Duration time.Duration
+	// Frequency is the sampling frequency in Hz.
+	Frequency int
+	// Sink receives the pprof profile of each container in each window.
+	Sink sink.Sink
+}
+
+func (c *ContinuousConfig) validate() error {
+	if c.Sink == nil {
+		return nil
+	}
+	if c.Duration < time.Second {
+		return fmt.Errorf("duration of the continuous profiling must be at least 1s: %s", c.Duration)
+	}
+	if c.Interval <= c.Duration {
+		return fmt.Errorf("interval of the continuous profiling must be longer than the duration %s: %s", c.Duration, c.Interval)
+	}
+	if c.Frequency < 1 || c.Frequency > resource.MaxFrequency {
+		return fmt.Errorf("frequency of the continuous profiling must be between 1 and %d: %d", resource.MaxFrequency, c.Frequency)
+	}
+	return nil
+}
+
+func (d *DaemonServer) runContinuous(ctx context.Context) error {
+	d.logger.Info("continuous profiling is enabled", "interval", d.continuous.Interval, "duration", d.continuous.Duration)
+	ticker := time.NewTicker(d.continuous.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.profileWindow(ctx); err != nil {
+				d.logger.Error("continuous profiling is failed", "error", err)
+			}
+		}
+	}
+}
+
+// profileWindow records the annotated containers on this node, and puts the profile of each container to the sink.
+func (d *DaemonServer) profileWindow(ctx context.Context) error {
+	containers, err := d.container.ListAnnotatedContainers(ctx, constants.AnnotationContinuousProfiling)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+
+	// The continuous profiling gives way to the requests of the users rather than waiting in the queue.
+	if !d.semaphore.TryAcquire(weight) {
+		d.logger.Info("continuous profiling is skipped because perf is busy")
+		return nil
+	}
+	defer d.semaphore.Release(weight)
+
+	options := resource.RecordOptions{
+		Frequency: d.continuous.Frequency,
+		CallGraph: continuousCallGraph,
+	}
+	return d.recordToSink(ctx, containers, d.continuous.Duration, options, d.continuous.Sink)
+}
+
+// recordToSink records the containers in one perf record session, and puts the profile of each container to the sink.
+// The caller must hold the semaphore.
+func (d *DaemonServer) recordToSink(ctx context.Context, containers []*resource.ContainerInfo, duration time.Duration, options resource.RecordOptions, s sink.Sink) error {
+	params := &profileParams{
+		containers:    containers,
+		recordOptions: options,
+	}
+	start := time.Now()
+	profileDataPath, err := d.profiler.ExecRecord(ctx, d.workDir, params.pids(), duration, params.recordOptions, nil)
+	end := time.Now()
+	if len(profileDataPath) != 0 {
+		defer os.Remove(profileDataPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.symbolize(ctx, profileDataPath, params)
+	builders, err := d.buildProfiles(ctx, profileDataPath, params)
+	if errors.Is(err, resource.ErrNoEvents) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		p := builders[c.PID].Profile()
+		if len(p.Sample) == 0 {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := p.Write(&buf); err != nil {
+			return err
+		}
+
+		err := s.Put(ctx, &sink.Profile{
+			Namespace: c.Namespace,
+			Pod:       c.PodName,
+			Container: c.Name,
+			Start:     start,
+			End:       end,
+			Frequency: options.Frequency,
+			Data:      buf.Bytes(),
+		})
+		if err != nil {
+			// The other containers can still be put.
+			d.logger.Error("failed to put the profile", "namespace", c.Namespace, "pod", c.PodName, "container", c.Name, "error", err)
+		}
+	}
+	return nil
+}
+
+// buildProfiles converts the recorded data into a profile for each container by PID.
+func (d *DaemonServer) buildProfiles(ctx context.Context, profileDataPath string, params *profileParams) (map[int]*perfscript.ProfileBuilder, error) {
+	builders := make(map[int]*perfscript.ProfileBuilder)
+	for _, pid := range params.pids() {
+		builders[pid] = perfscript.NewProfileBuilder(params.recordOptions.Frequency)
+	}
+
+	pr, pw := io.Pipe()
+	var g errgroup.Group
+	g.Go(func() error {
+		err := d.profiler.ExecScript(ctx, profileDataPath, pw)
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		p := perfscript.NewParser(pr)
+		for {
+			s, err := p.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				pr.CloseWithError(err)
+				return err
+			}
+			pid := s.PID
+			if pid == 0 {
+				pid = s.TID
+			}
+			if b, ok := builders[pid]; ok {
+				b.Add(s)
+			}
+		}
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return builders, nil
+}
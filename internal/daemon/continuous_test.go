@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/sink"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSink keeps the profiles put to it.
+type fakeSink struct {
+	mu       sync.Mutex
+	profiles map[string]*sink.Profile
+}
+
+func (s *fakeSink) Put(ctx context.Context, p *sink.Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profiles[p.Namespace+"/"+p.Pod+"/"+p.Container] = p
+	return nil
+}
+
+func newTestContinuousServer(t *testing.T, profiler *resource.FakeProfiler) (*DaemonServer, *fakeSink) {
+	t.Helper()
+
+	s := &fakeSink{profiles: make(map[string]*sink.Profile)}
+	d := newTestDaemonServer(t, profiler)
+	d.continuous = ContinuousConfig{
+		Interval:  time.Minute,
+		Duration:  10 * time.Millisecond,
+		Frequency: 19,
+		Sink:      s,
+	}
+	return d, s
+}
+
+func TestProfileWindow(t *testing.T) {
+	ctx := context.Background()
+	profiler := newFakeProfiler(t)
+	d, s := newTestContinuousServer(t, profiler)
+
+	if err := d.profileWindow(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	records := profiler.Records()
+	if !assert.Len(t, records, 1) {
+		return
+	}
+	assert.ElementsMatch(t, []int{12345, 12400}, records[0].PIDs)
+	assert.Equal(t, 10*time.Millisecond, records[0].Timeout)
+	assert.Equal(t, resource.RecordOptions{Frequency: 19, CallGraph: "fp"}, records[0].Options)
+
+	assert.Len(t, s.profiles, 2)
+	for _, name := range []string{"app/app-pod/app", "app/app-pod/sidecar"} {
+		p, ok := s.profiles[name]
+		if !assert.True(t, ok, name) {
+			continue
+		}
+		assert.Equal(t, 19, p.Frequency)
+		assert.False(t, p.End.Before(p.Start))
+
+		prof, err := profile.Parse(bytes.NewReader(p.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEmpty(t, prof.Sample, name)
+	}
+	assertNoTemporaryFiles(t, d.workDir)
+}
+
+func TestProfileWindowSkipped(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("busy", func(t *testing.T) {
+		profiler := newFakeProfiler(t)
+		d, s := newTestContinuousServer(t, profiler)
+		if err := d.semaphore.Acquire(ctx, maxWorkers); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, d.profileWindow(ctx))
+		assert.Empty(t, profiler.Records())
+		assert.Empty(t, s.profiles)
+	})
+
+	t.Run("noEvents", func(t *testing.T) {
+		profiler := newFakeProfiler(t)
+		profiler.ScriptErr = resource.ErrNoEvents
+		d, s := newTestContinuousServer(t, profiler)
+
+		assert.NoError(t, d.profileWindow(ctx))
+		assert.Len(t, profiler.Records(), 1)
+		assert.Empty(t, s.profiles)
+	})
+}
+
+func TestContinuousConfig(t *testing.T) {
+	t.Parallel()
+
+	s := &fakeSink{}
+	tests := map[string]struct {
+		config ContinuousConfig
+		valid  bool
+	}{
+		"disabled": {
+			config: ContinuousConfig{},
+			valid:  true,
+		},
+		"valid": {
+			config: ContinuousConfig{Interval: time.Minute, Duration: 10 * time.Second, Frequency: 19, Sink: s},
+			valid:  true,
+		},
+		"tooShortDuration": {
+			config: ContinuousConfig{Interval: time.Minute, Duration: 100 * time.Millisecond, Frequency: 19, Sink: s},
+		},
+		"intervalNotLongerThanDuration": {
+			config: ContinuousConfig{Interval: 10 * time.Second, Duration: 10 * time.Second, Frequency: 19, Sink: s},
+		},
+		"tooHighFrequency": {
+			config: ContinuousConfig{Interval: time.Minute, Duration: 10 * time.Second, Frequency: 1000, Sink: s},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.config.validate()
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
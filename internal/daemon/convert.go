@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+)
+
+// writeContainerComments writes the containers as comments of the output of perf script
+// so that the PIDs in the samples can be mapped to the containers.
+func writeContainerComments(w io.Writer, containers []*resource.ContainerInfo, names map[int]string) error {
+	if names == nil {
+		return nil
+	}
+	for _, c := range containers {
+		if _, err := fmt.Fprintf(w, "# container: %s id=%s pid=%d\n", c.Name, c.ID, c.PID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "#")
+	return err
+}
+
+// convertScript reads the output of perf script from r, converts it into the specified format
+// and writes the result to w. If names is not nil, the samples are tagged with the container names by PID.
+// For the off-CPU profile, the stacks are weighted by the blocked time in nanoseconds instead of the samples.
+func convertScript(r io.Reader, w io.Writer, format rpc.OutputFormat, opts resource.RecordOptions, names map[int]string) error {
+	var add func(s *perfscript.Sample, value int64)
+	var write func(w io.Writer) error
+
+	containerOf := func(s *perfscript.Sample) (string, bool) {
+		pid := s.PID
+		if pid == 0 {
+			pid = s.TID
+		}
+		name, ok := names[pid]
+		return name, ok
+	}
+
+	switch format {
+	case rpc.OutputFormat_OUTPUT_FORMAT_PPROF:
+		b := perfscript.NewProfileBuilder(opts.Frequency)
+		if opts.OffCPU {
+			b = perfscript.NewOffCPUProfileBuilder()
+		}
+		add = func(s *perfscript.Sample, value int64) {
+			var labels map[string]string
+			if name, ok := containerOf(s); ok {
+				labels = map[string]string{"container": name}
+			}
+			if opts.OffCPU {
+				b.AddWithValue(s, value, labels)
+				return
+			}
+			b.AddWithLabels(s, labels)
+		}
+		write = func(w io.Writer) error {
+			return b.Profile().Write(w)
+		}
+	case rpc.OutputFormat_OUTPUT_FORMAT_FOLDED:
+		folded := make(perfscript.Folded)
+		add = func(s *perfscript.Sample, value int64) {
+			if name, ok := containerOf(s); ok {
+				folded.AddWithRoot([]string{name}, s, value)
+				return
+			}
+			folded.Add(s, value)
+		}
+		write = folded.Write
+	default:
+		return fmt.Errorf("output format %s cannot be converted", format)
+	}
+
+	var offCPU *perfscript.OffCPU
+	if opts.OffCPU {
+		offCPU = perfscript.NewOffCPU()
+	}
+
+	p := perfscript.NewParser(r)
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if offCPU == nil {
+			add(s, 1)
+			continue
+		}
+		if blocked, ns, ok := offCPU.Add(s); ok {
+			add(blocked, ns)
+		}
+	}
+
+	return write(w)
+}
@@ -2,13 +2,16 @@ package daemon
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/cybozu-go/necoperf/internal/constants"
 	"github.com/cybozu-go/necoperf/internal/resource"
 	"github.com/cybozu-go/necoperf/internal/rpc"
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
@@ -18,6 +21,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
@@ -34,8 +38,24 @@ type DaemonServer struct {
 	workDir     string
 	semaphore   *semaphore.Weighted
 	rpc.UnimplementedNecoPerfServer
-	container    *resource.Container
-	perfExecuter *resource.PerfExecuter
+	container *resource.Container
+	perfPath  string
+	// cgroupRoot is the mount point of the cgroup hierarchy of the host.
+	cgroupRoot string
+	// symbols is the store of the debug files to resolve the symbols of the stripped binaries.
+	symbols resource.SymbolStore
+	// symbolRetention is the duration to keep the binaries not used in the build-ID cache.
+	symbolRetention time.Duration
+	profiler        resource.Profiler
+	jobs            *jobManager
+	quotas          *quotaManager
+	// authorization enables the authorization of the callers by the Kubernetes API.
+	authorization bool
+	authorizer    authorizer
+	continuous    ContinuousConfig
+	trigger       TriggerConfig
+	// cpuWatches are the CPU usages of the containers watched by the triggered profiling by container ID.
+	cpuWatches map[string]*cpuWatch
 }
 
 const (
@@ -54,7 +74,45 @@ var (
 	)
 )
 
-func New(logger *slog.Logger, port, metricsPort int, endpoint, workDir string) (*DaemonServer, error) {
+// Config is the configuration of DaemonServer.
+type Config struct {
+	Port        int
+	MetricsPort int
+	// RuntimeEndpoint is the endpoint of the CRI API of the container runtime.
+	RuntimeEndpoint string
+	// WorkDir is the directory in which the profiling results and the build-ID cache are stored.
+	WorkDir  string
+	PerfPath string
+	// CgroupRoot is the mount point of the cgroup hierarchy of the host.
+	CgroupRoot string
+	// Symbols is the store of the debug files to resolve the symbols of the stripped binaries.
+	Symbols resource.SymbolStore
+	// SymbolRetention is the duration to keep the binaries not used in the build-ID cache. They are kept forever if 0.
+	SymbolRetention time.Duration
+	// Retention is the duration to keep the results of the jobs after they finish.
+	Retention time.Duration
+	// TLSConfig is used to serve the gRPC server over TLS. The server is not encrypted when nil.
+	TLSConfig *tls.Config
+	// Authorization enables the authorization of the callers by the Kubernetes API.
+	Authorization bool
+	Quota         QuotaConfig
+	Continuous    ContinuousConfig
+	Trigger       TriggerConfig
+}
+
+// New creates a DaemonServer. The gRPC server is served over TLS if config.TLSConfig is not nil.
+// If config.Authorization is true, the callers must be allowed to profile the pods by the Kubernetes API.
+func New(logger *slog.Logger, config Config) (*DaemonServer, error) {
+	if err := config.Continuous.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Trigger.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Symbols.Validate(); err != nil {
+		return nil, err
+	}
+
 	opts := []logging.Option{
 		logging.WithLogOnEvents(logging.StartCall, logging.FinishCall),
 	}
@@ -66,7 +124,7 @@ func New(logger *slog.Logger, port, metricsPort int, endpoint, workDir string) (
 	srvMetrics := grpcprom.NewServerMetrics()
 	reg.MustRegister(srvMetrics)
 
-	serv := grpc.NewServer(
+	serverOpts := []grpc.ServerOption{
 		grpc.ChainUnaryInterceptor(
 			srvMetrics.UnaryServerInterceptor(),
 			logging.UnaryServerInterceptor(InterceptorLogger(logger), opts...),
@@ -78,7 +136,11 @@ func New(logger *slog.Logger, port, metricsPort int, endpoint, workDir string) (
 		grpc.KeepaliveEnforcementPolicy(
 			kep,
 		),
-	)
+	}
+	if config.TLSConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(config.TLSConfig)))
+	}
+	serv := grpc.NewServer(serverOpts...)
 	srvMetrics.InitializeMetrics(serv)
 
 	semaphore := semaphore.NewWeighted(maxWorkers)
@@ -86,11 +148,22 @@ func New(logger *slog.Logger, port, metricsPort int, endpoint, workDir string) (
 	return &DaemonServer{
 		logger:      logger,
 		server:      serv,
-		port:        port,
-		metricsPort: metricsPort,
-		endpoint:    endpoint,
-		workDir:     workDir,
+		port:        config.Port,
+		metricsPort: config.MetricsPort,
+		endpoint:    config.RuntimeEndpoint,
+		workDir:     config.WorkDir,
+		perfPath:    config.PerfPath,
+		cgroupRoot:  config.CgroupRoot,
+		symbols:     config.Symbols,
 		semaphore:   semaphore,
+		jobs:        newJobManager(filepath.Join(config.WorkDir, "jobs"), config.Retention),
+		quotas:      newQuotaManager(config.Quota),
+
+		symbolRetention: config.SymbolRetention,
+		authorization:   config.Authorization,
+		continuous:      config.Continuous,
+		trigger:         config.Trigger,
+		cpuWatches:      make(map[string]*cpuWatch),
 	}, nil
 }
 
@@ -112,11 +185,19 @@ func (d *DaemonServer) Start() error {
 		return err
 	}
 
+	if err := d.jobs.setup(); err != nil {
+		return err
+	}
+
 	if err := d.setupContainer(); err != nil {
 		return err
 	}
 
-	if err := d.setupPerfExecuter(); err != nil {
+	if err := d.setupProfiler(); err != nil {
+		return err
+	}
+
+	if err := d.setupAuthorizer(); err != nil {
 		return err
 	}
 
@@ -141,6 +222,10 @@ func (d *DaemonServer) Start() error {
 	g.Add(func() error {
 		m := http.NewServeMux()
 		m.Handle("/metrics", metricsHandler)
+		// The gRPC health check of kubelet cannot be used when the gRPC server is served over TLS.
+		m.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
 		metricsServer.Handler = m
 		d.logger.Info("metrics server is running", "port", d.metricsPort)
 		return metricsServer.ListenAndServe()
@@ -150,20 +235,45 @@ func (d *DaemonServer) Start() error {
 		}
 	})
 
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return d.sweepJobs(ctx)
+	}, func(err error) {
+		cancel()
+		d.jobs.shutdown()
+	})
+
+	if d.continuous.Sink != nil {
+		continuousCtx, cancelContinuous := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return d.runContinuous(continuousCtx)
+		}, func(err error) {
+			cancelContinuous()
+		})
+	}
+
+	if d.trigger.Sink != nil {
+		triggerCtx, cancelTrigger := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return d.runTrigger(triggerCtx)
+		}, func(err error) {
+			cancelTrigger()
+		})
+	}
+
 	return g.Run()
 }
 
 func (d *DaemonServer) setupWorkDir() error {
-	_, err := os.Stat(d.workDir)
-	if err == nil {
-		return nil
-	}
-	if !os.IsNotExist(err) {
+	if err := os.MkdirAll(d.workDir, 0755); err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(d.workDir, 0755); err != nil {
-		return err
+	// Remove temporary files left by the previous process.
+	for _, name := range []string{constants.ProfileDirName, constants.ScriptDirName} {
+		if err := os.RemoveAll(filepath.Join(d.workDir, name)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -179,11 +289,16 @@ func (d *DaemonServer) setupContainer() error {
 	return nil
 }
 
-func (d *DaemonServer) setupPerfExecuter() error {
-	perfExecuter, err := resource.NewPerfExecuter(d.logger)
+func (d *DaemonServer) setupProfiler() error {
+	perfExecuter, err := resource.NewPerfExecuter(d.logger, d.perfPath)
+	if err != nil {
+		return err
+	}
+	// The build-ID cache is kept across the restarts not to look up the debug files again.
+	err = perfExecuter.EnableSymbolization(filepath.Join(d.workDir, constants.BuildIDDirName), d.symbols)
 	if err != nil {
 		return err
 	}
-	d.perfExecuter = perfExecuter
+	d.profiler = perfExecuter
 	return nil
 }
@@ -1,32 +1,191 @@
 package daemon
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"maps"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/cybozu-go/necoperf/internal/resource"
 	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/google/pprof/profile"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/types/known/durationpb"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	apitesting "k8s.io/cri-api/pkg/apis/testing"
 )
 
 const (
 	tooLongTimeout = 10 * time.Hour
 	timeout        = 1 * time.Second
 	containerID    = "test-container"
+	containerPID   = 1234
+	scriptPath     = "../perfscript/testdata/cpu-clock.script"
 )
 
-func server(ctx context.Context) (rpc.NecoPerfClient, func()) {
+// fakeRuntimeService returns the PID and the cgroup of the containers, which apitesting.FakeRuntimeService does not.
+// The cgroup of each container is "/kubepods/ID".
+type fakeRuntimeService struct {
+	*apitesting.FakeRuntimeService
+	pids map[string]int
+}
+
+func (f *fakeRuntimeService) ContainerStatus(ctx context.Context, containerID string, verbose bool) (*runtimeapi.ContainerStatusResponse, error) {
+	resp, err := f.FakeRuntimeService.ContainerStatus(ctx, containerID, verbose)
+	if err != nil {
+		return nil, err
+	}
+	resp.Info = map[string]string{
+		"info": fmt.Sprintf(`{"pid": %d, "runtimeSpec": {"linux": {"cgroupsPath": "/kubepods/%s"}}}`, f.pids[containerID], containerID),
+	}
+	return resp, nil
+}
+
+// runningContainer returns a container in the sandbox with the labels set by kubelet.
+func runningContainer(id, name string, sandbox *apitesting.FakePodSandbox) *apitesting.FakeContainer {
+	labels := maps.Clone(sandbox.Labels)
+	labels[constants.CRILabelContainerName] = name
+	return &apitesting.FakeContainer{
+		ContainerStatus: runtimeapi.ContainerStatus{
+			Id: id,
+			Metadata: &runtimeapi.ContainerMetadata{
+				Name: name,
+			},
+			State:  runtimeapi.ContainerState_CONTAINER_RUNNING,
+			Labels: labels,
+		},
+		SandboxID: sandbox.Id,
+	}
+}
+
+func podSandbox(id, namespace, name string) *apitesting.FakePodSandbox {
+	return &apitesting.FakePodSandbox{
+		PodSandboxStatus: runtimeapi.PodSandboxStatus{
+			Id: id,
+			Labels: map[string]string{
+				constants.CRILabelPodNamespace: namespace,
+				constants.CRILabelPodName:      name,
+			},
+		},
+	}
+}
+
+func newTestDaemonServer(t *testing.T, profiler resource.Profiler) *DaemonServer {
+	t.Helper()
+
+	// The PIDs of "app" and "sidecar" are the ones in the samples of the test script.
+	testPod := podSandbox("test-pod", "test", "test-pod")
+	appPod := podSandbox("app-pod", "app", "app-pod")
+	// The containers of "app-pod" are the targets of the continuous and triggered profiling.
+	appPod.Annotations = map[string]string{
+		constants.AnnotationContinuousProfiling: "true",
+		constants.AnnotationCPUThreshold:        "500m",
+	}
+	runtime := &fakeRuntimeService{
+		FakeRuntimeService: &apitesting.FakeRuntimeService{
+			Containers: map[string]*apitesting.FakeContainer{
+				containerID: runningContainer(containerID, "test", testPod),
+				"app":       runningContainer("app", "app", appPod),
+				"sidecar":   runningContainer("sidecar", "sidecar", appPod),
+			},
+			Sandboxes: map[string]*apitesting.FakePodSandbox{
+				testPod.Id: testPod,
+				appPod.Id:  appPod,
+			},
+		},
+		pids: map[string]int{
+			containerID: containerPID,
+			"app":       12345,
+			"sidecar":   12400,
+		},
+	}
+	logger := slog.New(slog.DiscardHandler)
+	workDir := t.TempDir()
+
+	d := &DaemonServer{
+		logger:    logger,
+		workDir:   workDir,
+		semaphore: semaphore.NewWeighted(maxWorkers),
+		container: resource.NewContainer(logger, runtime),
+		profiler:  profiler,
+		jobs:      newJobManager(filepath.Join(workDir, "jobs"), time.Hour),
+		quotas:    newQuotaManager(QuotaConfig{}),
+
+		cpuWatches: make(map[string]*cpuWatch),
+	}
+	if err := d.jobs.setup(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func newFakeProfiler(t *testing.T) *resource.FakeProfiler {
+	t.Helper()
+
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resource.FakeProfiler{Script: script}
+}
+
+// receive returns the data sent by the stream until it ends.
+func receive(stream grpc.ServerStreamingClient[rpc.PerfProfileResponse]) ([]byte, error) {
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+		data = append(data, resp.GetData()...)
+	}
+}
+
+// assertNoTemporaryFiles checks that the temporary files in the work directory are removed.
+func assertNoTemporaryFiles(t *testing.T, workDir string) {
+	t.Helper()
+
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		for _, name := range []string{constants.ProfileDirName, constants.ScriptDirName} {
+			entries, err := os.ReadDir(filepath.Join(workDir, name))
+			if !os.IsNotExist(err) {
+				assert.NoError(c, err)
+			}
+			assert.Empty(c, entries)
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func server(ctx context.Context, d *DaemonServer) (rpc.NecoPerfClient, func()) {
 	buffer := 1024 * 1024
 	lis := bufconn.Listen(buffer)
 
 	serv := grpc.NewServer()
-	rpc.RegisterNecoPerfServer(serv, &DaemonServer{})
+	rpc.RegisterNecoPerfServer(serv, d)
 	go func() {
 		if err := serv.Serve(lis); err != nil {
 			log.Fatal(err)
@@ -62,7 +221,7 @@ func server(ctx context.Context) (rpc.NecoPerfClient, func()) {
 
 func TestProfile(t *testing.T) {
 	ctx := context.Background()
-	client, closer := server(ctx)
+	client, closer := server(ctx, newTestDaemonServer(t, newFakeProfiler(t)))
 	defer closer()
 
 	type expected struct {
@@ -93,6 +252,54 @@ func TestProfile(t *testing.T) {
 				err: fmt.Errorf("rpc error: code = InvalidArgument desc = timeout is too long %q", tooLongTimeout),
 			},
 		},
+		"tooHighFrequency": {
+			in: &rpc.PerfProfileRequest{
+				ContainerId: containerID,
+				Timeout:     durationpb.New(timeout),
+				RecordOptions: &rpc.PerfRecordOptions{
+					Frequency: 10000,
+				},
+			},
+			expected: expected{
+				out: nil,
+				err: fmt.Errorf("rpc error: code = InvalidArgument desc = invalid record options: frequency must be between 1 and 999: 10000"),
+			},
+		},
+		"notAllowedCallGraph": {
+			in: &rpc.PerfProfileRequest{
+				ContainerId: containerID,
+				Timeout:     durationpb.New(timeout),
+				RecordOptions: &rpc.PerfRecordOptions{
+					CallGraph: "unknown",
+				},
+			},
+			expected: expected{
+				out: nil,
+				err: fmt.Errorf("rpc error: code = InvalidArgument desc = invalid record options: call graph \"unknown\" is not allowed"),
+			},
+		},
+		"unsupportedOutputFormat": {
+			in: &rpc.PerfProfileRequest{
+				ContainerId:  containerID,
+				Timeout:      durationpb.New(timeout),
+				OutputFormat: rpc.OutputFormat(100),
+			},
+			expected: expected{
+				out: nil,
+				err: fmt.Errorf("rpc error: code = InvalidArgument desc = output format 100 is not supported"),
+			},
+		},
+		"unsupportedProfileType": {
+			in: &rpc.PerfProfileRequest{
+				ContainerId: containerID,
+				Timeout:     durationpb.New(timeout),
+				ProfileType: rpc.ProfileType(100),
+			},
+			expected: expected{
+				out: nil,
+				err: fmt.Errorf("rpc error: code = InvalidArgument desc = profile type 100 is not supported"),
+			},
+		},
 		"notSetContainerID": {
 			in: &rpc.PerfProfileRequest{
 				Timeout: durationpb.New(timeout),
@@ -119,5 +326,663 @@ func TestProfile(t *testing.T) {
 			}
 		})
 	}
+}
+
+func TestProfileJob(t *testing.T) {
+	ctx := context.Background()
+	client, closer := server(ctx, newTestDaemonServer(t, newFakeProfiler(t)))
+	defer closer()
+
+	_, err := client.StartProfile(ctx, &rpc.PerfProfileRequest{
+		Timeout: durationpb.New(timeout),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Want: %s\nGot: %v", codes.InvalidArgument, err)
+	}
+
+	_, err = client.GetProfileStatus(ctx, &rpc.ProfileJobRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Want: %s\nGot: %v", codes.InvalidArgument, err)
+	}
+
+	_, err = client.CancelProfile(ctx, &rpc.CancelProfileRequest{JobId: "non-existent"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Want: %s\nGot: %v", codes.NotFound, err)
+	}
+
+	stream, err := client.FetchProfile(ctx, &rpc.FetchProfileRequest{JobId: "non-existent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Want: %s\nGot: %v", codes.NotFound, err)
+	}
+}
+
+func TestProfileStream(t *testing.T) {
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		format rpc.OutputFormat
+		check  func(t *testing.T, data []byte)
+	}{
+		"script": {
+			format: rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT,
+			check: func(t *testing.T, data []byte) {
+				assert.Equal(t, script, data)
+			},
+		},
+		"pprof": {
+			format: rpc.OutputFormat_OUTPUT_FORMAT_PPROF,
+			check: func(t *testing.T, data []byte) {
+				p, err := profile.ParseData(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Len(t, p.Sample, 4)
+			},
+		},
+		"folded": {
+			format: rpc.OutputFormat_OUTPUT_FORMAT_FOLDED,
+			check: func(t *testing.T, data []byte) {
+				folded, err := perfscript.ParseFolded(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, int64(4), folded.Total())
+			},
+		},
+		"raw": {
+			format: rpc.OutputFormat_OUTPUT_FORMAT_RAW,
+			check: func(t *testing.T, data []byte) {
+				tr := tar.NewReader(bytes.NewReader(data))
+				hdr, err := tr.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, constants.ProfilingFileName, hdr.Name)
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			profiler := newFakeProfiler(t)
+			d := newTestDaemonServer(t, profiler)
+			client, closer := server(ctx, d)
+			defer closer()
+
+			stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+				ContainerId:  containerID,
+				Timeout:      durationpb.New(100 * time.Millisecond),
+				OutputFormat: tt.format,
+				RecordOptions: &rpc.PerfRecordOptions{
+					Frequency: 49,
+					CallGraph: "fp",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := receive(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, data)
+
+			opts := resource.DefaultRecordOptions()
+			opts.Frequency = 49
+			opts.CallGraph = "fp"
+			opts.DwarfStackSize = 0
+			assert.Equal(t, []resource.FakeRecord{{
+				PIDs:    []int{containerPID},
+				Timeout: 100 * time.Millisecond,
+				Options: opts,
+			}}, profiler.Records())
+			assert.Equal(t, [][]int{{containerPID}}, profiler.Symbolized())
+			assertNoTemporaryFiles(t, d.workDir)
+		})
+	}
+}
+
+func TestProfileStreamError(t *testing.T) {
+	tests := map[string]struct {
+		containerID string
+		recordErr   error
+		scriptErr   error
+		expected    string
+	}{
+		"containerNotFound": {
+			containerID: "non-existent",
+			expected:    `rpc error: code = Unknown desc = container "non-existent" not found`,
+		},
+		"recordFailed": {
+			containerID: containerID,
+			recordErr:   errors.New("perf record is failed"),
+			expected:    "rpc error: code = Unknown desc = perf record is failed",
+		},
+		"scriptFailed": {
+			containerID: containerID,
+			scriptErr:   errors.New("perf script is failed"),
+			expected:    "rpc error: code = Unknown desc = perf script is failed",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			profiler := newFakeProfiler(t)
+			profiler.RecordErr = tt.recordErr
+			profiler.ScriptErr = tt.scriptErr
+			d := newTestDaemonServer(t, profiler)
+			client, closer := server(ctx, d)
+			defer closer()
+
+			stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+				ContainerId: tt.containerID,
+				Timeout:     durationpb.New(10 * time.Millisecond),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = receive(stream)
+			assert.EqualError(t, err, tt.expected)
+			assertNoTemporaryFiles(t, d.workDir)
+		})
+	}
+}
+
+func TestProfileStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	profiler := newFakeProfiler(t)
+	d := newTestDaemonServer(t, profiler)
+	client, closer := server(context.Background(), d)
+	defer closer()
+
+	stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		return len(profiler.Records()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	_, err = receive(stream)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	assertNoTemporaryFiles(t, d.workDir)
+}
+
+func TestProfileJobLifecycle(t *testing.T) {
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	d := newTestDaemonServer(t, newFakeProfiler(t))
+	client, closer := server(ctx, d)
+	defer closer()
+
+	waitPhase := func(jobID string, phase rpc.ProfilePhase) *rpc.ProfileStatus {
+		t.Helper()
+		var st *rpc.ProfileStatus
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			var err error
+			st, err = client.GetProfileStatus(ctx, &rpc.ProfileJobRequest{JobId: jobID})
+			assert.NoError(c, err)
+			assert.Equal(c, phase, st.GetPhase())
+		}, 5*time.Second, 10*time.Millisecond)
+		return st
+	}
+
+	resp, err := client.StartProfile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(100 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := waitPhase(resp.GetJobId(), rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED)
+	assert.Equal(t, int64(len(script)), st.GetSize())
+
+	stream, err := client.FetchProfile(ctx, &rpc.FetchProfileRequest{JobId: resp.GetJobId(), Offset: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := receive(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, script[100:], data)
+
+	stream, err = client.FetchProfile(ctx, &rpc.FetchProfileRequest{JobId: resp.GetJobId(), Offset: st.GetSize() + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = receive(stream)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+
+	resp, err = client.StartProfile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPhase(resp.GetJobId(), rpc.ProfilePhase_PROFILE_PHASE_RECORDING)
+	_, err = client.CancelProfile(ctx, &rpc.CancelProfileRequest{JobId: resp.GetJobId(), KeepPartial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st = waitPhase(resp.GetJobId(), rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED)
+	assert.Equal(t, "recording was stopped before the timeout", st.GetMessage())
+
+	resp, err = client.StartProfile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPhase(resp.GetJobId(), rpc.ProfilePhase_PROFILE_PHASE_RECORDING)
+	_, err = client.CancelProfile(ctx, &rpc.CancelProfileRequest{JobId: resp.GetJobId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPhase(resp.GetJobId(), rpc.ProfilePhase_PROFILE_PHASE_CANCELLED)
+	stream, err = client.FetchProfile(ctx, &rpc.FetchProfileRequest{JobId: resp.GetJobId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = receive(stream)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	d.jobs.shutdown()
+	assertNoTemporaryFiles(t, d.workDir)
+}
+
+func TestProfileStreamOptions(t *testing.T) {
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		chunkSize   uint32
+		compression rpc.Compression
+		decompress  func(data []byte) ([]byte, error)
+		chunks      []int
+		err         string
+	}{
+		"chunked": {
+			chunkSize: 1024,
+			chunks:    []int{1024, len(script) - 1024},
+		},
+		"gzip": {
+			compression: rpc.Compression_COMPRESSION_GZIP,
+			decompress: func(data []byte) ([]byte, error) {
+				r, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					return nil, err
+				}
+				return io.ReadAll(r)
+			},
+		},
+		"zstd": {
+			compression: rpc.Compression_COMPRESSION_ZSTD,
+			decompress: func(data []byte) ([]byte, error) {
+				dec, err := zstd.NewReader(nil)
+				if err != nil {
+					return nil, err
+				}
+				defer dec.Close()
+				return dec.DecodeAll(data, nil)
+			},
+		},
+		"tooSmallChunkSize": {
+			chunkSize: 100,
+			err:       "rpc error: code = InvalidArgument desc = chunk size must be between 1024 and 1048576: 100",
+		},
+		"unsupportedCompression": {
+			compression: rpc.Compression(100),
+			err:         "rpc error: code = InvalidArgument desc = compression 100 is not supported",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			client, closer := server(ctx, newTestDaemonServer(t, newFakeProfiler(t)))
+			defer closer()
+
+			stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+				ContainerId: containerID,
+				Timeout:     durationpb.New(10 * time.Millisecond),
+				ChunkSize:   tt.chunkSize,
+				Compression: tt.compression,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var data []byte
+			var chunks []int
+			for {
+				resp, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if len(tt.err) != 0 {
+					assert.EqualError(t, err, tt.err)
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := resp.GetEvent().(*rpc.PerfProfileResponse_Data); !ok {
+					continue
+				}
+				data = append(data, resp.GetData()...)
+				chunks = append(chunks, len(resp.GetData()))
+			}
+
+			md, err := stream.Header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, []string{tt.compression.String()}, md.Get(constants.CompressionHeader))
+			if tt.chunks != nil {
+				assert.Equal(t, tt.chunks, chunks)
+			}
+			if tt.decompress != nil {
+				data, err = tt.decompress(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			assert.Equal(t, script, data)
+		})
+	}
+}
 
+func TestProfileMultipleContainers(t *testing.T) {
+	tests := map[string]struct {
+		format   rpc.OutputFormat
+		expected string
+	}{
+		"script": {
+			format: rpc.OutputFormat_OUTPUT_FORMAT_SCRIPT,
+			expected: `# container: app id=app pid=12345
+# container: sidecar id=sidecar pid=12400
+#
+`,
+		},
+		"folded": {
+			format: rpc.OutputFormat_OUTPUT_FORMAT_FOLDED,
+			expected: `app;yes;__libc_start_call_main;main;__GI___libc_write;_raw_spin_unlock_irqrestore 1
+app;yes;__libc_start_call_main;main;full_write 2
+sidecar;Web Content;[unknown];std::vector<int>::push_back(int const&) 1
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			profiler := newFakeProfiler(t)
+			client, closer := server(ctx, newTestDaemonServer(t, profiler))
+			defer closer()
+
+			stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+				ContainerId:  "app",
+				ContainerIds: []string{"sidecar", "app"},
+				Timeout:      durationpb.New(10 * time.Millisecond),
+				OutputFormat: tt.format,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := receive(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, strings.HasPrefix(string(data), tt.expected), string(data))
+
+			records := profiler.Records()
+			if assert.Len(t, records, 1) {
+				assert.Equal(t, []int{12345, 12400}, records[0].PIDs)
+			}
+		})
+	}
+
+	ctx := context.Background()
+	client, closer := server(ctx, newTestDaemonServer(t, newFakeProfiler(t)))
+	defer closer()
+
+	stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerIds: []string{"app", "non-existent"},
+		Timeout:      durationpb.New(10 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = receive(stream)
+	assert.EqualError(t, err, `rpc error: code = Unknown desc = container "non-existent" not found`)
+}
+
+func TestProfileOffCPU(t *testing.T) {
+	ctx := context.Background()
+	script, err := os.ReadFile("../perfscript/testdata/off-cpu.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiler := &resource.FakeProfiler{Script: script}
+	client, closer := server(ctx, newTestDaemonServer(t, profiler))
+	defer closer()
+
+	stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerIds: []string{"app", "sidecar"},
+		Timeout:      durationpb.New(10 * time.Millisecond),
+		OutputFormat: rpc.OutputFormat_OUTPUT_FORMAT_FOLDED,
+		ProfileType:  rpc.ProfileType_PROFILE_TYPE_OFF_CPU,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := receive(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stacks are weighted by the blocked time in nanoseconds.
+	assert.Equal(t, `app;yes;main;__GI___libc_read;schedule;__schedule 50000000
+sidecar;Web Content;fsync;schedule;__schedule 30000000
+`, string(data))
+
+	records := profiler.Records()
+	if assert.Len(t, records, 1) {
+		assert.True(t, records[0].Options.OffCPU)
+	}
+}
+
+func TestProfileCgroup(t *testing.T) {
+	ctx := context.Background()
+	profiler := newFakeProfiler(t)
+	d := newTestDaemonServer(t, profiler)
+	d.cgroupRoot = t.TempDir()
+	for id, procs := range map[string]string{
+		"app":     "12345\n12346\n",
+		"sidecar": "12400\n",
+	} {
+		dir := filepath.Join(d.cgroupRoot, "kubepods", id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(procs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client, closer := server(ctx, d)
+	defer closer()
+
+	stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerIds: []string{"app", "sidecar"},
+		Timeout:      durationpb.New(10 * time.Millisecond),
+		OutputFormat: rpc.OutputFormat_OUTPUT_FORMAT_FOLDED,
+		Cgroup:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := receive(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(data), "sidecar;Web Content;")
+
+	records := profiler.Records()
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, []int{12345, 12346, 12400}, records[0].PIDs)
+	}
+
+	// The cgroup of the container does not exist.
+	stream, err = client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(10 * time.Millisecond),
+		Cgroup:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = receive(stream)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestProfileContainerRefs(t *testing.T) {
+	tests := map[string]struct {
+		refs    []*rpc.ContainerRef
+		code    codes.Code
+		message string
+		pids    []int
+	}{
+		"resolved": {
+			refs: []*rpc.ContainerRef{
+				{Namespace: "app", PodName: "app-pod", ContainerName: "sidecar"},
+				{Namespace: "app", PodName: "app-pod", ContainerName: "app"},
+			},
+			code: codes.OK,
+			pids: []int{12400, 12345},
+		},
+		"podNotOnNode": {
+			refs: []*rpc.ContainerRef{
+				{Namespace: "app", PodName: "other-pod", ContainerName: "app"},
+			},
+			code:    codes.NotFound,
+			message: "pod app/other-pod is not on the node of this necoperf-daemon",
+		},
+		"containerNotRunning": {
+			refs: []*rpc.ContainerRef{
+				{Namespace: "app", PodName: "app-pod", ContainerName: "other"},
+			},
+			code:    codes.NotFound,
+			message: `container "other" is not running in pod app/app-pod`,
+		},
+		"noContainerName": {
+			refs: []*rpc.ContainerRef{
+				{Namespace: "app", PodName: "app-pod"},
+			},
+			code:    codes.InvalidArgument,
+			message: "namespace, pod name and container name must be set in container reference",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			profiler := newFakeProfiler(t)
+			client, closer := server(ctx, newTestDaemonServer(t, profiler))
+			defer closer()
+
+			stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+				ContainerRefs: tt.refs,
+				Timeout:       durationpb.New(10 * time.Millisecond),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = receive(stream)
+			st := status.Convert(err)
+			assert.Equal(t, tt.code, st.Code())
+			assert.Equal(t, tt.message, st.Message())
+
+			if tt.code == codes.OK {
+				records := profiler.Records()
+				if assert.Len(t, records, 1) {
+					assert.Equal(t, tt.pids, records[0].PIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestProfileProgress(t *testing.T) {
+	ctx := context.Background()
+	profiler := newFakeProfiler(t)
+	profiler.Samples = 42
+	d := newTestDaemonServer(t, profiler)
+	d.semaphore = semaphore.NewWeighted(1)
+	client, closer := server(ctx, d)
+	defer closer()
+
+	// Make the session wait for perf.
+	if err := d.semaphore.Acquire(ctx, weight); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(10 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(1), resp.GetQueued().GetPosition())
+	d.semaphore.Release(weight)
+
+	var events []string
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch e := resp.GetEvent().(type) {
+		case *rpc.PerfProfileResponse_Recording:
+			assert.Equal(t, 10*time.Millisecond, e.Recording.GetTimeout().AsDuration())
+			events = append(events, fmt.Sprintf("recording %d", e.Recording.GetSamples()))
+		case *rpc.PerfProfileResponse_Converting:
+			events = append(events, fmt.Sprintf("converting %d", e.Converting.GetSamples()))
+		case *rpc.PerfProfileResponse_Data:
+			data = append(data, e.Data...)
+		default:
+			t.Fatalf("unexpected event: %v", resp)
+		}
+	}
+	assert.Equal(t, []string{"recording 0", "recording 42", "converting 42"}, events)
+	assert.Equal(t, profiler.Script, data)
 }
@@ -0,0 +1,352 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxActiveJobs = 8
+	sweepInterval = 1 * time.Minute
+)
+
+type job struct {
+	mu              sync.Mutex
+	id              string
+	params          *profileParams
+	ctx             context.Context
+	cancelFunc      context.CancelFunc
+	recordCtx       context.Context
+	stopRecord      context.CancelFunc
+	partial         bool
+	phase           rpc.ProfilePhase
+	createTime      time.Time
+	recordStartTime time.Time
+	finishTime      time.Time
+	size            int64
+	message         string
+	queuePosition   int
+	samples         int
+}
+
+var _ progressReporter = &job{}
+
+func (j *job) queued(position int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.queuePosition = position
+}
+
+func (j *job) recording(elapsed time.Duration, samples int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if isFinished(j.phase) {
+		return
+	}
+	if j.phase != rpc.ProfilePhase_PROFILE_PHASE_RECORDING {
+		j.recordStartTime = time.Now()
+	}
+	j.phase = rpc.ProfilePhase_PROFILE_PHASE_RECORDING
+	j.queuePosition = 0
+	j.samples = samples
+}
+
+func (j *job) converting(samples int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if isFinished(j.phase) {
+		return
+	}
+	j.phase = rpc.ProfilePhase_PROFILE_PHASE_CONVERTING
+	j.samples = samples
+}
+
+// finish records the result of the job. It returns false if the job has already finished.
+func (j *job) finish(size int64, err error) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if isFinished(j.phase) {
+		return false
+	}
+	j.finishTime = time.Now()
+	switch {
+	case j.ctx.Err() != nil:
+		j.phase = rpc.ProfilePhase_PROFILE_PHASE_CANCELLED
+	case j.partial && errors.Is(err, context.Canceled):
+		// The recording was stopped before perf started.
+		j.phase = rpc.ProfilePhase_PROFILE_PHASE_CANCELLED
+	case err != nil:
+		j.phase = rpc.ProfilePhase_PROFILE_PHASE_FAILED
+		j.message = err.Error()
+	default:
+		j.phase = rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED
+		j.size = size
+		if j.partial {
+			j.message = "recording was stopped before the timeout"
+		}
+	}
+	return j.phase == rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED
+}
+
+// cancel cancels the job. If keepPartial is true and the job is recording,
+// only the recording is stopped and the data recorded so far is converted.
+func (j *job) cancel(keepPartial bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if isFinished(j.phase) {
+		return
+	}
+	if keepPartial && j.phase == rpc.ProfilePhase_PROFILE_PHASE_RECORDING {
+		j.partial = true
+		j.stopRecord()
+		return
+	}
+
+	j.cancelFunc()
+	j.phase = rpc.ProfilePhase_PROFILE_PHASE_CANCELLED
+	j.finishTime = time.Now()
+}
+
+func (j *job) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return isFinished(j.phase)
+}
+
+func (j *job) expired(now time.Time, retention time.Duration) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return isFinished(j.phase) && now.After(j.finishTime.Add(retention))
+}
+
+func (j *job) status(retention time.Duration) *rpc.ProfileStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	st := &rpc.ProfileStatus{
+		JobId:        j.id,
+		Phase:        j.phase,
+		OutputFormat: j.params.outputFormat,
+		Size:         j.size,
+		Message:      j.message,
+		CreateTime:   timestamppb.New(j.createTime),
+		Samples:      uint64(j.samples),
+	}
+
+	if j.params.recordOptions.OffCPU {
+		st.ProfileType = rpc.ProfileType_PROFILE_TYPE_OFF_CPU
+	}
+
+	switch j.phase {
+	case rpc.ProfilePhase_PROFILE_PHASE_PENDING:
+		st.QueuePosition = uint32(j.queuePosition)
+	case rpc.ProfilePhase_PROFILE_PHASE_RECORDING:
+		progress := float64(time.Since(j.recordStartTime)) / float64(j.params.timeout)
+		st.Progress = min(progress, 1)
+	case rpc.ProfilePhase_PROFILE_PHASE_CONVERTING, rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED:
+		st.Progress = 1
+	}
+	if isFinished(j.phase) {
+		st.FinishTime = timestamppb.New(j.finishTime)
+		st.ExpireTime = timestamppb.New(j.finishTime.Add(retention))
+	}
+
+	return st
+}
+
+func isFinished(phase rpc.ProfilePhase) bool {
+	switch phase {
+	case rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED,
+		rpc.ProfilePhase_PROFILE_PHASE_FAILED,
+		rpc.ProfilePhase_PROFILE_PHASE_CANCELLED:
+		return true
+	}
+	return false
+}
+
+// jobManager keeps the profiling jobs and their results in the directory until the retention period passes.
+type jobManager struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	dir       string
+	retention time.Duration
+	running   sync.WaitGroup
+}
+
+func newJobManager(dir string, retention time.Duration) *jobManager {
+	return &jobManager{
+		jobs:      make(map[string]*job),
+		dir:       dir,
+		retention: retention,
+	}
+}
+
+// setup removes the results left by the previous process, since the jobs are only kept in memory.
+func (m *jobManager) setup() error {
+	if err := os.RemoveAll(m.dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(m.dir, 0755)
+}
+
+func (m *jobManager) create(params *profileParams) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := 0
+	for _, j := range m.jobs {
+		if !j.finished() {
+			active++
+		}
+	}
+	if active >= maxActiveJobs {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many active jobs: %d", active)
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recordCtx, stopRecord := context.WithCancel(ctx)
+	j := &job{
+		id:         id.String(),
+		params:     params,
+		ctx:        ctx,
+		cancelFunc: cancel,
+		recordCtx:  recordCtx,
+		stopRecord: stopRecord,
+		phase:      rpc.ProfilePhase_PROFILE_PHASE_PENDING,
+		createTime: time.Now(),
+	}
+	m.jobs[j.id] = j
+
+	return j, nil
+}
+
+func (m *jobManager) get(id string) (*job, error) {
+	if len(id) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "job ID is not set")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %q is not found", id)
+	}
+	return j, nil
+}
+
+func (m *jobManager) resultPath(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+// sweep removes the jobs whose retention period has passed.
+func (m *jobManager) sweep(now time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	for id, j := range m.jobs {
+		if !j.expired(now, m.retention) {
+			continue
+		}
+		os.Remove(m.resultPath(id))
+		delete(m.jobs, id)
+		removed = append(removed, id)
+	}
+	return removed
+}
+
+// shutdown cancels all the jobs and waits for them to stop.
+func (m *jobManager) shutdown() {
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		j.cancel(false)
+	}
+	m.mu.Unlock()
+
+	m.running.Wait()
+}
+
+func (d *DaemonServer) startJob(j *job) {
+	d.jobs.running.Add(1)
+	go func() {
+		defer d.jobs.running.Done()
+		d.runJob(j)
+	}()
+}
+
+func (d *DaemonServer) runJob(j *job) {
+	defer j.cancelFunc()
+	defer d.quotas.release(j.params.quota)
+
+	resultPath := d.jobs.resultPath(j.id)
+	f, err := os.Create(resultPath)
+	if err == nil {
+		err = d.runProfile(j.ctx, j.recordCtx, j.params, j, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	var size int64
+	if err == nil {
+		var fi os.FileInfo
+		fi, err = os.Stat(resultPath)
+		if err == nil {
+			size = fi.Size()
+		}
+	}
+
+	if !j.finish(size, err) {
+		os.Remove(resultPath)
+	}
+
+	st := j.status(d.jobs.retention)
+	switch st.GetPhase() {
+	case rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED:
+		d.logger.Info("profiling job is finished", "jobID", j.id, "size", size)
+	case rpc.ProfilePhase_PROFILE_PHASE_FAILED:
+		d.logger.Error("profiling job is failed", "jobID", j.id, "error", err)
+	}
+}
+
+func (d *DaemonServer) sweepJobs(ctx context.Context) error {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			for _, id := range d.jobs.sweep(now) {
+				d.logger.Info("profiling job is expired", "jobID", id)
+			}
+			if err := d.profiler.SweepSymbols(now, d.symbolRetention); err != nil {
+				d.logger.Error("failed to sweep the build-ID cache", "error", err)
+			}
+		}
+	}
+}
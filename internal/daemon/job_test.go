@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestJobManager(t *testing.T) {
+	t.Parallel()
+
+	m := newJobManager(t.TempDir(), time.Hour)
+	if err := m.setup(); err != nil {
+		t.Fatal(err)
+	}
+	params := &profileParams{
+		timeout:      10 * time.Second,
+		outputFormat: rpc.OutputFormat_OUTPUT_FORMAT_PPROF,
+	}
+
+	_, err := m.get("")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = m.get("non-existent")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	succeeded, err := m.create(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := m.get(succeeded.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, succeeded, j)
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_PENDING, j.status(m.retention).GetPhase())
+
+	j.queued(2)
+	assert.Equal(t, uint32(2), j.status(m.retention).GetQueuePosition())
+
+	j.recording(0, 0)
+	j.recording(time.Second, 100)
+	j.recordStartTime = time.Now().Add(-5 * time.Second)
+	st := j.status(m.retention)
+	assert.InDelta(t, 0.5, st.GetProgress(), 0.1)
+	assert.Equal(t, uint32(0), st.GetQueuePosition())
+	assert.Equal(t, uint64(100), st.GetSamples())
+
+	j.converting(500)
+	st = j.status(m.retention)
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_CONVERTING, st.GetPhase())
+	assert.Equal(t, uint64(500), st.GetSamples())
+
+	if err := os.WriteFile(m.resultPath(j.id), []byte("result"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, j.finish(6, nil))
+	st = j.status(m.retention)
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED, st.GetPhase())
+	assert.Equal(t, int64(6), st.GetSize())
+	assert.Equal(t, float64(1), st.GetProgress())
+	assert.Equal(t, rpc.OutputFormat_OUTPUT_FORMAT_PPROF, st.GetOutputFormat())
+	assert.Equal(t, st.GetFinishTime().AsTime().Add(time.Hour), st.GetExpireTime().AsTime())
+
+	failed, err := m.create(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, failed.finish(0, errors.New("perf is failed")))
+	st = failed.status(m.retention)
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_FAILED, st.GetPhase())
+	assert.Equal(t, "perf is failed", st.GetMessage())
+
+	cancelled, err := m.create(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled.cancel(false)
+	assert.Equal(t, context.Canceled, cancelled.ctx.Err())
+	assert.False(t, cancelled.finish(0, context.Canceled))
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_CANCELLED, cancelled.status(m.retention).GetPhase())
+
+	removed := m.sweep(time.Now())
+	assert.Empty(t, removed)
+	removed = m.sweep(time.Now().Add(2 * time.Hour))
+	assert.ElementsMatch(t, []string{succeeded.id, failed.id, cancelled.id}, removed)
+	_, err = os.Stat(m.resultPath(succeeded.id))
+	assert.True(t, os.IsNotExist(err))
+	_, err = m.get(succeeded.id)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestJobCancelKeepPartial(t *testing.T) {
+	t.Parallel()
+
+	m := newJobManager(t.TempDir(), time.Hour)
+	params := &profileParams{timeout: 10 * time.Second}
+
+	pending, err := m.create(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.cancel(true)
+	assert.Equal(t, context.Canceled, pending.ctx.Err())
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_CANCELLED, pending.status(m.retention).GetPhase())
+
+	recording, err := m.create(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording.recording(0, 0)
+	recording.cancel(true)
+	assert.Equal(t, context.Canceled, recording.recordCtx.Err())
+	assert.NoError(t, recording.ctx.Err())
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_RECORDING, recording.status(m.retention).GetPhase())
+	assert.True(t, recording.finish(6, nil))
+	st := recording.status(m.retention)
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_SUCCEEDED, st.GetPhase())
+	assert.Equal(t, "recording was stopped before the timeout", st.GetMessage())
+
+	notStarted, err := m.create(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notStarted.recording(0, 0)
+	notStarted.cancel(true)
+	assert.False(t, notStarted.finish(0, fmt.Errorf("perf record is stopped: %w", context.Canceled)))
+	assert.Equal(t, rpc.ProfilePhase_PROFILE_PHASE_CANCELLED, notStarted.status(m.retention).GetPhase())
+}
+
+func TestJobManagerTooManyJobs(t *testing.T) {
+	t.Parallel()
+
+	m := newJobManager(t.TempDir(), time.Hour)
+	params := &profileParams{}
+	for i := 0; i < maxActiveJobs; i++ {
+		if _, err := m.create(params); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.create(params)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
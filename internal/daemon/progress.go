@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// progressInterval is the interval to check the position of a session waiting for perf.
+const progressInterval = 1 * time.Second
+
+// progressReporter is notified of the progress of a profiling session.
+// The methods are called sequentially from runProfile.
+type progressReporter interface {
+	// queued is called when the session starts waiting for perf, and whenever its position changes.
+	queued(position int)
+	// recording is called when perf record starts, and periodically while recording.
+	recording(elapsed time.Duration, samples int)
+	// converting is called when the recorded data starts to be converted.
+	converting(samples int)
+}
+
+// nopProgress discards the progress of the sessions which cannot report it.
+type nopProgress struct{}
+
+var _ progressReporter = nopProgress{}
+
+func (nopProgress) queued(position int)                          {}
+func (nopProgress) recording(elapsed time.Duration, samples int) {}
+func (nopProgress) converting(samples int)                       {}
+
+// streamProgress sends the progress to the client of Profile.
+// The errors of sending are ignored, since the session is stopped by the context of the stream when the client has gone.
+type streamProgress struct {
+	stream  grpc.ServerStreamingServer[rpc.PerfProfileResponse]
+	timeout time.Duration
+}
+
+var _ progressReporter = &streamProgress{}
+
+func (p *streamProgress) queued(position int) {
+	p.stream.Send(&rpc.PerfProfileResponse{
+		Event: &rpc.PerfProfileResponse_Queued{
+			Queued: &rpc.ProfileQueued{
+				Position: uint32(position),
+			},
+		},
+	})
+}
+
+func (p *streamProgress) recording(elapsed time.Duration, samples int) {
+	p.stream.Send(&rpc.PerfProfileResponse{
+		Event: &rpc.PerfProfileResponse_Recording{
+			Recording: &rpc.ProfileRecording{
+				Elapsed: durationpb.New(elapsed),
+				Timeout: durationpb.New(p.timeout),
+				Samples: uint64(samples),
+			},
+		},
+	})
+}
+
+func (p *streamProgress) converting(samples int) {
+	p.stream.Send(&rpc.PerfProfileResponse{
+		Event: &rpc.PerfProfileResponse_Converting{
+			Converting: &rpc.ProfileConverting{
+				Samples: uint64(samples),
+			},
+		},
+	})
+}
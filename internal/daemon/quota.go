@@ -0,0 +1,272 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// quotaWindow is the period in which the profiling time of each namespace is limited.
+const quotaWindow = time.Hour
+
+// QuotaConfig is the configuration of the admission control of profiling sessions.
+// The zero values mean unlimited.
+type QuotaConfig struct {
+	// MaxSessions is the maximum number of concurrent profiling sessions per namespace.
+	MaxSessions int `json:"maxSessions,omitempty"`
+	// ProfileTimePerHour is the maximum total recording time per namespace in the last hour.
+	ProfileTimePerHour metav1.Duration `json:"profileTimePerHour,omitempty"`
+	// MaxQueueLength is the maximum number of sessions waiting for perf to be available.
+	MaxQueueLength int `json:"maxQueueLength,omitempty"`
+	// MaxQueueWait is the maximum time for a session to wait for perf to be available.
+	MaxQueueWait metav1.Duration `json:"maxQueueWait,omitempty"`
+	// Namespaces overrides the quotas for each namespace.
+	Namespaces map[string]NamespaceQuota `json:"namespaces,omitempty"`
+}
+
+// NamespaceQuota is the quotas of a namespace. The fields which are not set are inherited from QuotaConfig.
+type NamespaceQuota struct {
+	MaxSessions        *int             `json:"maxSessions,omitempty"`
+	ProfileTimePerHour *metav1.Duration `json:"profileTimePerHour,omitempty"`
+}
+
+// LoadQuotaConfig reads the quota configuration from the YAML file.
+// The fields which are not in the file are kept as they are in config.
+func LoadQuotaConfig(path string, config *QuotaConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *QuotaConfig) maxSessions(namespace string) int {
+	if q, ok := c.Namespaces[namespace]; ok && q.MaxSessions != nil {
+		return *q.MaxSessions
+	}
+	return c.MaxSessions
+}
+
+func (c *QuotaConfig) profileTimePerHour(namespace string) time.Duration {
+	if q, ok := c.Namespaces[namespace]; ok && q.ProfileTimePerHour != nil {
+		return q.ProfileTimePerHour.Duration
+	}
+	return c.ProfileTimePerHour.Duration
+}
+
+// quotaSession is an admitted profiling session.
+type quotaSession struct {
+	namespaces []string
+	start      time.Time
+	timeout    time.Duration
+	// usages are the recording time charged to the namespaces.
+	// They are reserved for the timeout on admission, and corrected when the session is released.
+	usages   []*quotaUsage
+	mu       sync.Mutex
+	recorded time.Duration
+}
+
+// setRecorded records how long perf record ran. It is safe to call on nil.
+func (s *quotaSession) setRecorded(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorded = d
+}
+
+type quotaUsage struct {
+	time     time.Time
+	duration time.Duration
+}
+
+// quotaManager limits the profiling sessions by QuotaConfig.
+type quotaManager struct {
+	mu       sync.Mutex
+	config   QuotaConfig
+	sessions []*quotaSession
+	usages   map[string][]*quotaUsage
+	// queue is the IDs of the sessions waiting for perf in the order of arrival.
+	queue  []uint64
+	lastID uint64
+	now    func() time.Time
+}
+
+func newQuotaManager(config QuotaConfig) *quotaManager {
+	return &quotaManager{
+		config: config,
+		usages: make(map[string][]*quotaUsage),
+		now:    time.Now,
+	}
+}
+
+// resourceExhausted returns a ResourceExhausted error with the hint of when to retry.
+func resourceExhausted(retryAfter time.Duration, format string, a ...any) error {
+	st := status.Newf(codes.ResourceExhausted, format, a...)
+	retryAfter = max(retryAfter, time.Second).Round(time.Second)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// admit starts a session for the namespaces if it does not exceed their quotas.
+func (m *quotaManager) admit(namespaces []string, timeout time.Duration) (*quotaSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for _, ns := range namespaces {
+		if limit := m.config.maxSessions(ns); limit > 0 {
+			var active []*quotaSession
+			for _, s := range m.sessions {
+				if slices.Contains(s.namespaces, ns) {
+					active = append(active, s)
+				}
+			}
+			if len(active) >= limit {
+				return nil, resourceExhausted(earliestEnd(active, now),
+					"too many profiling sessions in namespace %q: the limit is %d", ns, limit)
+			}
+		}
+
+		if budget := m.config.profileTimePerHour(ns); budget > 0 {
+			if timeout > budget {
+				return nil, status.Errorf(codes.InvalidArgument, "timeout %s exceeds the profiling time per hour of namespace %q: %s", timeout, ns, budget)
+			}
+			usages := m.activeUsages(ns, now)
+			var used time.Duration
+			for _, u := range usages {
+				used += u.duration
+			}
+			if used+timeout > budget {
+				// Wait until the usages expire enough to run the session.
+				var retryAfter time.Duration
+				for _, u := range usages {
+					used -= u.duration
+					retryAfter = u.time.Add(quotaWindow).Sub(now)
+					if used+timeout <= budget {
+						break
+					}
+				}
+				return nil, resourceExhausted(retryAfter,
+					"profiling time of namespace %q in the last hour exceeds the limit %s", ns, budget)
+			}
+		}
+	}
+
+	s := &quotaSession{
+		namespaces: namespaces,
+		start:      now,
+		timeout:    timeout,
+	}
+	for _, ns := range namespaces {
+		u := &quotaUsage{time: now, duration: timeout}
+		s.usages = append(s.usages, u)
+		m.usages[ns] = append(m.usages[ns], u)
+	}
+	m.sessions = append(m.sessions, s)
+	return s, nil
+}
+
+// activeUsages returns the usages of the namespace in the window, and drops the expired ones.
+func (m *quotaManager) activeUsages(ns string, now time.Time) []*quotaUsage {
+	usages := slices.DeleteFunc(m.usages[ns], func(u *quotaUsage) bool {
+		return !u.time.Add(quotaWindow).After(now)
+	})
+	if len(usages) == 0 {
+		delete(m.usages, ns)
+		return nil
+	}
+	m.usages[ns] = usages
+	return usages
+}
+
+// release finishes the session and charges the namespaces for the time perf record actually ran.
+// It is safe to call with nil.
+func (m *quotaManager) release(s *quotaSession) {
+	if s == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.mu.Lock()
+	recorded := min(s.recorded, s.timeout)
+	s.mu.Unlock()
+	for _, u := range s.usages {
+		u.duration = recorded
+	}
+	m.sessions = slices.DeleteFunc(m.sessions, func(x *quotaSession) bool {
+		return x == s
+	})
+}
+
+// enterQueue registers a session waiting for perf to be available and returns its ID in the queue.
+func (m *quotaManager) enterQueue() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.config.MaxQueueLength > 0 && len(m.queue) >= m.config.MaxQueueLength {
+		return 0, resourceExhausted(earliestEnd(m.sessions, m.now()),
+			"too many profiling sessions are waiting: the limit is %d", m.config.MaxQueueLength)
+	}
+	m.lastID++
+	m.queue = append(m.queue, m.lastID)
+	return m.lastID, nil
+}
+
+func (m *quotaManager) leaveQueue(id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queue = slices.DeleteFunc(m.queue, func(x uint64) bool {
+		return x == id
+	})
+}
+
+// queuePosition returns the position of the session in the queue. 1 means the session runs next.
+func (m *quotaManager) queuePosition(id uint64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Index(m.queue, id) + 1
+}
+
+// queueTimeoutError returns the error for a session which waited for perf too long.
+func (m *quotaManager) queueTimeoutError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return resourceExhausted(earliestEnd(m.sessions, m.now()),
+		"perf is not available within %s", m.config.MaxQueueWait.Duration)
+}
+
+// earliestEnd returns the time until the first of the sessions is expected to end.
+func earliestEnd(sessions []*quotaSession, now time.Time) time.Duration {
+	var d time.Duration
+	for i, s := range sessions {
+		end := s.start.Add(s.timeout).Sub(now)
+		if i == 0 || end < d {
+			d = end
+		}
+	}
+	return d
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// retryDelay returns the retry delay in the details of the error.
+func retryDelay(t *testing.T, err error) time.Duration {
+	t.Helper()
+
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	t.Fatalf("no retry info in %v", err)
+	return 0
+}
+
+func newTestQuotaManager(config QuotaConfig, now *time.Time) *quotaManager {
+	m := newQuotaManager(config)
+	m.now = func() time.Time { return *now }
+	return m
+}
+
+func TestQuotaMaxSessions(t *testing.T) {
+	one := 1
+	now := time.Now()
+	m := newTestQuotaManager(QuotaConfig{
+		MaxSessions: 2,
+		Namespaces: map[string]NamespaceQuota{
+			"small": {MaxSessions: &one},
+		},
+	}, &now)
+
+	s1, err := m.admit([]string{"test"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(10 * time.Second)
+	_, err = m.admit([]string{"test", "small"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.admit([]string{"test"}, time.Minute)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 50*time.Second, retryDelay(t, err))
+
+	_, err = m.admit([]string{"small"}, time.Minute)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = m.admit([]string{"other"}, time.Minute)
+	assert.NoError(t, err)
+
+	m.release(s1)
+	_, err = m.admit([]string{"test"}, time.Minute)
+	assert.NoError(t, err)
+}
+
+func TestQuotaProfileTimePerHour(t *testing.T) {
+	now := time.Now()
+	m := newTestQuotaManager(QuotaConfig{
+		ProfileTimePerHour: metav1.Duration{Duration: 5 * time.Minute},
+	}, &now)
+
+	_, err := m.admit([]string{"test"}, 10*time.Minute)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	s1, err := m.admit([]string{"test"}, 3*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1.setRecorded(time.Minute)
+	m.release(s1)
+
+	// The first session is charged for the recorded time, not for the timeout.
+	now = now.Add(10 * time.Minute)
+	s2, err := m.admit([]string{"test"}, 3*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2.setRecorded(3 * time.Minute)
+	m.release(s2)
+
+	now = now.Add(10 * time.Minute)
+	_, err = m.admit([]string{"test"}, 2*time.Minute)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// The first session expires 40 minutes later.
+	assert.Equal(t, 40*time.Minute, retryDelay(t, err))
+
+	_, err = m.admit([]string{"test"}, 3*time.Minute)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// Both sessions must expire.
+	assert.Equal(t, 50*time.Minute, retryDelay(t, err))
+
+	now = now.Add(time.Hour)
+	_, err = m.admit([]string{"test"}, 5*time.Minute)
+	assert.NoError(t, err)
+}
+
+func TestQuotaQueue(t *testing.T) {
+	now := time.Now()
+	m := newTestQuotaManager(QuotaConfig{MaxQueueLength: 2}, &now)
+
+	id1, err := m.enterQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := m.enterQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, m.queuePosition(id1))
+	assert.Equal(t, 2, m.queuePosition(id2))
+
+	_, err = m.enterQueue()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	m.leaveQueue(id1)
+	assert.Equal(t, 1, m.queuePosition(id2))
+	id3, err := m.enterQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, m.queuePosition(id3))
+}
+
+func TestLoadQuotaConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.yaml")
+	err := os.WriteFile(path, []byte(`maxSessions: 2
+profileTimePerHour: 10m
+namespaces:
+  batch:
+    maxSessions: 4
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := QuotaConfig{
+		MaxSessions:  1,
+		MaxQueueWait: metav1.Duration{Duration: time.Minute},
+	}
+	if err := LoadQuotaConfig(path, &config); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, config.maxSessions("test"))
+	assert.Equal(t, 4, config.maxSessions("batch"))
+	assert.Equal(t, 10*time.Minute, config.profileTimePerHour("batch"))
+	assert.Equal(t, time.Minute, config.MaxQueueWait.Duration)
+
+	if err := os.WriteFile(path, []byte("maxSession: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, LoadQuotaConfig(path, &config))
+}
+
+func TestProfileQuota(t *testing.T) {
+	ctx := context.Background()
+	profiler := newFakeProfiler(t)
+	d := newTestDaemonServer(t, profiler)
+	d.quotas = newQuotaManager(QuotaConfig{
+		MaxSessions:  1,
+		MaxQueueWait: metav1.Duration{Duration: 10 * time.Millisecond},
+	})
+	d.semaphore = semaphore.NewWeighted(1)
+	client, closer := server(ctx, d)
+	defer closer()
+
+	resp, err := client.StartProfile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The job holds perf once it starts recording.
+	assert.Eventually(t, func() bool {
+		return len(profiler.Records()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerId: containerID,
+		Timeout:     durationpb.New(timeout),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = receive(stream)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Greater(t, retryDelay(t, err), time.Duration(0))
+
+	// Containers in other namespaces wait for perf, which is used by the job.
+	stream, err = client.Profile(ctx, &rpc.PerfProfileRequest{
+		ContainerIds: []string{"app"},
+		Timeout:      durationpb.New(timeout),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = receive(stream)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "perf is not available")
+
+	_, err = client.CancelProfile(ctx, &rpc.CancelProfileRequest{JobId: resp.GetJobId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		stream, err := client.Profile(ctx, &rpc.PerfProfileRequest{
+			ContainerId: containerID,
+			Timeout:     durationpb.New(10 * time.Millisecond),
+		})
+		if err != nil {
+			return false
+		}
+		_, err = receive(stream)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Stat counts the events of the containers by perf stat.
+// It shares the workers and the quotas with the profiling since the counters of the PMU are multiplexed among the perf processes.
+func (d *DaemonServer) Stat(ctx context.Context, req *rpc.StatRequest) (*rpc.StatResponse, error) {
+	user, err := d.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var containerIDs []string
+	for _, id := range req.GetContainerIds() {
+		if len(id) != 0 && !slices.Contains(containerIDs, id) {
+			containerIDs = append(containerIDs, id)
+		}
+	}
+	if len(containerIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "container ID is not set")
+	}
+	if len(containerIDs) > maxContainers {
+		return nil, status.Errorf(codes.InvalidArgument, "too many containers: %d", len(containerIDs))
+	}
+
+	reqDuration := req.GetDuration()
+	if !reqDuration.IsValid() || reqDuration.AsDuration() < time.Second {
+		return nil, status.Error(codes.InvalidArgument, "duration must be at least 1s")
+	}
+	duration := reqDuration.AsDuration()
+	if duration > resource.MaxStatDuration {
+		return nil, status.Errorf(codes.InvalidArgument, "duration is too long %q", duration)
+	}
+
+	events := req.GetEvents()
+	if len(events) == 0 {
+		events = resource.DefaultStatEvents
+	}
+	if err := resource.ValidateStatEvents(events); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid events: %v", err)
+	}
+
+	for _, containerID := range containerIDs {
+		if err := d.authorizeContainer(ctx, user, containerID); err != nil {
+			return nil, err
+		}
+	}
+	containers, err := d.getContainers(ctx, containerIDs)
+	if err != nil {
+		return nil, err
+	}
+	params := &profileParams{
+		containers: containers,
+		timeout:    duration,
+	}
+
+	params.quota, err = d.quotas.admit(params.namespaces(), duration)
+	if err != nil {
+		return nil, err
+	}
+	defer d.quotas.release(params.quota)
+	if err := d.acquireWorker(ctx, nopProgress{}); err != nil {
+		return nil, err
+	}
+	defer d.semaphore.Release(weight)
+
+	start := time.Now()
+	counters, err := d.profiler.ExecStat(ctx, params.pids(), duration, events)
+	elapsed := time.Since(start)
+	params.quota.setRecorded(elapsed)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &rpc.StatResponse{
+		Elapsed: durationpb.New(elapsed),
+	}
+	for _, c := range counters {
+		resp.Counters = append(resp.Counters, &rpc.StatCounter{
+			Event:        c.Event,
+			Value:        c.Value,
+			Unit:         c.Unit,
+			Counted:      c.Counted,
+			Supported:    c.Supported,
+			RunningRatio: c.RunningRatio,
+			MetricValue:  c.MetricValue,
+			MetricUnit:   c.MetricUnit,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	profiler := newFakeProfiler(t)
+	profiler.Counters = []resource.StatCounter{
+		{Event: "cycles", Value: 2000000000, Counted: true, Supported: true, RunningRatio: 1},
+		{Event: "instructions", Value: 3000000000, Counted: true, Supported: true, RunningRatio: 1, MetricValue: 1.5, MetricUnit: "insn per cycle"},
+		{Event: "cache-misses", RunningRatio: 1},
+	}
+	client, closer := server(ctx, newTestDaemonServer(t, profiler))
+	defer closer()
+
+	resp, err := client.Stat(ctx, &rpc.StatRequest{
+		ContainerIds: []string{"app", "sidecar", "app"},
+		Duration:     durationpb.New(time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, resp.GetCounters(), 3) {
+		instructions := resp.GetCounters()[1]
+		assert.Equal(t, "instructions", instructions.GetEvent())
+		assert.Equal(t, float64(3000000000), instructions.GetValue())
+		assert.True(t, instructions.GetCounted())
+		assert.Equal(t, 1.5, instructions.GetMetricValue())
+		assert.Equal(t, "insn per cycle", instructions.GetMetricUnit())
+		assert.False(t, resp.GetCounters()[2].GetSupported())
+	}
+
+	stats := profiler.Stats()
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, []int{12345, 12400}, stats[0].PIDs)
+		assert.Equal(t, time.Second, stats[0].Duration)
+		assert.Equal(t, resource.DefaultStatEvents, stats[0].Events)
+	}
+}
+
+func TestStatInvalidRequest(t *testing.T) {
+	ctx := context.Background()
+	client, closer := server(ctx, newTestDaemonServer(t, newFakeProfiler(t)))
+	defer closer()
+
+	tests := map[string]struct {
+		in  *rpc.StatRequest
+		err string
+	}{
+		"notSetContainerID": {
+			in:  &rpc.StatRequest{Duration: durationpb.New(time.Second)},
+			err: "container ID is not set",
+		},
+		"notSetDuration": {
+			in:  &rpc.StatRequest{ContainerIds: []string{"app"}},
+			err: "duration must be at least 1s",
+		},
+		"tooLongDuration": {
+			in:  &rpc.StatRequest{ContainerIds: []string{"app"}, Duration: durationpb.New(time.Hour)},
+			err: `duration is too long "1h0m0s"`,
+		},
+		"notAllowedEvent": {
+			in: &rpc.StatRequest{
+				ContainerIds: []string{"app"},
+				Duration:     durationpb.New(time.Second),
+				Events:       []string{"cycles", "raw:r003c"},
+			},
+			err: `invalid events: event "raw:r003c" is not allowed`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := client.Stat(ctx, tt.in)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Equal(t, tt.err, status.Convert(err).Message())
+		})
+	}
+}
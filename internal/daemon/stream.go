@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/rpc"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultChunkSize = 64 * 1024
+	minChunkSize     = 1024
+	maxChunkSize     = 1024 * 1024
+)
+
+func validateStreamOptions(chunkSize uint32, compression rpc.Compression) (int, error) {
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return 0, status.Errorf(codes.InvalidArgument, "chunk size must be between %d and %d: %d", minChunkSize, maxChunkSize, chunkSize)
+	}
+
+	if _, ok := rpc.Compression_name[int32(compression)]; !ok {
+		return 0, status.Errorf(codes.InvalidArgument, "compression %d is not supported", compression)
+	}
+
+	return int(chunkSize), nil
+}
+
+// chunkSender sends the written data to the stream in chunks of at most size bytes.
+type chunkSender struct {
+	stream grpc.ServerStreamingServer[rpc.PerfProfileResponse]
+	size   int
+}
+
+func (s *chunkSender) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), s.size)
+		if err := s.stream.Send(&rpc.PerfProfileResponse{
+			Event: &rpc.PerfProfileResponse_Data{
+				Data: p[:n],
+			},
+		}); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// streamWriter compresses the written data and sends it to the stream in chunks.
+// Since Send blocks while the flow control window of the client is full,
+// Write also blocks until the client receives the data.
+type streamWriter struct {
+	buf        *bufio.Writer
+	compressor io.WriteCloser
+}
+
+// newStreamWriter creates streamWriter. The compression is notified to the client by the header
+// so that the client can tell whether the data is compressed.
+func newStreamWriter(stream grpc.ServerStreamingServer[rpc.PerfProfileResponse], chunkSize int, compression rpc.Compression) (*streamWriter, error) {
+	buf := bufio.NewWriterSize(&chunkSender{
+		stream: stream,
+		size:   chunkSize,
+	}, chunkSize)
+	w := &streamWriter{
+		buf: buf,
+	}
+
+	switch compression {
+	case rpc.Compression_COMPRESSION_GZIP:
+		w.compressor = gzip.NewWriter(buf)
+	case rpc.Compression_COMPRESSION_ZSTD:
+		enc, err := zstd.NewWriter(buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		w.compressor = enc
+	}
+
+	err := stream.SetHeader(metadata.Pairs(constants.CompressionHeader, compression.String()))
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.compressor != nil {
+		return w.compressor.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Close flushes the remaining data. It does not close the stream.
+func (w *streamWriter) Close() error {
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
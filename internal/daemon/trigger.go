@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/sink"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// TriggerConfig is the configuration of the profiling triggered by the CPU usage of the containers in the pods
+// annotated with constants.AnnotationCPUThreshold. It is disabled if Sink is nil.
+type TriggerConfig struct {
+	// CheckInterval is the interval between the checks of the CPU usage.
+	CheckInterval time.Duration
+	// Sustain is how long the CPU usage must stay above the threshold to trigger the profiling.
+	Sustain time.Duration
+	// Cooldown is the minimum time between the triggers of a container.
+	Cooldown time.Duration
+	// Duration is the length of each triggered recording.
+	Duration time.Duration
+	// Frequency is the sampling frequency in Hz.
+	Frequency int
+	// Sink receives the pprof profile of each triggered recording.
+	Sink sink.Sink
+}
+
+func (c *TriggerConfig) validate() error {
+	if c.Sink == nil {
+		return nil
+	}
+	if c.CheckInterval <= 0 {
+		return fmt.Errorf("check interval of the triggered profiling must be positive: %s", c.CheckInterval)
+	}
+	if c.Sustain < 0 {
+		return fmt.Errorf("sustain of the triggered profiling must not be negative: %s", c.Sustain)
+	}
+	if c.Duration < time.Second {
+		return fmt.Errorf("duration of the triggered profiling must be at least 1s: %s", c.Duration)
+	}
+	if c.Cooldown < c.Duration {
+		return fmt.Errorf("cooldown of the triggered profiling must not be shorter than the duration %s: %s", c.Duration, c.Cooldown)
+	}
+	if c.Frequency < 1 || c.Frequency > resource.MaxFrequency {
+		return fmt.Errorf("frequency of the triggered profiling must be between 1 and %d: %d", resource.MaxFrequency, c.Frequency)
+	}
+	return nil
+}
+
+// cpuWatch keeps the CPU usage of a container to find out how long it stays above the threshold.
+type cpuWatch struct {
+	// threshold is the CPU usage in cores. The container is not watched if it is not positive.
+	threshold float64
+	// usage is the total CPU time of the container at the last check.
+	usage time.Duration
+	at    time.Time
+	// aboveSince is the time since when the CPU usage has been above the threshold. It is zero if not above.
+	aboveSince  time.Time
+	lastTrigger time.Time
+}
+
+// update records the total CPU time at now, and returns true if the CPU usage has stayed above the threshold for sustain.
+func (w *cpuWatch) update(usage time.Duration, now time.Time, sustain time.Duration) bool {
+	prevUsage, prevAt := w.usage, w.at
+	w.usage, w.at = usage, now
+	if prevAt.IsZero() || !now.After(prevAt) {
+		return false
+	}
+
+	cores := float64(usage-prevUsage) / float64(now.Sub(prevAt))
+	if cores <= w.threshold {
+		w.aboveSince = time.Time{}
+		return false
+	}
+	if w.aboveSince.IsZero() {
+		w.aboveSince = prevAt
+	}
+	return now.Sub(w.aboveSince) >= sustain
+}
+
+// parseThreshold parses the value of constants.AnnotationCPUThreshold in cores.
+func parseThreshold(value string) (float64, error) {
+	q, err := k8sresource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU threshold %q: %w", value, err)
+	}
+	threshold := q.AsApproximateFloat64()
+	if threshold <= 0 {
+		return 0, fmt.Errorf("CPU threshold must be positive: %q", value)
+	}
+	return threshold, nil
+}
+
+func (d *DaemonServer) runTrigger(ctx context.Context) error {
+	d.logger.Info("triggered profiling is enabled", "checkInterval", d.trigger.CheckInterval, "sustain", d.trigger.Sustain, "cooldown", d.trigger.Cooldown)
+	ticker := time.NewTicker(d.trigger.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := d.checkTriggers(ctx, now); err != nil {
+				d.logger.Error("triggered profiling is failed", "error", err)
+			}
+		}
+	}
+}
+
+// checkTriggers checks the CPU usage of the annotated containers on this node,
+// and records the ones whose CPU usage has stayed above the threshold.
+func (d *DaemonServer) checkTriggers(ctx context.Context, now time.Time) error {
+	containers, err := d.container.ListContainersWithAnnotation(ctx, constants.AnnotationCPUThreshold)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool)
+	var triggered []*resource.ContainerInfo
+	for _, c := range containers {
+		current[c.ID] = true
+		w, ok := d.cpuWatches[c.ID]
+		if !ok {
+			w = &cpuWatch{}
+			// The annotations of the pod sandboxes are never changed, so the threshold is parsed only once.
+			threshold, err := parseThreshold(c.PodAnnotations[constants.AnnotationCPUThreshold])
+			if err != nil {
+				d.logger.Error("container is not watched", "namespace", c.Namespace, "pod", c.PodName, "container", c.Name, "error", err)
+			}
+			w.threshold = threshold
+			d.cpuWatches[c.ID] = w
+		}
+		if w.threshold <= 0 || len(c.CgroupPath) == 0 {
+			continue
+		}
+
+		usage, err := resource.CgroupCPUUsage(d.cgroupRoot, c.CgroupPath)
+		if err != nil {
+			d.logger.Info("failed to get the CPU usage", "containerID", c.ID, "cgroup", c.CgroupPath, "error", err)
+			continue
+		}
+		if !w.update(usage, now, d.trigger.Sustain) {
+			continue
+		}
+		if !w.lastTrigger.IsZero() && now.Sub(w.lastTrigger) < d.trigger.Cooldown {
+			continue
+		}
+		triggered = append(triggered, c)
+	}
+	for id := range d.cpuWatches {
+		if !current[id] {
+			delete(d.cpuWatches, id)
+		}
+	}
+	if len(triggered) == 0 {
+		return nil
+	}
+
+	// The triggered profiling gives way to the requests of the users as the continuous profiling does.
+	// The containers are checked again at the next time.
+	if !d.semaphore.TryAcquire(weight) {
+		d.logger.Info("triggered profiling is skipped because perf is busy")
+		return nil
+	}
+	defer d.semaphore.Release(weight)
+
+	for _, c := range triggered {
+		w := d.cpuWatches[c.ID]
+		w.lastTrigger = now
+		w.aboveSince = time.Time{}
+		d.logger.Info("CPU usage exceeds the threshold", "namespace", c.Namespace, "pod", c.PodName, "container", c.Name, "threshold", w.threshold)
+	}
+	options := resource.RecordOptions{
+		Frequency:      d.trigger.Frequency,
+		CallGraph:      resource.DefaultCallGraph,
+		DwarfStackSize: resource.DefaultDwarfStackSize,
+	}
+	return d.recordToSink(ctx, triggered, d.trigger.Duration, options, d.trigger.Sink)
+}
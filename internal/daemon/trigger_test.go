@@ -0,0 +1,230 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/resource"
+	"github.com/cybozu-go/necoperf/internal/sink"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTriggerServer(t *testing.T, profiler *resource.FakeProfiler) (*DaemonServer, *fakeSink) {
+	t.Helper()
+
+	s := &fakeSink{profiles: make(map[string]*sink.Profile)}
+	d := newTestDaemonServer(t, profiler)
+	d.cgroupRoot = t.TempDir()
+	d.trigger = TriggerConfig{
+		CheckInterval: time.Second,
+		Sustain:       2 * time.Second,
+		Cooldown:      time.Minute,
+		Duration:      10 * time.Millisecond,
+		Frequency:     29,
+		Sink:          s,
+	}
+	return d, s
+}
+
+// writeCPUUsage writes the total CPU time of the container "app" in the cgroup hierarchy of the daemon.
+// The CPU usage of "sidecar" cannot be read, so it is never triggered.
+func writeCPUUsage(t *testing.T, d *DaemonServer, usage time.Duration) {
+	t.Helper()
+
+	dir := filepath.Join(d.cgroupRoot, "kubepods", "app")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf("usage_usec %d\nuser_usec 0\nsystem_usec 0\n", usage.Microseconds())
+	if err := os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckTriggers(t *testing.T) {
+	ctx := context.Background()
+	profiler := newFakeProfiler(t)
+	d, s := newTestTriggerServer(t, profiler)
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// The container uses 1 core, which is above the threshold of 500m.
+	check := func(elapsed time.Duration) {
+		t.Helper()
+		writeCPUUsage(t, d, elapsed)
+		if err := d.checkTriggers(ctx, start.Add(elapsed)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(0)
+	check(time.Second)
+	assert.Empty(t, profiler.Records(), "the CPU usage has not stayed above the threshold long enough")
+
+	check(2 * time.Second)
+	records := profiler.Records()
+	if !assert.Len(t, records, 1) {
+		return
+	}
+	assert.Equal(t, []int{12345}, records[0].PIDs)
+	assert.Equal(t, 10*time.Millisecond, records[0].Timeout)
+	assert.Equal(t, resource.RecordOptions{Frequency: 29, CallGraph: resource.DefaultCallGraph, DwarfStackSize: resource.DefaultDwarfStackSize}, records[0].Options)
+	if assert.Contains(t, s.profiles, "app/app-pod/app") {
+		assert.Equal(t, 29, s.profiles["app/app-pod/app"].Frequency)
+	}
+	assertNoTemporaryFiles(t, d.workDir)
+
+	// The container is not triggered again until the cooldown passes.
+	for elapsed := 3 * time.Second; elapsed <= 10*time.Second; elapsed += time.Second {
+		check(elapsed)
+	}
+	assert.Len(t, profiler.Records(), 1)
+
+	check(time.Minute + 2*time.Second)
+	assert.Len(t, profiler.Records(), 2)
+}
+
+func TestCheckTriggersSkipped(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	t.Run("belowThreshold", func(t *testing.T) {
+		profiler := newFakeProfiler(t)
+		d, _ := newTestTriggerServer(t, profiler)
+
+		// The container uses 400m, which is below the threshold of 500m.
+		for i := range 5 {
+			writeCPUUsage(t, d, time.Duration(i)*400*time.Millisecond)
+			if err := d.checkTriggers(ctx, start.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.Empty(t, profiler.Records())
+	})
+
+	t.Run("busy", func(t *testing.T) {
+		profiler := newFakeProfiler(t)
+		d, _ := newTestTriggerServer(t, profiler)
+		d.trigger.Sustain = 0
+		if err := d.semaphore.Acquire(ctx, maxWorkers); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range 3 {
+			writeCPUUsage(t, d, time.Duration(i)*time.Second)
+			if err := d.checkTriggers(ctx, start.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.Empty(t, profiler.Records())
+		// The container is triggered as soon as perf becomes available.
+		assert.True(t, d.cpuWatches["app"].lastTrigger.IsZero())
+	})
+}
+
+func TestCPUWatch(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	w := &cpuWatch{threshold: 1.5}
+	sustain := 3 * time.Second
+
+	tests := []struct {
+		usage     time.Duration
+		triggered bool
+	}{
+		{usage: 0},
+		// 2 cores.
+		{usage: 2 * time.Second},
+		{usage: 4 * time.Second},
+		// 1 core resets the duration above the threshold.
+		{usage: 5 * time.Second},
+		{usage: 7 * time.Second},
+		{usage: 9 * time.Second},
+		{usage: 11 * time.Second, triggered: true},
+	}
+	for i, tt := range tests {
+		triggered := w.update(tt.usage, start.Add(time.Duration(i)*time.Second), sustain)
+		assert.Equal(t, tt.triggered, triggered, i)
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		value     string
+		threshold float64
+		err       bool
+	}{
+		"cores":    {value: "1.5", threshold: 1.5},
+		"milli":    {value: "500m", threshold: 0.5},
+		"zero":     {value: "0", err: true},
+		"negative": {value: "-1", err: true},
+		"invalid":  {value: "high", err: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			threshold, err := parseThreshold(tt.value)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.threshold, threshold)
+		})
+	}
+}
+
+func TestTriggerConfig(t *testing.T) {
+	t.Parallel()
+
+	s := &fakeSink{}
+	valid := TriggerConfig{CheckInterval: time.Second, Sustain: 10 * time.Second, Cooldown: 10 * time.Minute, Duration: 10 * time.Second, Frequency: 99, Sink: s}
+	tests := map[string]struct {
+		modify func(c *TriggerConfig)
+		valid  bool
+	}{
+		"disabled": {
+			modify: func(c *TriggerConfig) { *c = TriggerConfig{} },
+			valid:  true,
+		},
+		"valid": {
+			modify: func(c *TriggerConfig) {},
+			valid:  true,
+		},
+		"noCheckInterval": {
+			modify: func(c *TriggerConfig) { c.CheckInterval = 0 },
+		},
+		"negativeSustain": {
+			modify: func(c *TriggerConfig) { c.Sustain = -time.Second },
+		},
+		"tooShortDuration": {
+			modify: func(c *TriggerConfig) { c.Duration = 100 * time.Millisecond },
+		},
+		"cooldownShorterThanDuration": {
+			modify: func(c *TriggerConfig) { c.Cooldown = 5 * time.Second },
+		},
+		"tooHighFrequency": {
+			modify: func(c *TriggerConfig) { c.Frequency = 1000 },
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			config := valid
+			tt.modify(&config)
+			err := config.validate()
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
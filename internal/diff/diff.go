@@ -0,0 +1,233 @@
+// Package diff compares two profiles captured by necoperf to find out which functions got hotter.
+package diff
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/google/pprof/profile"
+)
+
+// MinDelta is the change of a share regarded as unchanged, which absorbs the rounding errors.
+// The differential flame graph uses it too, so that it agrees with Compare.
+const MinDelta = 1e-9
+
+// Load reads the profile saved by necoperf-cli as the folded stacks.
+// The file is read as a pprof profile if its name ends with ".pb.gz", and as the output of perf script otherwise.
+// The first frame of each stack is the command name of the process.
+func Load(path string) (perfscript.Folded, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var folded perfscript.Folded
+	if isProfile(path) {
+		folded, err = foldProfile(f)
+	} else {
+		folded, err = foldScript(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if folded.Total() <= 0 {
+		return nil, fmt.Errorf("no samples in %s", path)
+	}
+	return folded, nil
+}
+
+// CountName returns the unit of the values of the profiles loaded by Load, "ns" for the pprof profiles
+// and "samples" for the output of perf script.
+// It fails if the profiles are of different kinds, since their values cannot be shown in one unit.
+func CountName(before, after string) (string, error) {
+	if isProfile(before) != isProfile(after) {
+		return "", fmt.Errorf("%s and %s are of different formats", before, after)
+	}
+	// The values of the pprof profiles saved by necoperf are the time in nanoseconds.
+	if isProfile(after) {
+		return "ns", nil
+	}
+	return "samples", nil
+}
+
+func isProfile(path string) bool {
+	return strings.HasSuffix(path, ".pb.gz")
+}
+
+// foldScript counts the samples of perf script by stack.
+func foldScript(r io.Reader) (perfscript.Folded, error) {
+	folded := make(perfscript.Folded)
+	p := perfscript.NewParser(r)
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			return folded, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		folded.Add(s, 1)
+	}
+}
+
+// foldProfile sums the values of the default sample type of the pprof profile by stack.
+func foldProfile(r io.Reader) (perfscript.Folded, error) {
+	p, err := profile.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last sample type is the default one if it is not specified.
+	index := len(p.SampleType) - 1
+	for i, st := range p.SampleType {
+		if st.Type == p.DefaultSampleType {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("no sample types")
+	}
+
+	folded := make(perfscript.Folded)
+	for _, s := range p.Sample {
+		comm := "[unknown]"
+		if labels := s.Label["comm"]; len(labels) != 0 {
+			comm = labels[0]
+		}
+		frames := []string{comm}
+		// The locations are ordered from the leaf, and the lines of a location are ordered from the innermost inlined function.
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			loc := s.Location[i]
+			if len(loc.Line) == 0 {
+				frames = append(frames, "[unknown]")
+				continue
+			}
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				name := "[unknown]"
+				if fn := loc.Line[j].Function; fn != nil && len(fn.Name) != 0 {
+					name = fn.Name
+				}
+				frames = append(frames, name)
+			}
+		}
+		folded[strings.Join(frames, ";")] += s.Value[index]
+	}
+	return folded, nil
+}
+
+// Function is the change of the share of the samples of a function.
+type Function struct {
+	Name string
+	// SelfBefore and SelfAfter are the shares of the samples in which the function is the leaf.
+	SelfBefore float64
+	SelfAfter  float64
+	// TotalBefore and TotalAfter are the shares of the samples in which the function is on the stack.
+	TotalBefore float64
+	TotalAfter  float64
+}
+
+func (f *Function) SelfDelta() float64 {
+	return f.SelfAfter - f.SelfBefore
+}
+
+func (f *Function) TotalDelta() float64 {
+	return f.TotalAfter - f.TotalBefore
+}
+
+type shares struct {
+	self  float64
+	total float64
+}
+
+// functionShares returns the shares of the samples of the functions in the folded stacks.
+// The samples are normalized by the total so that the profiles of different lengths can be compared.
+func functionShares(folded perfscript.Folded) map[string]*shares {
+	result := make(map[string]*shares)
+	get := func(name string) *shares {
+		s, ok := result[name]
+		if !ok {
+			s = &shares{}
+			result[name] = s
+		}
+		return s
+	}
+
+	total := folded.Total()
+	for stack, value := range folded {
+		// The first frame is the command name.
+		frames := strings.Split(stack, ";")[1:]
+		if value <= 0 || len(frames) == 0 {
+			continue
+		}
+		share := float64(value) / float64(total)
+
+		get(frames[len(frames)-1]).self += share
+		// A recursive function is counted once in a stack.
+		seen := make(map[string]bool)
+		for _, name := range frames {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			get(name).total += share
+		}
+	}
+	return result
+}
+
+// Compare returns the functions whose shares of the samples changed from before to after.
+// They are ranked by the absolute change of the self share, and then of the total share.
+func Compare(before, after perfscript.Folded) []Function {
+	beforeShares := functionShares(before)
+	afterShares := functionShares(after)
+
+	names := make(map[string]bool)
+	for name := range beforeShares {
+		names[name] = true
+	}
+	for name := range afterShares {
+		names[name] = true
+	}
+
+	var functions []Function
+	for name := range names {
+		f := Function{Name: name}
+		if s, ok := beforeShares[name]; ok {
+			f.SelfBefore, f.TotalBefore = s.self, s.total
+		}
+		if s, ok := afterShares[name]; ok {
+			f.SelfAfter, f.TotalAfter = s.self, s.total
+		}
+		if math.Abs(f.SelfDelta()) < MinDelta && math.Abs(f.TotalDelta()) < MinDelta {
+			continue
+		}
+		functions = append(functions, f)
+	}
+
+	slices.SortFunc(functions, func(a, b Function) int {
+		if c := compareDelta(b.SelfDelta(), a.SelfDelta()); c != 0 {
+			return c
+		}
+		if c := compareDelta(b.TotalDelta(), a.TotalDelta()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return functions
+}
+
+// compareDelta compares the absolute values of the changes regarding the tiny differences as equal.
+func compareDelta(a, b float64) int {
+	a, b = math.Abs(a), math.Abs(b)
+	if math.Abs(a-b) < MinDelta {
+		return 0
+	}
+	return cmp.Compare(a, b)
+}
@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/stretchr/testify/assert"
+)
+
+const scriptPath = "../perfscript/testdata/cpu-clock.script"
+
+// writeAfter saves the samples of the test script as a pprof profile, in which the sample of full_write is
+// recorded 3 more times. The samples of the script are:
+//
+//	yes;__libc_start_call_main;main;__GI___libc_write;_raw_spin_unlock_irqrestore 1
+//	yes;__libc_start_call_main;main;full_write 2
+//	Web Content;[unknown];std::vector<int>::push_back(int const&) 1
+func writeAfter(t *testing.T, dir string) string {
+	t.Helper()
+
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := perfscript.Parse(bytes.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := perfscript.NewProfileBuilder(99)
+	for _, s := range samples {
+		b.Add(s)
+	}
+	for range 3 {
+		b.Add(samples[1])
+	}
+	var buf bytes.Buffer
+	if err := b.Profile().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "after.pb.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	before, err := Load(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, perfscript.Folded{
+		"yes;__libc_start_call_main;main;__GI___libc_write;_raw_spin_unlock_irqrestore": 1,
+		"yes;__libc_start_call_main;main;full_write":                                    2,
+		"Web Content;[unknown];std::vector<int>::push_back(int const&)":                 1,
+	}, before)
+
+	after, err := Load(writeAfter(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The values of the pprof profile are the CPU time in nanoseconds.
+	assert.Equal(t, perfscript.Folded{
+		"yes;__libc_start_call_main;main;__GI___libc_write;_raw_spin_unlock_irqrestore": 10101010,
+		"yes;__libc_start_call_main;main;full_write":                                    5 * 10101010,
+		"Web Content;[unknown];std::vector<int>::push_back(int const&)":                 10101010,
+	}, after)
+
+	empty := filepath.Join(t.TempDir(), "empty.script")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(empty)
+	assert.Error(t, err)
+}
+
+func TestCountName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		before string
+		after  string
+		want   string
+		err    bool
+	}{
+		"script": {before: "before.script", after: "after.script", want: "samples"},
+		"pprof":  {before: "before.pb.gz", after: "after.pb.gz", want: "ns"},
+		"mixed":  {before: "before.script", after: "after.pb.gz", err: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CountName(tt.before, tt.after)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	before, err := Load(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Load(writeAfter(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	functions := Compare(before, after)
+	var names []string
+	for _, f := range functions {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{
+		"full_write",
+		"_raw_spin_unlock_irqrestore",
+		"std::vector<int>::push_back(int const&)",
+		"[unknown]",
+		"__GI___libc_write",
+		"__libc_start_call_main",
+		"main",
+	}, names)
+
+	f := functions[0]
+	assert.InDelta(t, 2.0/4, f.SelfBefore, 1e-9)
+	assert.InDelta(t, 5.0/7, f.SelfAfter, 1e-9)
+	assert.InDelta(t, 5.0/7-2.0/4, f.SelfDelta(), 1e-9)
+	assert.InDelta(t, f.SelfDelta(), f.TotalDelta(), 1e-9)
+
+	main := functions[6]
+	assert.Zero(t, main.SelfBefore)
+	assert.Zero(t, main.SelfAfter)
+	assert.InDelta(t, 3.0/4, main.TotalBefore, 1e-9)
+	assert.InDelta(t, 6.0/7, main.TotalAfter, 1e-9)
+
+	// The same profile has no changes even if it is longer.
+	assert.Empty(t, Compare(after, after))
+	doubled := make(perfscript.Folded)
+	for stack, value := range before {
+		doubled[stack] = value * 2
+	}
+	assert.Empty(t, Compare(before, doubled))
+}
@@ -0,0 +1,68 @@
+package flamegraph
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/cybozu-go/necoperf/internal/diff"
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+)
+
+// RenderDiff writes the differential flame graph of the folded stacks from before to after to w.
+// The frames are laid out by after. Each frame is red if its share of the samples grew from before,
+// and blue if it shrank. The more it changed, the deeper the color is.
+// The stacks which appear only in before are not drawn.
+func RenderDiff(w io.Writer, before, after perfscript.Folded, opts Options) error {
+	beforeShares := prefixShares(before)
+	afterShares := prefixShares(after)
+
+	var maxDelta float64
+	for stack, share := range afterShares {
+		maxDelta = max(maxDelta, math.Abs(share-beforeShares[stack]))
+	}
+
+	opts.Color = func(f Frame) string {
+		return diffColor(afterShares[f.Stack]-beforeShares[f.Stack], maxDelta)
+	}
+	opts.Detail = func(f Frame) string {
+		return fmt.Sprintf("%+.2f%% from %.2f%%", (afterShares[f.Stack]-beforeShares[f.Stack])*100, beforeShares[f.Stack]*100)
+	}
+	return Render(w, after, opts)
+}
+
+// prefixShares returns the share of the samples of each frame keyed by the stack from the root to the frame.
+func prefixShares(folded perfscript.Folded) map[string]float64 {
+	shares := make(map[string]float64)
+	total := folded.Total()
+	if total <= 0 {
+		return shares
+	}
+
+	for stack, value := range folded {
+		if value <= 0 {
+			continue
+		}
+		share := float64(value) / float64(total)
+		for i, c := range stack {
+			if c == ';' {
+				shares[stack[:i]] += share
+			}
+		}
+		shares[stack] += share
+	}
+	shares[""] = 1
+	return shares
+}
+
+func diffColor(delta, maxDelta float64) string {
+	if maxDelta < diff.MinDelta || math.Abs(delta) < diff.MinDelta {
+		return "rgb(255,255,255)"
+	}
+	// The smallest changes are still tinted to tell them from the unchanged frames.
+	v := 40 + int(math.Abs(delta)/maxDelta*170)
+	if delta > 0 {
+		return fmt.Sprintf("rgb(255,%d,%d)", 255-v, 255-v)
+	}
+	return fmt.Sprintf("rgb(%d,%d,255)", 255-v, 255-v)
+}
@@ -0,0 +1,202 @@
+// Package flamegraph renders folded stacks as a flame graph in SVG.
+package flamegraph
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+)
+
+const (
+	defaultWidth = 1200
+	frameHeight  = 16
+	fontSize     = 12
+	fontWidth    = 0.59
+	padX         = 10
+	padTop       = 40
+	padBottom    = 10
+	minWidth     = 0.1
+)
+
+// Frame is a rectangle in the flame graph.
+type Frame struct {
+	Name string
+	// Stack is the folded stack from the root to this frame.
+	Stack string
+	Value int64
+	Depth int
+}
+
+type Options struct {
+	Title string
+	// Width is the width of the image in pixels.
+	Width int
+	// Color returns the fill color of a frame. Warm colors are used when nil.
+	Color func(f Frame) string
+	// CountName is the unit of the values shown in the tooltips. "samples" is used when empty.
+	CountName string
+	// Detail returns the additional text shown in the tooltip of a frame, if not nil.
+	Detail func(f Frame) string
+}
+
+type node struct {
+	frame    Frame
+	children map[string]*node
+}
+
+func buildTree(folded perfscript.Folded) (*node, int) {
+	root := &node{
+		frame:    Frame{Name: "all", Value: folded.Total()},
+		children: make(map[string]*node),
+	}
+
+	maxDepth := 0
+	for stack, value := range folded {
+		if value <= 0 {
+			continue
+		}
+
+		n := root
+		frames := strings.Split(stack, ";")
+		for i, name := range frames {
+			child, ok := n.children[name]
+			if !ok {
+				child = &node{
+					frame: Frame{
+						Name:  name,
+						Stack: strings.Join(frames[:i+1], ";"),
+						Depth: i + 1,
+					},
+					children: make(map[string]*node),
+				}
+				n.children[name] = child
+			}
+			child.frame.Value += value
+			n = child
+		}
+		maxDepth = max(maxDepth, len(frames))
+	}
+
+	return root, maxDepth
+}
+
+// Render writes the flame graph of the folded stacks to w.
+func Render(w io.Writer, folded perfscript.Folded, opts Options) error {
+	if opts.Width <= 0 {
+		opts.Width = defaultWidth
+	}
+	if opts.Color == nil {
+		opts.Color = warmColor
+	}
+	if len(opts.CountName) == 0 {
+		opts.CountName = "samples"
+	}
+
+	root, maxDepth := buildTree(folded)
+	height := padTop + (maxDepth+1)*frameHeight + padBottom
+	r := &renderer{
+		opts:   opts,
+		height: height,
+		total:  root.frame.Value,
+	}
+	if r.total > 0 {
+		r.scale = float64(opts.Width-2*padX) / float64(root.frame.Value)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">
+<rect x="0" y="0" width="100%%" height="100%%" fill="#f8f8f8"/>
+<text x="%d" y="24" font-size="17" font-family="Verdana" text-anchor="middle">%s</text>
+`, opts.Width, height, opts.Width, height, opts.Width/2, html.EscapeString(opts.Title))
+	r.writeNode(bw, root, padX)
+	fmt.Fprintln(bw, "</svg>")
+
+	return bw.Flush()
+}
+
+type renderer struct {
+	opts   Options
+	height int
+	total  int64
+	scale  float64
+}
+
+func (r *renderer) writeNode(w io.Writer, n *node, x float64) {
+	width := float64(n.frame.Value) * r.scale
+	if width < minWidth {
+		return
+	}
+
+	y := r.height - padBottom - (n.frame.Depth+1)*frameHeight
+	percent := float64(n.frame.Value) / float64(r.total) * 100
+	name := html.EscapeString(n.frame.Name)
+
+	fill := r.opts.Color(n.frame)
+	if n.frame.Depth == 0 {
+		fill = "rgb(200,200,200)"
+	}
+	detail := ""
+	if r.opts.Detail != nil {
+		detail = ", " + html.EscapeString(r.opts.Detail(n.frame))
+	}
+	fmt.Fprintf(w, `<g><title>%s (%d %s, %.2f%%%s)</title><rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s" rx="2" ry="2"/>`,
+		name, n.frame.Value, html.EscapeString(r.opts.CountName), percent, detail, x, y, width, frameHeight-1, fill)
+	if label := truncate(n.frame.Name, width); len(label) != 0 {
+		fmt.Fprintf(w, `<text x="%.1f" y="%d" font-size="%d" font-family="Verdana">%s</text>`,
+			x+3, y+fontSize, fontSize, html.EscapeString(label))
+	}
+	fmt.Fprintln(w, "</g>")
+
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		child := n.children[name]
+		r.writeNode(w, child, x)
+		x += float64(child.frame.Value) * r.scale
+	}
+}
+
+func truncate(name string, width float64) string {
+	chars := int((width - 6) / (fontSize * fontWidth))
+	if chars < 3 {
+		return ""
+	}
+	runes := []rune(name)
+	if len(runes) <= chars {
+		return name
+	}
+	return string(runes[:chars-2]) + ".."
+}
+
+func warmColor(f Frame) string {
+	h := fnv.New32a()
+	h.Write([]byte(f.Name))
+	v := h.Sum32()
+
+	red := 205 + int(v%50)
+	green := int((v >> 8) % 230)
+	blue := int((v >> 16) % 55)
+	return fmt.Sprintf("rgb(%d,%d,%d)", red, green, blue)
+}
+
+// ColdColor returns blue colors, which are used for the off-CPU flame graphs to tell them from the CPU ones.
+func ColdColor(f Frame) string {
+	h := fnv.New32a()
+	h.Write([]byte(f.Name))
+	v := h.Sum32()
+
+	red := int(v % 55)
+	green := 80 + int((v>>8)%100)
+	blue := 205 + int((v>>16)%50)
+	return fmt.Sprintf("rgb(%d,%d,%d)", red, green, blue)
+}
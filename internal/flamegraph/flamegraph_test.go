@@ -0,0 +1,131 @@
+package flamegraph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	folded := perfscript.Folded{
+		"yes;__libc_start_call_main;main;full_write":                    2,
+		"yes;__libc_start_call_main;main;__GI___libc_write":             1,
+		"Web Content;[unknown];std::vector<int>::push_back(int const&)": 1,
+	}
+
+	var buf bytes.Buffer
+	err := Render(&buf, folded, Options{Title: "test <pod>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	decoder := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	inTitle := false
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inTitle = tok.Name.Local == "title"
+		case xml.EndElement:
+			inTitle = false
+		case xml.CharData:
+			if inTitle {
+				titles = append(titles, string(tok))
+			}
+		}
+	}
+
+	assert.Contains(t, titles, "all (4 samples, 100.00%)")
+	assert.Contains(t, titles, "main (3 samples, 75.00%)")
+	assert.Contains(t, titles, "full_write (2 samples, 50.00%)")
+	assert.Contains(t, titles, "std::vector<int>::push_back(int const&) (1 samples, 25.00%)")
+	assert.True(t, strings.Contains(buf.String(), "test &lt;pod&gt;"))
+}
+
+func TestRenderColor(t *testing.T) {
+	t.Parallel()
+
+	folded := perfscript.Folded{
+		"a;b": 1,
+		"a;c": 1,
+	}
+
+	var buf bytes.Buffer
+	err := Render(&buf, folded, Options{
+		Color: func(f Frame) string {
+			if f.Stack == "a;c" {
+				return "red"
+			}
+			return "blue"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), `fill="red"`))
+	assert.Equal(t, 2, strings.Count(buf.String(), `fill="blue"`))
+}
+
+func TestRenderCountName(t *testing.T) {
+	t.Parallel()
+
+	folded := perfscript.Folded{
+		"a;b": 1000,
+	}
+
+	var buf bytes.Buffer
+	err := Render(&buf, folded, Options{
+		Color:     ColdColor,
+		CountName: "ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, buf.String(), "<title>b (1000 ns, 100.00%)</title>")
+	assert.NotContains(t, buf.String(), "samples")
+}
+
+func TestRenderDiff(t *testing.T) {
+	t.Parallel()
+
+	before := perfscript.Folded{
+		"a;b": 1,
+		"a;c": 1,
+		"a;d": 2,
+	}
+	after := perfscript.Folded{
+		"a;b": 3,
+		"a;c": 1,
+	}
+
+	var buf bytes.Buffer
+	if err := RenderDiff(&buf, before, after, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	// b grew from 25% to 75%, c did not change, and d is not drawn.
+	assert.Contains(t, svg, `<title>b (3 samples, 75.00%, +50.00% from 25.00%)</title><rect x="10.0" y="40" width="885.0" height="15" fill="rgb(255,45,45)"`)
+	assert.Contains(t, svg, `<title>c (1 samples, 25.00%, +0.00% from 25.00%)</title><rect x="895.0" y="40" width="295.0" height="15" fill="rgb(255,255,255)"`)
+	assert.Contains(t, svg, `<title>a (4 samples, 100.00%, +0.00% from 100.00%)`)
+	assert.NotContains(t, svg, "<title>d ")
+
+	var reversed bytes.Buffer
+	if err := RenderDiff(&reversed, after, before, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, reversed.String(), `<title>b (1 samples, 25.00%, -50.00% from 75.00%)</title><rect x="10.0" y="40" width="295.0" height="15" fill="rgb(45,45,255)"`)
+}
@@ -0,0 +1,89 @@
+package perfscript
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Folded holds the values of the folded stacks, which is compatible with stackcollapse-perf.pl.
+// Each key is a semicolon separated list of frames from the root to the leaf,
+// prefixed with the command name of the process.
+type Folded map[string]int64
+
+// Add adds the sample to the folded stacks with the given value.
+func (f Folded) Add(s *Sample, value int64) {
+	f.AddWithRoot(nil, s, value)
+}
+
+// AddWithRoot adds the sample to the folded stacks with the given value.
+// The root frames are prepended to the stack, e.g. to group the samples by container.
+func (f Folded) AddWithRoot(root []string, s *Sample, value int64) {
+	frames := make([]string, 0, len(root)+len(s.Stack)+1)
+	frames = append(frames, root...)
+	frames = append(frames, s.Comm)
+	for i := len(s.Stack) - 1; i >= 0; i-- {
+		frames = append(frames, s.Stack[i].Symbol)
+	}
+	f[strings.Join(frames, ";")] += value
+}
+
+// Total returns the sum of the values of all stacks.
+func (f Folded) Total() int64 {
+	var total int64
+	for _, v := range f {
+		total += v
+	}
+	return total
+}
+
+// Write writes the folded stacks in lexical order.
+func (f Folded) Write(w io.Writer) error {
+	stacks := make([]string, 0, len(f))
+	for stack := range f {
+		stacks = append(stacks, stack)
+	}
+	slices.Sort(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, f[stack]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ParseFolded reads folded stacks written by Write or stackcollapse-perf.pl.
+func ParseFolded(r io.Reader) (Folded, error) {
+	f := make(Folded)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: value is not found", lineNum)
+		}
+		value, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		f[line[:i]] += value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
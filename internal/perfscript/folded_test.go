@@ -0,0 +1,66 @@
+package perfscript
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFolded(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/cpu-clock.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folded := make(Folded)
+	for _, s := range samples {
+		folded.Add(s, 1)
+	}
+	assert.Equal(t, int64(4), folded.Total())
+
+	var buf bytes.Buffer
+	if err := folded.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Web Content;[unknown];std::vector<int>::push_back(int const&) 1
+yes;__libc_start_call_main;main;__GI___libc_write;_raw_spin_unlock_irqrestore 1
+yes;__libc_start_call_main;main;full_write 2
+`
+	assert.Equal(t, expected, buf.String())
+
+	parsed, err := ParseFolded(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, folded, parsed)
+}
+
+func TestFoldedAddWithRoot(t *testing.T) {
+	t.Parallel()
+
+	s := &Sample{
+		Comm: "envoy",
+		Stack: []Frame{
+			{Symbol: "leaf"},
+			{Symbol: "main"},
+		},
+	}
+	folded := make(Folded)
+	folded.AddWithRoot([]string{"sidecar"}, s, 2)
+	folded.AddWithRoot(nil, s, 1)
+
+	assert.Equal(t, Folded{
+		"sidecar;envoy;main;leaf": 2,
+		"envoy;main;leaf":         1,
+	}, folded)
+}
@@ -0,0 +1,51 @@
+package perfscript
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// e.g. "comm=yes pid=12345 delay=49900000 [ns]"
+var schedStatRegexp = regexp.MustCompile(`(?:^|\s)pid=(\d+)\s+delay=(\d+)`)
+
+// OffCPU computes the time for which the threads are blocked from the samples of the scheduler tracepoints
+// in the same way as perf inject --sched-stat.
+//
+// A sched_switch sample has the stack on which the thread goes off CPU. When the thread wakes up,
+// a sched_stat_* sample tells how long it was blocked, but its stack is the one of the waker.
+// So the blocked time is attributed to the stack of the last sched_switch sample of the thread.
+type OffCPU struct {
+	switched map[int]*Sample
+}
+
+func NewOffCPU() *OffCPU {
+	return &OffCPU{
+		switched: make(map[int]*Sample),
+	}
+}
+
+// Add processes the sample. If the sample ends the blocked time of a thread, it returns
+// the sched_switch sample on which the thread was blocked and the blocked time in nanoseconds.
+func (o *OffCPU) Add(s *Sample) (*Sample, int64, bool) {
+	switch s.Event {
+	case "sched:sched_switch":
+		o.switched[s.TID] = s
+	case "sched:sched_stat_sleep", "sched:sched_stat_blocked", "sched:sched_stat_iowait":
+		m := schedStatRegexp.FindStringSubmatch(s.Args)
+		if m == nil {
+			return nil, 0, false
+		}
+		tid, _ := strconv.Atoi(m[1])
+		delay, _ := strconv.ParseInt(m[2], 10, 64)
+
+		// sched_stat_iowait is followed by sched_stat_blocked for the same wakeup,
+		// so the sample is forgotten once it is used not to count the time twice.
+		blocked, ok := o.switched[tid]
+		if !ok {
+			return nil, 0, false
+		}
+		delete(o.switched, tid)
+		return blocked, delay, true
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,84 @@
+package perfscript
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseOffCPU(t *testing.T) []*Sample {
+	t.Helper()
+
+	f, err := os.Open("testdata/off-cpu.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func TestOffCPU(t *testing.T) {
+	t.Parallel()
+
+	samples := parseOffCPU(t)
+	if !assert.Len(t, samples, 6) {
+		return
+	}
+	assert.Equal(t, "sched:sched_switch", samples[0].Event)
+	assert.Equal(t, "sched:sched_stat_sleep", samples[2].Event)
+
+	folded := make(Folded)
+	o := NewOffCPU()
+	for _, s := range samples {
+		if blocked, ns, ok := o.Add(s); ok {
+			folded.Add(blocked, ns)
+		}
+	}
+
+	// The iowait time is not counted twice by sched_stat_blocked,
+	// and the wakeup of the thread which has not switched is ignored.
+	assert.Equal(t, Folded{
+		"yes;main;__GI___libc_read;schedule;__schedule": 50000000,
+		"Web Content;fsync;schedule;__schedule":         30000000,
+	}, folded)
+}
+
+func TestOffCPUProfileBuilder(t *testing.T) {
+	t.Parallel()
+
+	b := NewOffCPUProfileBuilder()
+	o := NewOffCPU()
+	for _, s := range parseOffCPU(t) {
+		if blocked, ns, ok := o.Add(s); ok {
+			b.AddWithValue(blocked, ns, map[string]string{"container": "app"})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := b.Profile().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "off-cpu", p.DefaultSampleType)
+	assert.Equal(t, "off-cpu", p.SampleType[1].Type)
+	if !assert.Len(t, p.Sample, 2) {
+		return
+	}
+	assert.Equal(t, []int64{1, 50000000}, p.Sample[0].Value)
+	assert.Equal(t, []int64{12345}, p.Sample[0].NumLabel["pid"])
+	assert.Equal(t, []int64{1, 30000000}, p.Sample[1].Value)
+	assert.Equal(t, []int64{12401}, p.Sample[1].NumLabel["tid"])
+	assert.Equal(t, []string{"app"}, p.Sample[1].Label["container"])
+}
@@ -0,0 +1,173 @@
+// Package perfscript parses the text output of perf script.
+package perfscript
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxLineSize = 1024 * 1024
+
+var (
+	// e.g. "yes 12345/12345 [001] 123456.789012:   10101010 cpu-clock:pppH: "
+	headerRegexp = regexp.MustCompile(`^\s*(.+?)\s+(\d+)(?:/(\d+))?\s+(?:\[(\d+)\]\s+)?(\d+\.\d+):\s*(?:(\d+)\s+)?(\S+?):?(?:\s+(.*))?$`)
+	// e.g. "	    55d0f0a1b2c3 main+0x22 (/usr/bin/yes)"
+	frameRegexp  = regexp.MustCompile(`^\s+([0-9a-f]+)\s+(.*?)(?:\s+\(([^()]*)\))?$`)
+	offsetRegexp = regexp.MustCompile(`\+0x[0-9a-f]+$`)
+)
+
+// Frame is a function on the call stack of a sample.
+type Frame struct {
+	Address uint64
+	Symbol  string
+	DSO     string
+}
+
+// Sample is an event recorded by perf.
+type Sample struct {
+	Comm string
+	// PID is zero if perf script is not run with the pid field.
+	PID  int
+	TID  int
+	CPU  int
+	Time float64
+	// Period is zero if perf script does not print the period field.
+	Period uint64
+	Event  string
+	// Args holds the text printed after the event name, such as tracepoint fields.
+	Args string
+	// Stack is ordered from the leaf function to the root function.
+	Stack []Frame
+}
+
+// Parser reads samples one by one from the output of perf script.
+type Parser struct {
+	scanner *bufio.Scanner
+	pending *Sample
+}
+
+func NewParser(r io.Reader) *Parser {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Parser{
+		scanner: scanner,
+	}
+}
+
+// Next returns the next sample. It returns io.EOF when there are no more samples.
+// Lines that cannot be recognized are skipped.
+func (p *Parser) Next() (*Sample, error) {
+	sample := p.pending
+	p.pending = nil
+
+	for p.scanner.Scan() {
+		line := p.scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			if sample != nil {
+				return sample, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if s := parseHeader(line); s != nil {
+			if sample != nil {
+				p.pending = s
+				return sample, nil
+			}
+			sample = s
+			continue
+		}
+
+		if sample == nil {
+			continue
+		}
+		if f, ok := parseFrame(line); ok {
+			sample.Stack = append(sample.Stack, f)
+		}
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if sample != nil {
+		return sample, nil
+	}
+	return nil, io.EOF
+}
+
+// Parse reads all samples from r.
+func Parse(r io.Reader) ([]*Sample, error) {
+	var samples []*Sample
+	p := NewParser(r)
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+}
+
+func parseHeader(line string) *Sample {
+	m := headerRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+
+	s := &Sample{
+		Comm:  m[1],
+		Event: m[7],
+		Args:  m[8],
+	}
+	if len(m[3]) != 0 {
+		s.PID, _ = strconv.Atoi(m[2])
+		s.TID, _ = strconv.Atoi(m[3])
+	} else {
+		s.TID, _ = strconv.Atoi(m[2])
+	}
+	if len(m[4]) != 0 {
+		s.CPU, _ = strconv.Atoi(m[4])
+	}
+	s.Time, _ = strconv.ParseFloat(m[5], 64)
+	if len(m[6]) != 0 {
+		s.Period, _ = strconv.ParseUint(m[6], 10, 64)
+	}
+
+	return s
+}
+
+func parseFrame(line string) (Frame, bool) {
+	m := frameRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return Frame{}, false
+	}
+
+	addr, err := strconv.ParseUint(m[1], 16, 64)
+	if err != nil {
+		return Frame{}, false
+	}
+
+	symbol := offsetRegexp.ReplaceAllString(m[2], "")
+	if len(symbol) == 0 {
+		symbol = "[unknown]"
+	}
+	dso := m[3]
+	if len(dso) == 0 {
+		dso = "[unknown]"
+	}
+
+	return Frame{
+		Address: addr,
+		Symbol:  symbol,
+		DSO:     dso,
+	}, true
+}
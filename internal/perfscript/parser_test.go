@@ -0,0 +1,86 @@
+package perfscript
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/cpu-clock.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, samples, 4)
+
+	assert.Equal(t, &Sample{
+		Comm:   "yes",
+		TID:    12345,
+		CPU:    1,
+		Time:   1000.0001,
+		Period: 10101010,
+		Event:  "cpu-clock:pppH",
+		Stack: []Frame{
+			{Address: 0xffffffff81a0b2c5, Symbol: "_raw_spin_unlock_irqrestore", DSO: "[kernel.kallsyms]"},
+			{Address: 0x7f3a1c2d4e10, Symbol: "__GI___libc_write", DSO: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+			{Address: 0x55d0f0a1b2c3, Symbol: "main", DSO: "/usr/bin/yes"},
+			{Address: 0x7f3a1c229d8f, Symbol: "__libc_start_call_main", DSO: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+		},
+	}, samples[0])
+
+	assert.Equal(t, "Web Content", samples[2].Comm)
+	assert.Equal(t, []Frame{
+		{Address: 0x7f0000001000, Symbol: "std::vector<int>::push_back(int const&)", DSO: "/usr/lib/libxul.so"},
+		{Address: 0x7f0000002000, Symbol: "[unknown]", DSO: "[unknown]"},
+	}, samples[2].Stack)
+}
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		in       string
+		expected *Sample
+	}{
+		"withoutCallchain": {
+			in: "yes 100 [002] 12.500000: 250000 cycles:P: ffffffff81000000 do_syscall_64",
+			expected: &Sample{
+				Comm: "yes", TID: 100, CPU: 2, Time: 12.5, Period: 250000, Event: "cycles:P",
+				Args: "ffffffff81000000 do_syscall_64",
+			},
+		},
+		"withPID": {
+			in: "sh 200/201 [000] 1.000001: 1 cpu-clock:",
+			expected: &Sample{
+				Comm: "sh", PID: 200, TID: 201, Time: 1.000001, Period: 1, Event: "cpu-clock",
+			},
+		},
+		"tracepoint": {
+			in: "app 300 [001] 2.000000: sched:sched_switch: prev_comm=app prev_pid=300 prev_prio=120 prev_state=S ==> next_comm=swapper/1 next_pid=0 next_prio=120",
+			expected: &Sample{
+				Comm: "app", TID: 300, CPU: 1, Time: 2, Event: "sched:sched_switch",
+				Args: "prev_comm=app prev_pid=300 prev_prio=120 prev_state=S ==> next_comm=swapper/1 next_pid=0 next_prio=120",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			samples, err := Parse(strings.NewReader(tt.in + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, []*Sample{tt.expected}, samples)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package perfscript
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+type locationKey struct {
+	dso     string
+	address uint64
+	symbol  string
+}
+
+type functionKey struct {
+	dso    string
+	symbol string
+}
+
+// ProfileBuilder builds a pprof profile from samples of perf script.
+type ProfileBuilder struct {
+	profile   *profile.Profile
+	period    int64
+	mappings  map[string]*profile.Mapping
+	functions map[functionKey]*profile.Function
+	locations map[locationKey]*profile.Location
+}
+
+// NewProfileBuilder returns a builder for the samples recorded at the frequency in Hz.
+func NewProfileBuilder(frequency int) *ProfileBuilder {
+	return newProfileBuilder("cpu", int64(time.Second)/int64(frequency))
+}
+
+// NewOffCPUProfileBuilder returns a builder for the off-CPU profile.
+// The samples are added by AddWithValue with the time for which the threads are blocked.
+func NewOffCPUProfileBuilder() *ProfileBuilder {
+	return newProfileBuilder("off-cpu", 1)
+}
+
+func newProfileBuilder(sampleType string, period int64) *ProfileBuilder {
+	return &ProfileBuilder{
+		profile: &profile.Profile{
+			SampleType: []*profile.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: sampleType, Unit: "nanoseconds"},
+			},
+			DefaultSampleType: sampleType,
+			PeriodType:        &profile.ValueType{Type: sampleType, Unit: "nanoseconds"},
+			Period:            period,
+		},
+		period:    period,
+		mappings:  make(map[string]*profile.Mapping),
+		functions: make(map[functionKey]*profile.Function),
+		locations: make(map[locationKey]*profile.Location),
+	}
+}
+
+// Add adds a sample to the profile.
+func (b *ProfileBuilder) Add(s *Sample) {
+	b.AddWithLabels(s, nil)
+}
+
+// AddWithLabels adds a sample to the profile with the additional string labels.
+func (b *ProfileBuilder) AddWithLabels(s *Sample, labels map[string]string) {
+	// The period of the clock events is measured in nanoseconds.
+	// For other events, the period is estimated from the sampling frequency.
+	value := b.period
+	if isClockEvent(s.Event) && s.Period != 0 {
+		value = int64(s.Period)
+	}
+	b.AddWithValue(s, value, labels)
+}
+
+// AddWithValue adds a sample to the profile with the value in nanoseconds and the additional string labels.
+func (b *ProfileBuilder) AddWithValue(s *Sample, value int64, labels map[string]string) {
+	sample := &profile.Sample{
+		Value: []int64{1, value},
+		Label: map[string][]string{
+			"comm": {s.Comm},
+		},
+		NumLabel: map[string][]int64{
+			"tid": {int64(s.TID)},
+		},
+	}
+	if s.PID != 0 {
+		sample.NumLabel["pid"] = []int64{int64(s.PID)}
+	}
+	for k, v := range labels {
+		sample.Label[k] = []string{v}
+	}
+	for _, f := range s.Stack {
+		sample.Location = append(sample.Location, b.location(f))
+	}
+
+	b.profile.Sample = append(b.profile.Sample, sample)
+}
+
+// Profile returns the built profile.
+func (b *ProfileBuilder) Profile() *profile.Profile {
+	return b.profile
+}
+
+func (b *ProfileBuilder) mapping(dso string) *profile.Mapping {
+	if m, ok := b.mappings[dso]; ok {
+		return m
+	}
+
+	m := &profile.Mapping{
+		ID:   uint64(len(b.profile.Mapping) + 1),
+		File: dso,
+		// Symbols have already been resolved by perf script.
+		HasFunctions: true,
+	}
+	b.mappings[dso] = m
+	b.profile.Mapping = append(b.profile.Mapping, m)
+	return m
+}
+
+func (b *ProfileBuilder) function(f Frame) *profile.Function {
+	key := functionKey{dso: f.DSO, symbol: f.Symbol}
+	if fn, ok := b.functions[key]; ok {
+		return fn
+	}
+
+	fn := &profile.Function{
+		ID:         uint64(len(b.profile.Function) + 1),
+		Name:       f.Symbol,
+		SystemName: f.Symbol,
+		Filename:   filepath.Base(f.DSO),
+	}
+	b.functions[key] = fn
+	b.profile.Function = append(b.profile.Function, fn)
+	return fn
+}
+
+func (b *ProfileBuilder) location(f Frame) *profile.Location {
+	key := locationKey{dso: f.DSO, address: f.Address, symbol: f.Symbol}
+	if loc, ok := b.locations[key]; ok {
+		return loc
+	}
+
+	loc := &profile.Location{
+		ID:      uint64(len(b.profile.Location) + 1),
+		Mapping: b.mapping(f.DSO),
+		Address: f.Address,
+		Line: []profile.Line{
+			{Function: b.function(f)},
+		},
+	}
+	b.locations[key] = loc
+	b.profile.Location = append(b.profile.Location, loc)
+	return loc
+}
+
+func isClockEvent(event string) bool {
+	name, _, _ := strings.Cut(event, ":")
+	return name == "cpu-clock" || name == "task-clock"
+}
@@ -0,0 +1,66 @@
+package perfscript
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileBuilder(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/cpu-clock.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewProfileBuilder(99)
+	for _, s := range samples {
+		b.Add(s)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Profile().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(10101010), p.Period)
+	assert.Len(t, p.Sample, 4)
+	assert.Equal(t, []int64{1, 10101010}, p.Sample[0].Value)
+	assert.Equal(t, []string{"yes"}, p.Sample[0].Label["comm"])
+
+	var stack []string
+	for _, loc := range p.Sample[1].Location {
+		stack = append(stack, loc.Line[0].Function.Name)
+	}
+	assert.Equal(t, []string{"full_write", "main", "__libc_start_call_main"}, stack)
+
+	var files []string
+	for _, m := range p.Mapping {
+		files = append(files, m.File)
+	}
+	assert.ElementsMatch(t, []string{
+		"[kernel.kallsyms]",
+		"/usr/lib/x86_64-linux-gnu/libc.so.6",
+		"/usr/bin/yes",
+		"/usr/lib/libxul.so",
+		"[unknown]",
+	}, files)
+
+	// Locations with the same address share the same entry.
+	assert.Len(t, p.Location, 7)
+	assert.Len(t, p.Function, 7)
+}
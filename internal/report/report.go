@@ -0,0 +1,259 @@
+// Package report summarizes the output of perf script offline, such as the top functions and the samples per thread.
+package report
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cybozu-go/necoperf/internal/perfscript"
+)
+
+// Function is the number of the samples of a function.
+type Function struct {
+	Name string `json:"name"`
+	DSO  string `json:"dso"`
+	// Self is the number of the samples in which the function is running.
+	Self int64 `json:"self"`
+	// Total is the number of the samples in which the function is on the stack.
+	Total int64 `json:"total"`
+}
+
+// Thread is the number of the samples of a thread.
+type Thread struct {
+	// PID is zero if perf script is not run with the pid field.
+	PID     int    `json:"pid"`
+	TID     int    `json:"tid"`
+	Comm    string `json:"comm"`
+	Samples int64  `json:"samples"`
+}
+
+// Comm is the number of the samples of a command name.
+type Comm struct {
+	Name    string `json:"name"`
+	Samples int64  `json:"samples"`
+}
+
+// DSO is the number of the samples of a binary or a shared library.
+type DSO struct {
+	Name string `json:"name"`
+	// Self is the number of the samples in which a function of the DSO is running.
+	Self int64 `json:"self"`
+	// Total is the number of the samples in which a function of the DSO is on the stack.
+	Total int64 `json:"total"`
+}
+
+// Report is the summary of the samples. Each list is sorted in descending order of the samples.
+type Report struct {
+	Samples int64 `json:"samples"`
+	// TopSelf and TopTotal are the functions sorted by the self and the total samples.
+	TopSelf  []Function `json:"topSelf"`
+	TopTotal []Function `json:"topTotal"`
+	Threads  []Thread   `json:"threads"`
+	Comms    []Comm     `json:"comms"`
+	DSOs     []DSO      `json:"dsos"`
+}
+
+type functionKey struct {
+	name string
+	dso  string
+}
+
+type threadKey struct {
+	pid  int
+	tid  int
+	comm string
+}
+
+// Builder counts the samples to build a Report.
+type Builder struct {
+	samples   int64
+	functions map[functionKey]*Function
+	threads   map[threadKey]*Thread
+	comms     map[string]*Comm
+	dsos      map[string]*DSO
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		functions: make(map[functionKey]*Function),
+		threads:   make(map[threadKey]*Thread),
+		comms:     make(map[string]*Comm),
+		dsos:      make(map[string]*DSO),
+	}
+}
+
+// Add counts the sample.
+func (b *Builder) Add(s *perfscript.Sample) {
+	b.samples++
+
+	tk := threadKey{pid: s.PID, tid: s.TID, comm: s.Comm}
+	t, ok := b.threads[tk]
+	if !ok {
+		t = &Thread{PID: s.PID, TID: s.TID, Comm: s.Comm}
+		b.threads[tk] = t
+	}
+	t.Samples++
+
+	c, ok := b.comms[s.Comm]
+	if !ok {
+		c = &Comm{Name: s.Comm}
+		b.comms[s.Comm] = c
+	}
+	c.Samples++
+
+	if len(s.Stack) == 0 {
+		return
+	}
+	leaf := s.Stack[0]
+	b.function(leaf).Self++
+	b.dso(leaf.DSO).Self++
+
+	// Recursive functions and the DSOs appearing many times are counted once in a stack.
+	seenFunctions := make(map[functionKey]bool)
+	seenDSOs := make(map[string]bool)
+	for _, f := range s.Stack {
+		fk := functionKey{name: f.Symbol, dso: f.DSO}
+		if !seenFunctions[fk] {
+			seenFunctions[fk] = true
+			b.function(f).Total++
+		}
+		if !seenDSOs[f.DSO] {
+			seenDSOs[f.DSO] = true
+			b.dso(f.DSO).Total++
+		}
+	}
+}
+
+func (b *Builder) function(f perfscript.Frame) *Function {
+	key := functionKey{name: f.Symbol, dso: f.DSO}
+	fn, ok := b.functions[key]
+	if !ok {
+		fn = &Function{Name: f.Symbol, DSO: f.DSO}
+		b.functions[key] = fn
+	}
+	return fn
+}
+
+func (b *Builder) dso(name string) *DSO {
+	d, ok := b.dsos[name]
+	if !ok {
+		d = &DSO{Name: name}
+		b.dsos[name] = d
+	}
+	return d
+}
+
+// Report returns the summary of the samples added so far.
+// Each list has at most top entries. All the entries are returned if top is not positive.
+func (b *Builder) Report(top int) *Report {
+	functions := values(b.functions)
+	r := &Report{
+		Samples: b.samples,
+		TopSelf: slices.SortedFunc(slices.Values(functions), func(x, y Function) int {
+			return cmp.Or(cmp.Compare(y.Self, x.Self), cmp.Compare(y.Total, x.Total), compareFunction(x, y))
+		}),
+		TopTotal: slices.SortedFunc(slices.Values(functions), func(x, y Function) int {
+			return cmp.Or(cmp.Compare(y.Total, x.Total), cmp.Compare(y.Self, x.Self), compareFunction(x, y))
+		}),
+		Threads: slices.SortedFunc(slices.Values(values(b.threads)), func(x, y Thread) int {
+			return cmp.Or(cmp.Compare(y.Samples, x.Samples), cmp.Compare(x.PID, y.PID), cmp.Compare(x.TID, y.TID), strings.Compare(x.Comm, y.Comm))
+		}),
+		Comms: slices.SortedFunc(slices.Values(values(b.comms)), func(x, y Comm) int {
+			return cmp.Or(cmp.Compare(y.Samples, x.Samples), strings.Compare(x.Name, y.Name))
+		}),
+		DSOs: slices.SortedFunc(slices.Values(values(b.dsos)), func(x, y DSO) int {
+			return cmp.Or(cmp.Compare(y.Self, x.Self), cmp.Compare(y.Total, x.Total), strings.Compare(x.Name, y.Name))
+		}),
+	}
+	if top > 0 {
+		r.TopSelf = truncate(r.TopSelf, top)
+		r.TopTotal = truncate(r.TopTotal, top)
+		r.Threads = truncate(r.Threads, top)
+		r.Comms = truncate(r.Comms, top)
+		r.DSOs = truncate(r.DSOs, top)
+	}
+	return r
+}
+
+func compareFunction(x, y Function) int {
+	return cmp.Or(strings.Compare(x.Name, y.Name), strings.Compare(x.DSO, y.DSO))
+}
+
+func values[K comparable, V any](m map[K]*V) []V {
+	result := make([]V, 0, len(m))
+	for _, v := range m {
+		result = append(result, *v)
+	}
+	return result
+}
+
+func truncate[T any](s []T, n int) []T {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// Build reads the output of perf script from r and summarizes it.
+func Build(r io.Reader, top int) (*Report, error) {
+	b := NewBuilder()
+	p := perfscript.NewParser(r)
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			return b.Report(top), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.Add(s)
+	}
+}
+
+// Write writes the report as tables.
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Samples: %d\n", r.Samples)
+
+	fmt.Fprintln(tw, "\nTop functions by self samples")
+	r.writeFunctions(tw, r.TopSelf)
+	fmt.Fprintln(tw, "\nTop functions by total samples")
+	r.writeFunctions(tw, r.TopTotal)
+
+	fmt.Fprintln(tw, "\nThreads")
+	fmt.Fprintln(tw, "SAMPLES\tSHARE\tPID\tTID\tCOMM")
+	for _, t := range r.Threads {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\n", t.Samples, r.share(t.Samples), t.PID, t.TID, t.Comm)
+	}
+
+	fmt.Fprintln(tw, "\nCommands")
+	fmt.Fprintln(tw, "SAMPLES\tSHARE\tCOMM")
+	for _, c := range r.Comms {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", c.Samples, r.share(c.Samples), c.Name)
+	}
+
+	fmt.Fprintln(tw, "\nDSOs")
+	fmt.Fprintln(tw, "SELF\tSELF%\tTOTAL\tTOTAL%\tDSO")
+	for _, d := range r.DSOs {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", d.Self, r.share(d.Self), d.Total, r.share(d.Total), d.Name)
+	}
+	return tw.Flush()
+}
+
+func (r *Report) writeFunctions(w io.Writer, functions []Function) {
+	fmt.Fprintln(w, "SELF\tSELF%\tTOTAL\tTOTAL%\tFUNCTION\tDSO")
+	for _, f := range functions {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\n", f.Self, r.share(f.Self), f.Total, r.share(f.Total), f.Name, f.DSO)
+	}
+}
+
+func (r *Report) share(samples int64) string {
+	if r.Samples == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(samples)/float64(r.Samples)*100)
+}
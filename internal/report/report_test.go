@@ -0,0 +1,125 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+// assertGolden compares the actual output with the golden file, or updates it with -update.
+func assertGolden(t *testing.T, path string, actual []byte) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(expected), string(actual), path)
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	// Each fixture has the golden files of the text and the JSON output with the same name.
+	scripts, err := filepath.Glob("testdata/*.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no fixtures")
+	}
+
+	for _, script := range scripts {
+		name := strings.TrimSuffix(script, ".script")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r, err := Build(f, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var text bytes.Buffer
+			if err := r.Write(&text); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, name+".txt", text.Bytes())
+
+			data, err := json.MarshalIndent(r, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, name+".json", append(data, '\n'))
+		})
+	}
+}
+
+func TestBuildTop(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/perf.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := Build(f, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(10), r.Samples)
+	assert.Equal(t, []Function{
+		{Name: "compute", DSO: "/app/bin/app", Self: 3, Total: 4},
+		{Name: "__memmove_avx_unaligned_erms", DSO: "/usr/lib/x86_64-linux-gnu/libc.so.6", Self: 2, Total: 2},
+	}, r.TopSelf)
+	assert.Equal(t, []Function{
+		{Name: "__libc_start_main", DSO: "/usr/lib/x86_64-linux-gnu/libc.so.6", Self: 0, Total: 6},
+		{Name: "compute", DSO: "/app/bin/app", Self: 3, Total: 4},
+	}, r.TopTotal)
+	assert.Len(t, r.Threads, 2)
+	assert.Len(t, r.Comms, 2)
+	assert.Len(t, r.DSOs, 2)
+}
+
+func TestBuilderRecursion(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/perf.script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := Build(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fib is on the stacks of 2 samples many times, and is counted once in each of them.
+	for _, fn := range r.TopSelf {
+		if fn.Name == "fib" {
+			assert.Equal(t, Function{Name: "fib", DSO: "/app/bin/app", Self: 2, Total: 2}, fn)
+			return
+		}
+	}
+	t.Error("fib is not found")
+}
@@ -0,0 +1,169 @@
+package resource
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+)
+
+// ExecArchive writes a tar archive of the perf.data file and the build-ID cache of the binaries with samples to w.
+// The binaries are listed by perf buildid-list like perf archive, and the ones which Symbolize added to the cache
+// are put under constants.ArchiveBuildIDDirName in the layout of the cache, so that perf report can resolve
+// the symbols on another machine with --buildid-dir after the archive is extracted.
+// The others are listed in constants.ArchiveMissingFileName in the format of perf buildid-list.
+// The kernel and the other pseudo binaries are not included.
+func (p *PerfExecuter) ExecArchive(ctx context.Context, path string, w io.Writer) error {
+	found, err := p.hasPerfEvent(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNoEvents
+	}
+
+	tw := tar.NewWriter(w)
+	if err := addTarFile(tw, path, constants.ProfilingFileName); err != nil {
+		return err
+	}
+
+	if len(p.buildIDDir) != 0 {
+		dsos, err := p.listBuildIDs(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		// The cache may be being updated by Symbolize of the other sessions.
+		p.cacheMu.Lock()
+		defer p.cacheMu.Unlock()
+		var missing bytes.Buffer
+		for _, d := range dsos {
+			found, err := p.archiveBuildID(tw, d.buildID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				fmt.Fprintf(&missing, "%s %s\n", d.buildID, d.path)
+			}
+		}
+		if missing.Len() != 0 {
+			p.logger.Warn("binaries are not included in the archive", "path", path, "missing", missing.String())
+			if err := addTarData(tw, missing.Bytes(), constants.ArchiveMissingFileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// archiveBuildID adds the link of the build ID in .build-id and the cached files which it points to.
+// It returns false if the build ID is not in the cache.
+func (p *PerfExecuter) archiveBuildID(tw *tar.Writer, buildID string) (bool, error) {
+	linkName := filepath.Join(".build-id", buildID[:2], buildID[2:])
+	link := filepath.Join(p.buildIDDir, linkName)
+	fi, err := os.Lstat(link)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return true, addTarFile(tw, link, filepath.Join(constants.ArchiveBuildIDDirName, linkName))
+	}
+
+	root, err := filepath.EvalSymlinks(p.buildIDDir)
+	if err != nil {
+		return false, err
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if os.IsNotExist(err) {
+		// The cached file has been removed.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		// perf links to the files in the cache only, so the others are not exposed.
+		p.logger.Warn("build-ID cache links outside of the cache", "link", link, "target", target)
+		return false, nil
+	}
+
+	linkTarget, err := os.Readlink(link)
+	if err != nil {
+		return false, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     filepath.Join(constants.ArchiveBuildIDDirName, linkName),
+		Linkname: linkTarget,
+		Mode:     0777,
+		ModTime:  fi.ModTime(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// The link points to the cached file in older versions of perf, and to the directory containing it in newer ones.
+	return true, filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return addTarFile(tw, path, filepath.Join(constants.ArchiveBuildIDDirName, rel))
+	})
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func addTarData(tw *tar.Writer, data []byte, name string) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    filepath.ToSlash(name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
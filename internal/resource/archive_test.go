@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecArchiveWithFakePerf(t *testing.T) {
+	t.Parallel()
+
+	const (
+		dirID     = "1111111111111111111111111111111111111111"
+		fileID    = "2222222222222222222222222222222222222222"
+		missingID = "3333333333333333333333333333333333333333"
+		outsideID = "4444444444444444444444444444444444444444"
+	)
+
+	p := newFakePerfExecuter(t)
+	buildIDDir := filepath.Join(t.TempDir(), "buildid")
+	if err := p.EnableSymbolization(buildIDDir, SymbolStore{}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile := func(name, content string) {
+		path := filepath.Join(buildIDDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(name, target string) {
+		path := filepath.Join(buildIDDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The link points to the directory of the cached files like newer versions of perf.
+	writeFile("usr/bin/app/"+dirID+"/elf", "app")
+	writeFile("usr/bin/app/"+dirID+"/debug", "app debuginfo")
+	symlink(".build-id/11/"+dirID[2:], "../../usr/bin/app/"+dirID)
+	// The link points to the cached file like older versions of perf.
+	writeFile("usr/lib/libc.so.6/"+fileID, "libc")
+	symlink(".build-id/22/"+fileID[2:], "../../usr/lib/libc.so.6/"+fileID)
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(".build-id/44/"+outsideID[2:], outside)
+
+	// The fake perf buildid-list prints the content of the input file.
+	dataPath := filepath.Join(t.TempDir(), "perf.data")
+	list := []string{
+		dirID + " /usr/bin/app",
+		fileID + " /usr/lib/libc.so.6",
+		missingID + " /usr/bin/missing",
+		outsideID + " /usr/bin/outside",
+	}
+	if err := os.WriteFile(dataPath, []byte(strings.Join(list, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.ExecArchive(context.Background(), dataPath, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	links := make(map[string]string)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			links[hdr.Name] = hdr.Linkname
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+
+	assert.Equal(t, map[string]string{
+		".debug/.build-id/11/" + dirID[2:]:  "../../usr/bin/app/" + dirID,
+		".debug/.build-id/22/" + fileID[2:]: "../../usr/lib/libc.so.6/" + fileID,
+	}, links)
+	assert.Equal(t, map[string]string{
+		"perf.data":                              strings.Join(list, "\n") + "\n",
+		".debug/usr/bin/app/" + dirID + "/elf":   "app",
+		".debug/usr/bin/app/" + dirID + "/debug": "app debuginfo",
+		".debug/usr/lib/libc.so.6/" + fileID:     "libc",
+		// The binaries not in the cache are reported.
+		"missing-buildids": missingID + " /usr/bin/missing\n" + outsideID + " /usr/bin/outside\n",
+	}, files)
+}
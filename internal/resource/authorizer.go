@@ -0,0 +1,76 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Authorizer authenticates and authorizes the callers of necoperf-daemon with the Kubernetes API.
+type Authorizer struct {
+	logger *slog.Logger
+	client client.Client
+}
+
+func NewAuthorizer(logger *slog.Logger, client client.Client) *Authorizer {
+	return &Authorizer{
+		logger: logger,
+		client: client,
+	}
+}
+
+// Authenticate returns the user of the bearer token by TokenReview.
+func (a *Authorizer) Authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}
+	if err := a.client.Create(ctx, review); err != nil {
+		return nil, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("token is not authenticated: %s", review.Status.Error)
+	}
+
+	return &review.Status.User, nil
+}
+
+// Authorize returns nil if the user is allowed to profile the pod by SubjectAccessReview.
+// The user must be allowed to create pods/profile in the namespace of the pod.
+func (a *Authorizer) Authorize(ctx context.Context, user *authenticationv1.UserInfo, pod *PodRef) error {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   pod.Namespace,
+				Verb:        constants.ProfileVerb,
+				Resource:    "pods",
+				Subresource: constants.ProfileSubresource,
+				Name:        pod.Name,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+		},
+	}
+	if err := a.client.Create(ctx, review); err != nil {
+		return fmt.Errorf("failed to review access: %w", err)
+	}
+	if !review.Status.Allowed {
+		a.logger.Info("profiling is denied", "user", user.Username, "pod", pod.String(), "reason", review.Status.Reason)
+		return fmt.Errorf("%s is not allowed to profile pod %s", user.Username, pod.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package resource
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const (
+	validToken = "valid-token"
+	testUser   = "system:serviceaccount:tenant:profiler"
+)
+
+// newFakeReviewClient returns a client which authenticates validToken as testUser,
+// and allows testUser to profile the pods in the "tenant" namespace.
+func newFakeReviewClient() client.Client {
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			switch review := obj.(type) {
+			case *authenticationv1.TokenReview:
+				if review.Spec.Token == validToken {
+					review.Status.Authenticated = true
+					review.Status.User = authenticationv1.UserInfo{
+						Username: testUser,
+						Groups:   []string{"system:serviceaccounts"},
+						Extra:    map[string]authenticationv1.ExtraValue{"scope": {"test"}},
+					}
+				}
+			case *authorizationv1.SubjectAccessReview:
+				attrs := review.Spec.ResourceAttributes
+				review.Status.Allowed = review.Spec.User == testUser &&
+					review.Spec.Extra["scope"][0] == "test" &&
+					attrs.Namespace == "tenant" &&
+					attrs.Verb == "create" &&
+					attrs.Resource == "pods" &&
+					attrs.Subresource == "profile"
+			}
+			return nil
+		},
+	}).Build()
+}
+
+func TestAuthorizer(t *testing.T) {
+	a := NewAuthorizer(slog.Default(), newFakeReviewClient())
+	ctx := context.Background()
+
+	_, err := a.Authenticate(ctx, "invalid-token")
+	assert.Error(t, err)
+
+	user, err := a.Authenticate(ctx, validToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testUser, user.Username)
+
+	testCases := map[string]struct {
+		pod     *PodRef
+		allowed bool
+	}{
+		"allowed": {
+			pod:     &PodRef{Namespace: "tenant", Name: "app"},
+			allowed: true,
+		},
+		"other namespace": {
+			pod:     &PodRef{Namespace: "other", Name: "app"},
+			allowed: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := a.Authorize(ctx, user, tc.pod)
+			if tc.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package resource
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCgroupRoot is the mount point of the cgroup hierarchy.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// cgroupPath converts cgroupsPath of the OCI runtime spec into the path in the cgroup hierarchy.
+// The systemd cgroup driver specifies it as "slice:prefix:name", e.g.
+// "kubepods-burstable-pod1234.slice:cri-containerd:abcd" is
+// "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-abcd.scope".
+// The cgroupfs driver specifies the path itself.
+func cgroupPath(cgroupsPath string) (string, error) {
+	if strings.HasPrefix(cgroupsPath, "/") {
+		return path.Clean(cgroupsPath), nil
+	}
+
+	parts := strings.Split(cgroupsPath, ":")
+	if len(parts) != 3 || len(parts[2]) == 0 {
+		return "", fmt.Errorf("invalid cgroups path %q", cgroupsPath)
+	}
+	slice, err := expandSlice(parts[0])
+	if err != nil {
+		return "", err
+	}
+
+	unit := parts[2]
+	if !strings.HasSuffix(unit, ".slice") {
+		if len(parts[1]) != 0 {
+			unit = parts[1] + "-" + unit
+		}
+		unit += ".scope"
+	}
+	return path.Join(slice, unit), nil
+}
+
+// expandSlice returns the path of the systemd slice, whose parents are named by the prefixes of its name.
+func expandSlice(slice string) (string, error) {
+	name, ok := strings.CutSuffix(slice, ".slice")
+	if !ok || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	if name == "-" {
+		return "/", nil
+	}
+
+	var p, prefix string
+	for _, component := range strings.Split(name, "-") {
+		if len(component) == 0 {
+			return "", fmt.Errorf("invalid slice name %q", slice)
+		}
+		p += "/" + prefix + component + ".slice"
+		prefix += component + "-"
+	}
+	return p, nil
+}
+
+// CgroupProcesses returns the PIDs of the processes in the cgroup and its descendants.
+// root is the mount point of the cgroup hierarchy in which cgroup.procs files are read.
+func CgroupProcesses(root, cgroup string) ([]int, error) {
+	var pids []int
+	err := filepath.WalkDir(filepath.Join(root, cgroup), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(filepath.Join(p, "cgroup.procs"))
+		if err != nil {
+			// The cgroup may be removed while walking.
+			if os.IsNotExist(err) && p != filepath.Join(root, cgroup) {
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				return fmt.Errorf("invalid PID in %s: %w", f.Name(), err)
+			}
+			if !slices.Contains(pids, pid) {
+				pids = append(pids, pid)
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pids, nil
+}
+
+// CgroupCPUUsage returns the total CPU time consumed by the processes in the cgroup and its descendants.
+// It reads usage_usec in cpu.stat of the cgroup v2 hierarchy mounted at root.
+func CgroupCPUUsage(root, cgroup string) (time.Duration, error) {
+	f, err := os.Open(filepath.Join(root, cgroup, "cpu.stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || key != "usage_usec" {
+			continue
+		}
+		usec, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid usage_usec in %s: %w", f.Name(), err)
+		}
+		return time.Duration(usec) * time.Microsecond, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("usage_usec is not found in %s", f.Name())
+}
@@ -0,0 +1,115 @@
+package resource
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupPath(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		cgroupsPath string
+		expected    string
+		err         bool
+	}{
+		"systemd": {
+			cgroupsPath: "kubepods-burstable-pod1234.slice:cri-containerd:abcd",
+			expected:    "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-abcd.scope",
+		},
+		"systemdRootSlice": {
+			cgroupsPath: "-.slice:docker:abcd",
+			expected:    "/docker-abcd.scope",
+		},
+		"cgroupfs": {
+			cgroupsPath: "/kubepods/burstable/pod1234/abcd",
+			expected:    "/kubepods/burstable/pod1234/abcd",
+		},
+		"invalidSlice": {
+			cgroupsPath: "kubepods--pod1234.slice:cri-containerd:abcd",
+			err:         true,
+		},
+		"notSlice": {
+			cgroupsPath: "kubepods:cri-containerd:abcd",
+			err:         true,
+		},
+		"relative": {
+			cgroupsPath: "kubepods/abcd",
+			err:         true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p, err := cgroupPath(tt.cgroupsPath)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, p)
+		})
+	}
+}
+
+func TestCgroupProcesses(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	cgroup := "/kubepods.slice/cri-containerd-abcd.scope"
+	writeProcs := func(dir, procs string) {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "cgroup.procs"), []byte(procs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProcs("/kubepods.slice", "1\n")
+	writeProcs(cgroup, "100\n101\n")
+	// Processes in the nested cgroups are included.
+	writeProcs(cgroup+"/worker", "102\n")
+	writeProcs(cgroup+"/empty", "")
+
+	pids, err := CgroupProcesses(root, cgroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []int{100, 101, 102}, pids)
+
+	_, err = CgroupProcesses(root, "/non-existent")
+	assert.Error(t, err)
+}
+
+func TestCgroupCPUUsage(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeStat := func(dir, stat string) {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "cpu.stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeStat("/valid", "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n")
+	writeStat("/invalid", "usage_usec abc\n")
+	writeStat("/missing", "user_usec 1000000\n")
+
+	usage, err := CgroupCPUUsage(root, "/valid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1500*time.Millisecond, usage)
+
+	for _, cgroup := range []string{"/invalid", "/missing", "/non-existent"} {
+		_, err := CgroupCPUUsage(root, cgroup)
+		assert.Error(t, err, cgroup)
+	}
+}
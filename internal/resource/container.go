@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/cybozu-go/necoperf/internal/constants"
 	criapi "k8s.io/cri-api/pkg/apis"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
@@ -16,7 +17,12 @@ type Container struct {
 }
 
 type containerStatus struct {
-	PID int `json:"pid"`
+	PID         int `json:"pid"`
+	RuntimeSpec struct {
+		Linux struct {
+			CgroupsPath string `json:"cgroupsPath"`
+		} `json:"linux"`
+	} `json:"runtimeSpec"`
 }
 
 func NewContainer(logger *slog.Logger, criClient criapi.RuntimeService) *Container {
@@ -26,24 +32,209 @@ func NewContainer(logger *slog.Logger, criClient criapi.RuntimeService) *Contain
 	}
 }
 
-// GetPidFromContainerID returns the pid of the container
-func (c *Container) GetPidFromContainerID(ctx context.Context, containerID string) (int, error) {
+// ContainerInfo is the information of a running container.
+type ContainerInfo struct {
+	ID   string
+	Name string
+	PID  int
+	// Namespace and PodName are those of the pod of the container. They are empty if the container is not in a pod.
+	Namespace string
+	PodName   string
+	// CgroupPath is the path of the cgroup of the container in the cgroup hierarchy.
+	// It is empty if the container runtime does not tell it.
+	CgroupPath string
+	// PIDs are the processes to be profiled in the container. Only PID is profiled if it is empty.
+	PIDs []int
+	// PodAnnotations are the annotations of the pod. They are set only by the methods listing the containers.
+	PodAnnotations map[string]string
+}
+
+// Processes returns the PIDs of the processes to be profiled in the container.
+func (c *ContainerInfo) Processes() []int {
+	if len(c.PIDs) == 0 {
+		return []int{c.PID}
+	}
+	return c.PIDs
+}
+
+// GetContainerInfo returns the information of the running container.
+func (c *Container) GetContainerInfo(ctx context.Context, containerID string) (*ContainerInfo, error) {
 	var status containerStatus
 
 	resp, err := c.criClient.ContainerStatus(ctx, containerID, true)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
 	if resp.Status.State != runtimeapi.ContainerState_CONTAINER_RUNNING {
-		return -1, fmt.Errorf("%q container is not running", containerID)
+		return nil, fmt.Errorf("%q container is not running", containerID)
 	}
 
 	for k := range resp.Info {
 		if err := json.Unmarshal([]byte(resp.Info[k]), &status); err != nil {
-			return -1, err
+			return nil, err
 		}
 	}
 
-	return status.PID, nil
+	info := &ContainerInfo{
+		ID:        containerID,
+		Name:      resp.Status.GetMetadata().GetName(),
+		PID:       status.PID,
+		Namespace: resp.Status.GetLabels()[constants.CRILabelPodNamespace],
+		PodName:   resp.Status.GetLabels()[constants.CRILabelPodName],
+	}
+	if cgroupsPath := status.RuntimeSpec.Linux.CgroupsPath; len(cgroupsPath) != 0 {
+		path, err := cgroupPath(cgroupsPath)
+		if err != nil {
+			c.logger.Warn("failed to get the cgroup of the container", "containerID", containerID, "error", err)
+		} else {
+			info.CgroupPath = path
+		}
+	}
+	return info, nil
+}
+
+// PodRef identifies the pod to which a container belongs.
+type PodRef struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+func (p *PodRef) String() string {
+	return p.Namespace + "/" + p.Name
+}
+
+// GetPodRef returns the pod to which the container belongs.
+// The pod is identified by the labels which kubelet sets to the pod sandbox of the container.
+func (c *Container) GetPodRef(ctx context.Context, containerID string) (*PodRef, error) {
+	containers, err := c.criClient.ListContainers(ctx, &runtimeapi.ContainerFilter{Id: containerID})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("container %q not found", containerID)
+	}
+
+	resp, err := c.criClient.PodSandboxStatus(ctx, containers[0].GetPodSandboxId(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := resp.GetStatus().GetLabels()
+	ref := &PodRef{
+		Namespace: labels[constants.CRILabelPodNamespace],
+		Name:      labels[constants.CRILabelPodName],
+		UID:       labels[constants.CRILabelPodUID],
+	}
+	if len(ref.Namespace) == 0 || len(ref.Name) == 0 {
+		return nil, fmt.Errorf("container %q does not belong to any Kubernetes pod", containerID)
+	}
+
+	return ref, nil
+}
+
+// FindContainerID returns the ID of the running container in the pod on this node.
+// If the container has been restarted, the latest one is returned.
+// It returns an empty string if the container is not running.
+func (c *Container) FindContainerID(ctx context.Context, pod *PodRef, containerName string) (string, error) {
+	containers, err := c.criClient.ListContainers(ctx, &runtimeapi.ContainerFilter{
+		State: &runtimeapi.ContainerStateValue{State: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		LabelSelector: map[string]string{
+			constants.CRILabelPodNamespace:  pod.Namespace,
+			constants.CRILabelPodName:       pod.Name,
+			constants.CRILabelContainerName: containerName,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var latest *runtimeapi.Container
+	for _, container := range containers {
+		if latest == nil || container.GetCreatedAt() > latest.GetCreatedAt() {
+			latest = container
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.GetId(), nil
+}
+
+// HasPod returns true if the pod sandbox exists on this node.
+func (c *Container) HasPod(ctx context.Context, pod *PodRef) (bool, error) {
+	sandboxes, err := c.criClient.ListPodSandbox(ctx, &runtimeapi.PodSandboxFilter{
+		LabelSelector: map[string]string{
+			constants.CRILabelPodNamespace: pod.Namespace,
+			constants.CRILabelPodName:      pod.Name,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(sandboxes) != 0, nil
+}
+
+// ListAnnotatedContainers returns the running containers of the ready pods on this node annotated with the key and "true".
+// The annotations of the pods are passed to the pod sandboxes by kubelet.
+func (c *Container) ListAnnotatedContainers(ctx context.Context, key string) ([]*ContainerInfo, error) {
+	return c.listContainers(ctx, func(annotations map[string]string) bool {
+		return annotations[key] == "true"
+	})
+}
+
+// ListContainersWithAnnotation returns the running containers of the ready pods on this node annotated with the key,
+// whatever its value is.
+func (c *Container) ListContainersWithAnnotation(ctx context.Context, key string) ([]*ContainerInfo, error) {
+	return c.listContainers(ctx, func(annotations map[string]string) bool {
+		_, ok := annotations[key]
+		return ok
+	})
+}
+
+// listContainers returns the running containers of the ready pods on this node whose annotations match.
+func (c *Container) listContainers(ctx context.Context, match func(annotations map[string]string) bool) ([]*ContainerInfo, error) {
+	sandboxes, err := c.criClient.ListPodSandbox(ctx, &runtimeapi.PodSandboxFilter{
+		State: &runtimeapi.PodSandboxStateValue{State: runtimeapi.PodSandboxState_SANDBOX_READY},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*ContainerInfo
+	for _, sandbox := range sandboxes {
+		if !match(sandbox.GetAnnotations()) {
+			continue
+		}
+
+		containers, err := c.criClient.ListContainers(ctx, &runtimeapi.ContainerFilter{
+			PodSandboxId: sandbox.GetId(),
+			State:        &runtimeapi.ContainerStateValue{State: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, container := range containers {
+			info, err := c.GetContainerInfo(ctx, container.GetId())
+			if err != nil {
+				// The container may have exited after it was listed.
+				c.logger.Info("failed to get container info", "containerID", container.GetId(), "error", err)
+				continue
+			}
+			info.PodAnnotations = sandbox.GetAnnotations()
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// GetPidFromContainerID returns the pid of the container
+func (c *Container) GetPidFromContainerID(ctx context.Context, containerID string) (int, error) {
+	info, err := c.GetContainerInfo(ctx, containerID)
+	if err != nil {
+		return -1, err
+	}
+
+	return info.PID, nil
 }
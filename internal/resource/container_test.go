@@ -3,8 +3,10 @@ package resource
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"testing"
 
+	"github.com/cybozu-go/necoperf/internal/constants"
 	"github.com/stretchr/testify/assert"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	apitesting "k8s.io/cri-api/pkg/apis/testing"
@@ -20,6 +22,9 @@ func TestGetPidFromContainerIDInvalidContainerID(t *testing.T) {
 	containers := map[string]*apitesting.FakeContainer{
 		runningContainerID: {
 			ContainerStatus: runtimeapi.ContainerStatus{
+				Metadata: &runtimeapi.ContainerMetadata{
+					Name: "app",
+				},
 				State: runtimeapi.ContainerState_CONTAINER_RUNNING,
 			},
 		},
@@ -52,4 +57,193 @@ func TestGetPidFromContainerIDInvalidContainerID(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.Equal(t, 0, pid)
+
+	info, err := c.GetContainerInfo(context.Background(), runningContainerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &ContainerInfo{ID: runningContainerID, Name: "app"}, info)
+}
+
+func TestGetPodRef(t *testing.T) {
+	t.Parallel()
+
+	fakeRuntimeService := &apitesting.FakeRuntimeService{
+		Containers: map[string]*apitesting.FakeContainer{
+			"app": {
+				ContainerStatus: runtimeapi.ContainerStatus{Id: "app"},
+				SandboxID:       "pod-sandbox",
+			},
+			"no-labels": {
+				ContainerStatus: runtimeapi.ContainerStatus{Id: "no-labels"},
+				SandboxID:       "other-sandbox",
+			},
+		},
+		Sandboxes: map[string]*apitesting.FakePodSandbox{
+			"pod-sandbox": {
+				PodSandboxStatus: runtimeapi.PodSandboxStatus{
+					Id: "pod-sandbox",
+					Labels: map[string]string{
+						constants.CRILabelPodNamespace: "tenant",
+						constants.CRILabelPodName:      "app-pod",
+						constants.CRILabelPodUID:       "uid",
+					},
+				},
+			},
+			"other-sandbox": {
+				PodSandboxStatus: runtimeapi.PodSandboxStatus{Id: "other-sandbox"},
+			},
+		},
+	}
+	c := NewContainer(nil, fakeRuntimeService)
+
+	ref, err := c.GetPodRef(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &PodRef{Namespace: "tenant", Name: "app-pod", UID: "uid"}, ref)
+
+	_, err = c.GetPodRef(context.Background(), "no-labels")
+	assert.Error(t, err)
+
+	_, err = c.GetPodRef(context.Background(), "non-existent")
+	assert.Error(t, err)
+}
+
+func TestFindContainerID(t *testing.T) {
+	t.Parallel()
+
+	podLabels := map[string]string{
+		constants.CRILabelPodNamespace: "tenant",
+		constants.CRILabelPodName:      "app-pod",
+	}
+	containerLabels := map[string]string{
+		constants.CRILabelPodNamespace:  "tenant",
+		constants.CRILabelPodName:       "app-pod",
+		constants.CRILabelContainerName: "app",
+	}
+	fakeRuntimeService := &apitesting.FakeRuntimeService{
+		Containers: map[string]*apitesting.FakeContainer{
+			"exited": {
+				ContainerStatus: runtimeapi.ContainerStatus{
+					Id:        "exited",
+					State:     runtimeapi.ContainerState_CONTAINER_EXITED,
+					CreatedAt: 3,
+					Labels:    containerLabels,
+				},
+			},
+			"old": {
+				ContainerStatus: runtimeapi.ContainerStatus{
+					Id:        "old",
+					State:     runtimeapi.ContainerState_CONTAINER_RUNNING,
+					CreatedAt: 1,
+					Labels:    containerLabels,
+				},
+			},
+			"new": {
+				ContainerStatus: runtimeapi.ContainerStatus{
+					Id:        "new",
+					State:     runtimeapi.ContainerState_CONTAINER_RUNNING,
+					CreatedAt: 2,
+					Labels:    containerLabels,
+				},
+			},
+		},
+		Sandboxes: map[string]*apitesting.FakePodSandbox{
+			"pod-sandbox": {
+				PodSandboxStatus: runtimeapi.PodSandboxStatus{
+					Id:     "pod-sandbox",
+					Labels: podLabels,
+				},
+			},
+		},
+	}
+	c := NewContainer(nil, fakeRuntimeService)
+	ctx := context.Background()
+	pod := &PodRef{Namespace: "tenant", Name: "app-pod"}
+
+	containerID, err := c.FindContainerID(ctx, pod, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "new", containerID)
+
+	containerID, err = c.FindContainerID(ctx, pod, "sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, containerID)
+
+	found, err := c.HasPod(ctx, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, found)
+
+	found, err = c.HasPod(ctx, &PodRef{Namespace: "tenant", Name: "other-pod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, found)
+}
+
+func TestListAnnotatedContainers(t *testing.T) {
+	t.Parallel()
+
+	const key = "example.com/profile"
+	sandbox := func(id, annotation string, state runtimeapi.PodSandboxState) *apitesting.FakePodSandbox {
+		return &apitesting.FakePodSandbox{
+			PodSandboxStatus: runtimeapi.PodSandboxStatus{
+				Id:          id,
+				State:       state,
+				Labels:      map[string]string{constants.CRILabelPodNamespace: "tenant", constants.CRILabelPodName: id},
+				Annotations: map[string]string{key: annotation},
+			},
+		}
+	}
+	container := func(id, sandboxID string, state runtimeapi.ContainerState) *apitesting.FakeContainer {
+		return &apitesting.FakeContainer{
+			ContainerStatus: runtimeapi.ContainerStatus{
+				Id:       id,
+				Metadata: &runtimeapi.ContainerMetadata{Name: id},
+				State:    state,
+				Labels:   map[string]string{constants.CRILabelPodNamespace: "tenant", constants.CRILabelPodName: sandboxID},
+			},
+			SandboxID: sandboxID,
+		}
+	}
+
+	fakeRuntimeService := &apitesting.FakeRuntimeService{
+		Containers: map[string]*apitesting.FakeContainer{
+			"app":       container("app", "annotated", runtimeapi.ContainerState_CONTAINER_RUNNING),
+			"exited":    container("exited", "annotated", runtimeapi.ContainerState_CONTAINER_EXITED),
+			"other":     container("other", "not-annotated", runtimeapi.ContainerState_CONTAINER_RUNNING),
+			"not-ready": container("not-ready", "not-ready", runtimeapi.ContainerState_CONTAINER_RUNNING),
+		},
+		Sandboxes: map[string]*apitesting.FakePodSandbox{
+			"annotated":     sandbox("annotated", "true", runtimeapi.PodSandboxState_SANDBOX_READY),
+			"not-annotated": sandbox("not-annotated", "false", runtimeapi.PodSandboxState_SANDBOX_READY),
+			"not-ready":     sandbox("not-ready", "true", runtimeapi.PodSandboxState_SANDBOX_NOTREADY),
+		},
+	}
+	c := NewContainer(slog.Default(), fakeRuntimeService)
+
+	infos, err := c.ListAnnotatedContainers(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []*ContainerInfo{
+		{ID: "app", Name: "app", Namespace: "tenant", PodName: "annotated", PodAnnotations: map[string]string{key: "true"}},
+	}, infos)
+
+	// The value of the annotation does not matter.
+	infos, err = c.ListContainersWithAnnotation(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+	}
+	assert.ElementsMatch(t, []string{"app", "other"}, ids)
 }
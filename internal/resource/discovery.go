@@ -9,10 +9,15 @@ import (
 	"strconv"
 
 	"github.com/cybozu-go/necoperf/internal/constants"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+var containerIDSchemeRegexp = regexp.MustCompile("[a-z]*://")
+
 type Discovery struct {
 	logger *slog.Logger
 	client client.Client
@@ -39,6 +44,58 @@ func (d *Discovery) GetPod(ctx context.Context, namespace, podName string) (*cor
 	return pod, nil
 }
 
+// ListRunningPods returns the running pods in the namespace which match the selector.
+func (d *Discovery) ListRunningPods(ctx context.Context, namespace string, selector labels.Selector) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	err := d.client.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	var running []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		running = append(running, pod)
+	}
+
+	return running, nil
+}
+
+// GetWorkloadSelector returns the pod selector of the workload.
+// kind is either "Deployment" or "StatefulSet".
+func (d *Discovery) GetWorkloadSelector(ctx context.Context, namespace, kind, name string) (labels.Selector, error) {
+	var ls *metav1.LabelSelector
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	switch kind {
+	case "Deployment":
+		deploy := &appsv1.Deployment{}
+		if err := d.client.Get(ctx, key, deploy); err != nil {
+			return nil, err
+		}
+		ls = deploy.Spec.Selector
+	case "StatefulSet":
+		sts := &appsv1.StatefulSet{}
+		if err := d.client.Get(ctx, key, sts); err != nil {
+			return nil, err
+		}
+		ls = sts.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q", kind)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(ls)
+	if err != nil {
+		return nil, err
+	}
+	if selector.Empty() {
+		return nil, fmt.Errorf("%s %s/%s has an empty selector", kind, namespace, name)
+	}
+
+	return selector, nil
+}
+
 func (d *Discovery) GetPodList(ctx context.Context, necoperfNS string) (*corev1.PodList, error) {
 	pods := &corev1.PodList{}
 	err := d.client.List(ctx, pods, client.InNamespace(necoperfNS), client.MatchingLabels{
@@ -51,22 +108,48 @@ func (d *Discovery) GetPodList(ctx context.Context, necoperfNS string) (*corev1.
 	return pods, nil
 }
 
+// GetContainerID returns the ID of the container in the pod.
+// If containerName is empty, the container specified by the kubectl.kubernetes.io/default-container
+// annotation is used, or the first container if the annotation is not set.
 func (d *Discovery) GetContainerID(pod *corev1.Pod, containerName string) (string, error) {
-	if len(containerName) == 0 && len(pod.Spec.Containers) >= 1 {
-		containerName = pod.Spec.Containers[0].Name
+	if len(containerName) == 0 {
+		containerName = pod.Annotations[constants.AnnotationDefaultContainer]
+		if len(containerName) == 0 && len(pod.Spec.Containers) >= 1 {
+			containerName = pod.Spec.Containers[0].Name
+		}
+		d.logger.Info("container is not specified, so the default container is used", "container", containerName)
 	}
 
 	for i := range pod.Status.ContainerStatuses {
 		if pod.Status.ContainerStatuses[i].Name == containerName {
-			regex := regexp.MustCompile("[a-z]*://")
-			containerID := regex.ReplaceAllString(pod.Status.ContainerStatuses[i].ContainerID, "")
-			return containerID, nil
+			return trimContainerIDScheme(pod.Status.ContainerStatuses[i].ContainerID), nil
 		}
 	}
 
 	return "", errors.New("failed to get container ID")
 }
 
+// GetContainerIDs returns the IDs of all the running containers in the pod.
+func (d *Discovery) GetContainerIDs(pod *corev1.Pod) ([]string, error) {
+	var containerIDs []string
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.State.Running == nil || len(c.ContainerID) == 0 {
+			continue
+		}
+		containerIDs = append(containerIDs, trimContainerIDScheme(c.ContainerID))
+	}
+	if len(containerIDs) == 0 {
+		return nil, fmt.Errorf("no container is running in pod %s/%s", pod.Namespace, pod.Name)
+	}
+
+	return containerIDs, nil
+}
+
+// trimContainerIDScheme removes the scheme of the container runtime, e.g. "containerd://", from the container ID.
+func trimContainerIDScheme(containerID string) string {
+	return containerIDSchemeRegexp.ReplaceAllString(containerID, "")
+}
+
 func (d *Discovery) DiscoveryServerAddr(pods *corev1.PodList, hostIP string) (string, error) {
 	var podIP, addr string
 	var pod *corev1.Pod
@@ -7,6 +7,9 @@ import (
 	"github.com/cybozu-go/necoperf/internal/constants"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var _ = Describe("Test Discovery", func() {
@@ -21,6 +24,82 @@ var _ = Describe("Test Discovery", func() {
 		Expect(containerID).NotTo(BeEmpty())
 	})
 
+	It("should get container ids of running containers", func() {
+		pod := &corev1.Pod{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}, {Name: "envoy"}, {Name: "exited"}},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name:        "app",
+						ContainerID: "containerd://app",
+						State:       corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					},
+					{
+						Name:        "envoy",
+						ContainerID: "containerd://envoy",
+						State:       corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					},
+					{
+						Name:        "exited",
+						ContainerID: "containerd://exited",
+						State:       corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}},
+					},
+				},
+			},
+		}
+		containerIDs, err := d.GetContainerIDs(pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(containerIDs).To(Equal([]string{"app", "envoy"}))
+
+		By("using the default container annotation")
+		pod.Annotations = map[string]string{constants.AnnotationDefaultContainer: "envoy"}
+		containerID, err := d.GetContainerID(pod, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(containerID).To(Equal("envoy"))
+	})
+
+	It("should list running pods of a deployment", func() {
+		labels := map[string]string{"app": "replicas"}
+		deploy := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "replicas"},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+				},
+			},
+		}
+		err := k8sClient.Create(ctx, deploy)
+		Expect(err).NotTo(HaveOccurred())
+
+		for name, phase := range map[string]corev1.PodPhase{"replicas-running": corev1.PodRunning, "replicas-pending": corev1.PodPending} {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: name, Labels: labels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+			err := k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			pod.Status.Phase = phase
+			err = k8sClient.Status().Update(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		selector, err := d.GetWorkloadSelector(ctx, "test", "Deployment", "replicas")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selector.String()).To(Equal("app=replicas"))
+
+		pods, err := d.ListRunningPods(ctx, "test", selector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods).To(HaveLen(1))
+		Expect(pods[0].Name).To(Equal("replicas-running"))
+
+		_, err = d.GetWorkloadSelector(ctx, "test", "DaemonSet", "replicas")
+		Expect(err).To(HaveOccurred())
+	})
+
 	It("should discovery server addr", func() {
 		By("get test pod")
 		pod, err := d.GetPod(ctx, "test", "test-pod")
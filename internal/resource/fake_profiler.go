@@ -0,0 +1,175 @@
+package resource
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/google/uuid"
+)
+
+// FakeRecord is the arguments of a call to FakeProfiler.ExecRecord.
+type FakeRecord struct {
+	PIDs    []int
+	Timeout time.Duration
+	Options RecordOptions
+}
+
+// FakeStat is the arguments of a call to FakeProfiler.ExecStat.
+type FakeStat struct {
+	PIDs     []int
+	Duration time.Duration
+	Events   []string
+}
+
+// FakeProfiler is a Profiler which does not run perf. It is intended for tests.
+// ExecRecord waits for the timeout like perf record, and ExecScript writes Script.
+// ExecStat returns Counters immediately, Symbolize only records the PIDs, and SweepSymbols does nothing.
+type FakeProfiler struct {
+	// Script is the output of perf script.
+	Script []byte
+	// RecordErr is returned by ExecRecord if it is not nil.
+	RecordErr error
+	// ScriptErr is returned by ExecScript and ExecArchive if it is not nil.
+	ScriptErr error
+	// Samples is reported as the progress when ExecRecord starts waiting for the timeout.
+	Samples int
+	// Counters is returned by ExecStat.
+	Counters []StatCounter
+
+	mu         sync.Mutex
+	records    []FakeRecord
+	stats      []FakeStat
+	symbolized [][]int
+}
+
+var _ Profiler = &FakeProfiler{}
+
+// Records returns the arguments of the calls to ExecRecord.
+func (f *FakeProfiler) Records() []FakeRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeRecord(nil), f.records...)
+}
+
+// Stats returns the arguments of the calls to ExecStat.
+func (f *FakeProfiler) Stats() []FakeStat {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeStat(nil), f.stats...)
+}
+
+// Symbolized returns the PIDs passed to Symbolize.
+func (f *FakeProfiler) Symbolized() [][]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]int(nil), f.symbolized...)
+}
+
+func (f *FakeProfiler) ExecRecord(ctx context.Context, workDir string, pids []int, timeout time.Duration, opts RecordOptions, progress func(samples int)) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	f.records = append(f.records, FakeRecord{
+		PIDs:    pids,
+		Timeout: timeout,
+		Options: opts,
+	})
+	f.mu.Unlock()
+
+	if f.RecordErr != nil {
+		return "", f.RecordErr
+	}
+
+	profileDir := filepath.Join(workDir, constants.ProfileDirName)
+	if err := os.MkdirAll(profileDir, 0755); err != nil {
+		return "", err
+	}
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(profileDir, fmt.Sprintf("necoperf-%s.data", uuid.String()))
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return "", err
+	}
+	if progress != nil {
+		progress(f.Samples)
+	}
+
+	select {
+	case <-ctx.Done():
+		return path, fmt.Errorf("perf record is stopped: %w", ctx.Err())
+	case <-time.After(timeout):
+		return path, nil
+	}
+}
+
+func (f *FakeProfiler) ExecScript(ctx context.Context, path string, w io.Writer) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if f.ScriptErr != nil {
+		return f.ScriptErr
+	}
+
+	_, err := w.Write(f.Script)
+	return err
+}
+
+// ExecArchive writes a tar archive containing only the recorded data.
+func (f *FakeProfiler) ExecArchive(ctx context.Context, path string, w io.Writer) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if f.ScriptErr != nil {
+		return f.ScriptErr
+	}
+
+	tw := tar.NewWriter(w)
+	if err := addTarFile(tw, path, constants.ProfilingFileName); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (f *FakeProfiler) ExecStat(ctx context.Context, pids []int, duration time.Duration, events []string) ([]StatCounter, error) {
+	if err := ValidateStatEvents(events); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats = append(f.stats, FakeStat{
+		PIDs:     pids,
+		Duration: duration,
+		Events:   events,
+	})
+	return f.Counters, nil
+}
+
+func (f *FakeProfiler) Symbolize(ctx context.Context, path string, pids []int) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.symbolized = append(f.symbolized, pids)
+	return nil
+}
+
+func (f *FakeProfiler) SweepSymbols(now time.Time, maxAge time.Duration) error {
+	return nil
+}
@@ -1,40 +1,180 @@
 package resource
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cybozu-go/necoperf/internal/constants"
 	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	perfName = "perf"
+
+	// stopGracePeriod is the time to wait for perf to exit after SIGINT is sent.
+	stopGracePeriod = 10 * time.Second
+
+	// progressInterval is the interval to report the number of samples while recording.
+	progressInterval = 1 * time.Second
+)
+
+const (
+	DefaultFrequency      = 99
+	MaxFrequency          = 999
+	DefaultCallGraph      = "dwarf"
+	DefaultDwarfStackSize = 8192
+	MaxDwarfStackSize     = 65528
 )
 
+// ErrNoEvents is returned by ExecScript when no sample is recorded.
+var ErrNoEvents = errors.New("perf.data file does not contain events")
+
+// offCPUEvents are the events recorded for the off-CPU profile.
+// sched_switch records the stack on which a thread goes off CPU, and sched_stat_* record how long it was blocked
+// when it wakes up. The latter are delivered to the woken thread even though they occur in the waker.
+var offCPUEvents = []string{
+	constants.SchedSwitchEvent,
+	constants.SchedStatSleepEvent,
+	constants.SchedStatBlockedEvent,
+	constants.SchedStatIOWaitEvent,
+}
+
+var allowedCallGraphs = map[string]bool{
+	"fp":    true,
+	"dwarf": true,
+	"lbr":   true,
+}
+
+// RecordOptions is a set of options that users can specify for perf record.
+type RecordOptions struct {
+	Frequency      int
+	CallGraph      string
+	DwarfStackSize int
+	SystemWide     bool
+	// OffCPU records the scheduler tracepoints instead of sampling at Frequency.
+	OffCPU bool
+}
+
+func DefaultRecordOptions() RecordOptions {
+	return RecordOptions{
+		Frequency:      DefaultFrequency,
+		CallGraph:      DefaultCallGraph,
+		DwarfStackSize: DefaultDwarfStackSize,
+		SystemWide:     true,
+	}
+}
+
+// Validate checks the options against the allowlist and the upper limits.
+func (o RecordOptions) Validate() error {
+	if o.Frequency < 1 || o.Frequency > MaxFrequency {
+		return fmt.Errorf("frequency must be between 1 and %d: %d", MaxFrequency, o.Frequency)
+	}
+
+	if !allowedCallGraphs[o.CallGraph] {
+		return fmt.Errorf("call graph %q is not allowed", o.CallGraph)
+	}
+
+	if o.CallGraph != "dwarf" {
+		if o.DwarfStackSize != 0 {
+			return errors.New("dwarf stack size can only be specified with the dwarf call graph")
+		}
+		return nil
+	}
+	if o.DwarfStackSize < 8 || o.DwarfStackSize > MaxDwarfStackSize {
+		return fmt.Errorf("dwarf stack size must be between 8 and %d: %d", MaxDwarfStackSize, o.DwarfStackSize)
+	}
+	if o.DwarfStackSize%8 != 0 {
+		return fmt.Errorf("dwarf stack size must be a multiple of 8: %d", o.DwarfStackSize)
+	}
+
+	return nil
+}
+
+func (o RecordOptions) args() []string {
+	var args []string
+	if o.SystemWide {
+		args = append(args, "-a")
+	}
+
+	callGraph := o.CallGraph
+	if callGraph == "dwarf" {
+		callGraph = fmt.Sprintf("%s,%d", callGraph, o.DwarfStackSize)
+	}
+
+	if o.OffCPU {
+		for _, e := range offCPUEvents {
+			args = append(args, "-e", e)
+		}
+	} else {
+		args = append(args, "-F", strconv.Itoa(o.Frequency))
+	}
+	return append(args, "--call-graph", callGraph)
+}
+
+// Profiler records the profile of a process and converts it into the output of perf script.
+type Profiler interface {
+	// ExecRecord records the profile and returns the path of the recorded data in workDir.
+	// If progress is not nil, it is called with the number of samples collected so far while recording.
+	// It is not called after ExecRecord returns.
+	ExecRecord(ctx context.Context, workDir string, pids []int, timeout time.Duration, opts RecordOptions, progress func(samples int)) (string, error)
+	// ExecScript converts the recorded data into the output of perf script and writes it to w.
+	ExecScript(ctx context.Context, path string, w io.Writer) error
+	// ExecArchive writes a tar archive of the recorded data and the binaries to read it on another machine to w.
+	ExecArchive(ctx context.Context, path string, w io.Writer) error
+	// ExecStat counts the events of the processes for the duration.
+	ExecStat(ctx context.Context, pids []int, duration time.Duration, events []string) ([]StatCounter, error)
+	// Symbolize makes the symbols of the binaries of the processes recorded in the data available to ExecScript.
+	Symbolize(ctx context.Context, path string, pids []int) error
+	// SweepSymbols removes the binaries which have not been used by Symbolize for maxAge.
+	SweepSymbols(now time.Time, maxAge time.Duration) error
+}
+
+// PerfExecuter is a Profiler which runs the perf binary.
 type PerfExecuter struct {
 	logger  *slog.Logger
 	binPath string
+
+	// buildIDDir is the build-ID cache of perf. Symbolize does nothing if it is empty.
+	buildIDDir string
+	store      SymbolStore
+	httpClient *http.Client
+	// cacheMu serializes the updates of the build-ID cache by perf buildid-cache and the reads of it.
+	cacheMu sync.Mutex
+	// addGroup deduplicates the additions of the same build ID by the concurrent sessions.
+	addGroup singleflight.Group
 }
 
-func lookupBinary() (string, error) {
-	path, err := exec.LookPath(perfName)
+var _ Profiler = &PerfExecuter{}
+
+func lookupBinary(name string) (string, error) {
+	if len(name) == 0 {
+		name = perfName
+	}
+	path, err := exec.LookPath(name)
 	if err != nil {
 		return "", err
 	}
 	return path, nil
 }
 
-func NewPerfExecuter(logger *slog.Logger) (*PerfExecuter, error) {
-	path, err := lookupBinary()
+// NewPerfExecuter creates PerfExecuter which runs the perf binary at binPath.
+// If binPath is empty, perf is searched in the directories named by the PATH environment variable.
+func NewPerfExecuter(logger *slog.Logger, binPath string) (*PerfExecuter, error) {
+	path, err := lookupBinary(binPath)
 	if err != nil {
 		return nil, err
 	}
@@ -45,8 +185,43 @@ func NewPerfExecuter(logger *slog.Logger) (*PerfExecuter, error) {
 	}, nil
 }
 
-func (p *PerfExecuter) ExecRecord(ctx context.Context, workDir string, pid int, timeout time.Duration) (string, error) {
-	profileDir := filepath.Join(workDir, "profile")
+// command returns the command to run perf.
+// When ctx is done, perf is stopped by SIGINT instead of SIGKILL so that it can flush the data.
+func (p *PerfExecuter) command(ctx context.Context, args ...string) *exec.Cmd {
+	c := exec.CommandContext(ctx, p.binPath, args...)
+	c.Cancel = func() error {
+		return c.Process.Signal(os.Interrupt)
+	}
+	c.WaitDelay = stopGracePeriod
+	return c
+}
+
+// interrupted reports whether the command has exited gracefully after it was stopped by SIGINT.
+func interrupted(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == syscall.SIGINT
+}
+
+// ExecRecord records the profile of the process and returns the path of the perf.data file.
+// If ctx is done while recording, perf is stopped gracefully and the path of the partial data
+// is returned along with the error of ctx. On other errors, the file is removed.
+func (p *PerfExecuter) ExecRecord(ctx context.Context, workDir string, pids []int, timeout time.Duration, opts RecordOptions, progress func(samples int)) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	if len(pids) == 0 {
+		return "", errors.New("no PID is specified")
+	}
+
+	profileDir := filepath.Join(workDir, constants.ProfileDirName)
 	if err := os.MkdirAll(profileDir, 0755); err != nil {
 		return "", err
 	}
@@ -59,91 +234,138 @@ func (p *PerfExecuter) ExecRecord(ctx context.Context, workDir string, pid int,
 	profilingPath := filepath.Join(profileDir, profilingFileName)
 
 	t := timeout.Seconds()
-	perfArgs := []string{
-		constants.RecordSubcommand,
-		"-ag",
-		"-F", "99",
-		"--call-graph", "dwarf",
-		"-p", strconv.Itoa(pid),
+	perfArgs := p.globalArgs(constants.RecordSubcommand)
+	if len(p.buildIDDir) != 0 {
+		// Symbolize adds the binaries to the cache after looking up their debug files.
+		perfArgs = append(perfArgs, "--no-buildid-cache")
+	}
+	perfArgs = append(perfArgs, opts.args()...)
+	perfArgs = append(perfArgs,
+		"-p", joinPIDs(pids),
 		"-o", profilingPath,
 		"--", "sleep", strconv.Itoa(int(t)),
-	}
-	c := exec.CommandContext(ctx, p.binPath, perfArgs...)
+	)
+	c := p.command(ctx, perfArgs...)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	p.logger.Info("Executing perf record", "cmd", c.String())
 
-	return profilingPath, c.Run()
-}
-
-func (p *PerfExecuter) GetEvent(ctx context.Context, path string) (*bytes.Buffer, error) {
-	var stdoutBuff bytes.Buffer
-	perfArgs := []string{
-		constants.ScriptSubcommand,
-		"-F", "event",
-		"-i", path,
+	if err := c.Start(); err != nil {
+		return "", err
 	}
-
-	c := exec.CommandContext(ctx, p.binPath, perfArgs...)
-	c.Stdout = &stdoutBuff
-	c.Stderr = os.Stderr
-
-	if err := c.Run(); err != nil {
-		return nil, err
+	if progress != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			p.reportProgress(profilingPath, progress, stop)
+		}()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+	err = c.Wait()
+	if err != nil && ctx.Err() != nil && interrupted(err) {
+		p.logger.Info("perf record is stopped", "path", profilingPath)
+		return profilingPath, fmt.Errorf("perf record is stopped: %w", ctx.Err())
+	}
+	if err != nil {
+		os.Remove(profilingPath)
+		return "", err
 	}
-	return &stdoutBuff, nil
-}
 
-// Check if events are contained in the perf.data file.
-func (p *PerfExecuter) HasPerfEvent(ctx context.Context, buf *bytes.Buffer) bool {
-	return strings.Contains(buf.String(), constants.CyclesEvent) || strings.Contains(buf.String(), constants.CpuClockEvent)
+	return profilingPath, nil
 }
 
-func (p *PerfExecuter) ExecScript(ctx context.Context, path, workDir string) (string, error) {
-	var stdoutBuff bytes.Buffer
+// reportProgress calls progress with the number of samples in the file periodically until stop is closed.
+func (p *PerfExecuter) reportProgress(path string, progress func(samples int), stop <-chan struct{}) {
+	counter := &sampleCounter{path: path}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
 
-	buf, err := p.GetEvent(ctx, path)
-	if err != nil {
-		return "", err
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			samples, err := counter.count()
+			if err != nil {
+				p.logger.Warn("failed to count samples", "path", path, "error", err)
+				return
+			}
+			progress(samples)
+		}
 	}
+}
 
-	if !p.HasPerfEvent(ctx, buf) {
-		return "", fmt.Errorf("perf.data file does not contain events")
+func joinPIDs(pids []int) string {
+	s := make([]string, len(pids))
+	for i, pid := range pids {
+		s[i] = strconv.Itoa(pid)
 	}
+	return strings.Join(s, ",")
+}
 
-	perfArgs := []string{
-		constants.ScriptSubcommand,
-		"--no-inline",
+// hasPerfEvent checks if the perf.data file contains the events to be converted.
+func (p *PerfExecuter) hasPerfEvent(ctx context.Context, path string) (bool, error) {
+	perfArgs := append(p.globalArgs(constants.ScriptSubcommand),
+		"-F", "event",
 		"-i", path,
-	}
+	)
 
-	c := exec.CommandContext(ctx, p.binPath, perfArgs...)
-	c.Stdout = &stdoutBuff
+	c := p.command(ctx, perfArgs...)
 	c.Stderr = os.Stderr
-	p.logger.Info("Executing perf script", "cmd", c.String())
-
-	if err := c.Run(); err != nil {
-		return "", err
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := c.Start(); err != nil {
+		return false, err
 	}
 
-	scriptDir := filepath.Join(workDir, "script")
-	if err := os.MkdirAll(scriptDir, 0755); err != nil {
-		return "", err
+	found := false
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, constants.CyclesEvent) || strings.Contains(line, constants.CpuClockEvent) ||
+			strings.Contains(line, constants.SchedSwitchEvent) {
+			found = true
+		}
+	}
+	// Read until EOF so that perf does not block on writing.
+	if err := scanner.Err(); err != nil {
+		io.Copy(io.Discard, stdout)
 	}
 
-	profilingFileName := filepath.Base(path)
-	scriptFileName := profilingFileName + ".script"
-	scriptFilePath := filepath.Join(scriptDir, scriptFileName)
-	f, err := os.OpenFile(scriptFilePath, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return "", err
+	if err := c.Wait(); err != nil {
+		return false, err
 	}
-	defer f.Close()
+	return found, nil
+}
 
-	_, err = f.Write(stdoutBuff.Bytes())
+// ExecScript converts the perf.data file into text and writes it to w.
+// The output of perf is not buffered, so perf waits while w blocks.
+func (p *PerfExecuter) ExecScript(ctx context.Context, path string, w io.Writer) error {
+	found, err := p.hasPerfEvent(ctx, path)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if !found {
+		return ErrNoEvents
 	}
 
-	return scriptFilePath, nil
+	perfArgs := append(p.globalArgs(constants.ScriptSubcommand),
+		"--no-inline",
+		// Print the PID to tell which container the samples belong to.
+		"-F", "+pid",
+		"-i", path,
+	)
+
+	c := p.command(ctx, perfArgs...)
+	c.Stdout = w
+	c.Stderr = os.Stderr
+	p.logger.Info("Executing perf script", "cmd", c.String())
+
+	return c.Run()
 }
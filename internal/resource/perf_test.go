@@ -1,16 +1,91 @@
 package resource
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+	"github.com/stretchr/testify/assert"
 )
 
+func TestRecordOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		opts RecordOptions
+		args []string
+		err  string
+	}{
+		"default": {
+			opts: DefaultRecordOptions(),
+			args: []string{"-a", "-F", "99", "--call-graph", "dwarf,8192"},
+		},
+		"framePointer": {
+			opts: RecordOptions{Frequency: 49, CallGraph: "fp"},
+			args: []string{"-F", "49", "--call-graph", "fp"},
+		},
+		"lbr": {
+			opts: RecordOptions{Frequency: 999, CallGraph: "lbr", SystemWide: true},
+			args: []string{"-a", "-F", "999", "--call-graph", "lbr"},
+		},
+		"offCPU": {
+			opts: RecordOptions{Frequency: 99, CallGraph: "fp", OffCPU: true},
+			args: []string{
+				"-e", "sched:sched_switch",
+				"-e", "sched:sched_stat_sleep",
+				"-e", "sched:sched_stat_blocked",
+				"-e", "sched:sched_stat_iowait",
+				"--call-graph", "fp",
+			},
+		},
+		"zeroFrequency": {
+			opts: RecordOptions{Frequency: 0, CallGraph: "fp"},
+			err:  "frequency must be between 1 and 999: 0",
+		},
+		"tooHighFrequency": {
+			opts: RecordOptions{Frequency: 1000, CallGraph: "fp"},
+			err:  "frequency must be between 1 and 999: 1000",
+		},
+		"notAllowedCallGraph": {
+			opts: RecordOptions{Frequency: 99, CallGraph: "dwarf;rm"},
+			err:  `call graph "dwarf;rm" is not allowed`,
+		},
+		"stackSizeWithoutDwarf": {
+			opts: RecordOptions{Frequency: 99, CallGraph: "fp", DwarfStackSize: 8192},
+			err:  "dwarf stack size can only be specified with the dwarf call graph",
+		},
+		"tooLargeStackSize": {
+			opts: RecordOptions{Frequency: 99, CallGraph: "dwarf", DwarfStackSize: 65536},
+			err:  "dwarf stack size must be between 8 and 65528: 65536",
+		},
+		"unalignedStackSize": {
+			opts: RecordOptions{Frequency: 99, CallGraph: "dwarf", DwarfStackSize: 1001},
+			err:  "dwarf stack size must be a multiple of 8: 1001",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if len(tt.err) != 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.args, tt.opts.args())
+		})
+	}
+}
+
 func TestPerfExecutor(t *testing.T) {
 	t.Parallel()
 	var timeout = 10 * time.Second
@@ -34,7 +109,7 @@ func TestPerfExecutor(t *testing.T) {
 	}
 
 	logger := slog.Default()
-	perfExecuter, err := NewPerfExecuter(logger)
+	perfExecuter, err := NewPerfExecuter(logger, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,13 +126,116 @@ func TestPerfExecutor(t *testing.T) {
 	defer cmd.Cancel()
 
 	pid := cmd.Process.Pid
-	path, err := perfExecuter.ExecRecord(ctx, os.TempDir(), pid, timeout)
+	path, err := perfExecuter.ExecRecord(ctx, os.TempDir(), []int{pid}, timeout, DefaultRecordOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = perfExecuter.ExecScript(ctx, path, io.Discard)
 	if err != nil {
 		t.Fatal(err)
 	}
+}
 
-	_, err = perfExecuter.ExecScript(ctx, path, os.TempDir())
+func newFakePerfExecuter(t *testing.T) *PerfExecuter {
+	p, err := NewPerfExecuter(slog.Default(), "testdata/fake-perf")
 	if err != nil {
 		t.Fatal(err)
 	}
+	return p
+}
+
+func TestPerfExecutorWithFakePerf(t *testing.T) {
+	t.Parallel()
+
+	pid := os.Getpid()
+	opts := DefaultRecordOptions()
+
+	t.Run("finished", func(t *testing.T) {
+		t.Parallel()
+		p := newFakePerfExecuter(t)
+		workDir := t.TempDir()
+		ctx := context.Background()
+
+		path, err := p.ExecRecord(ctx, workDir, []int{pid}, time.Second, opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "started\nfinished\n", string(data))
+
+		var buf bytes.Buffer
+		err = p.ExecScript(ctx, path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, buf.String(), "cpu-clock:pppH")
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		t.Parallel()
+		p := newFakePerfExecuter(t)
+		workDir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(500*time.Millisecond, cancel)
+
+		start := time.Now()
+		path, err := p.ExecRecord(ctx, workDir, []int{pid}, time.Minute, opts, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, time.Since(start), stopGracePeriod)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "started\ninterrupted\n", string(data))
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		t.Parallel()
+		p := newFakePerfExecuter(t)
+		workDir := t.TempDir()
+
+		// The PID exceeds the upper limit of pid_max.
+		path, err := p.ExecRecord(context.Background(), workDir, []int{pid, 1<<22 + 1}, time.Second, opts, nil)
+		assert.Error(t, err)
+		assert.Empty(t, path)
+		entries, err := os.ReadDir(filepath.Join(workDir, constants.ProfileDirName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Empty(t, entries)
+
+		err = p.ExecScript(context.Background(), filepath.Join(workDir, "non-existent"), io.Discard)
+		assert.Error(t, err)
+	})
+}
+
+func TestExecStatWithFakePerf(t *testing.T) {
+	t.Parallel()
+
+	p := newFakePerfExecuter(t)
+	events := []string{"task-clock", "context-switches", "cycles", "instructions", "cache-misses", "branches"}
+	counters, err := p.ExecStat(context.Background(), []int{os.Getpid()}, time.Second, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []StatCounter{
+		{Event: "task-clock", Value: 1001.5, Unit: "msec", Counted: true, Supported: true, RunningRatio: 1, MetricValue: 0.999, MetricUnit: "CPUs utilized"},
+		{Event: "context-switches", Value: 12, Counted: true, Supported: true, RunningRatio: 1, MetricValue: 11.982, MetricUnit: "/sec"},
+		{Event: "cycles", Value: 2000000000, Counted: true, Supported: true, RunningRatio: 1, MetricValue: 1.997, MetricUnit: "GHz"},
+		{Event: "instructions", Value: 3000000000, Counted: true, Supported: true, RunningRatio: 1, MetricValue: 1.5, MetricUnit: "insn per cycle"},
+		{Event: "cache-misses", RunningRatio: 1},
+		{Event: "branches", Supported: true},
+	}, counters)
+
+	_, err = p.ExecStat(context.Background(), []int{os.Getpid()}, time.Second, []string{"cycles", "raw:r003c"})
+	assert.EqualError(t, err, `event "raw:r003c" is not allowed`)
+
+	// The PID exceeds the upper limit of pid_max.
+	_, err = p.ExecStat(context.Background(), []int{1<<22 + 1}, time.Second, events)
+	assert.ErrorContains(t, err, "does not exist")
 }
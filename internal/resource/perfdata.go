@@ -0,0 +1,87 @@
+package resource
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	perfFileMagic = "PERFILE2"
+	// perfFileHeaderSize is the size of the fields of perf_file_header up to the data section.
+	perfFileHeaderSize = 56
+	// perfDataOffset is the offset of data.offset in perf_file_header.
+	perfDataOffset = 40
+	// perfEventHeaderSize is the size of perf_event_header.
+	perfEventHeaderSize = 8
+	// perfRecordSample is PERF_RECORD_SAMPLE.
+	perfRecordSample = 9
+)
+
+// sampleCounter counts the samples in a perf.data file while perf record is writing it.
+// It reads only the records appended since the last count.
+type sampleCounter struct {
+	path    string
+	offset  int64
+	samples int
+}
+
+// count returns the number of samples written to the file so far.
+// It returns 0 until perf writes the file header.
+func (c *sampleCounter) count() (int, error) {
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if c.offset == 0 {
+		var header [perfFileHeaderSize]byte
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if string(header[:len(perfFileMagic)]) != perfFileMagic {
+			return 0, fmt.Errorf("%s is not a perf.data file", c.path)
+		}
+		offset := int64(binary.NativeEndian.Uint64(header[perfDataOffset:]))
+		if offset == 0 {
+			return 0, nil
+		}
+		c.offset = offset
+	}
+
+	if _, err := f.Seek(c.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	var header [perfEventHeaderSize]byte
+	for {
+		// The last record may be partially written.
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break
+		}
+		typ := binary.NativeEndian.Uint32(header[0:])
+		size := int(binary.NativeEndian.Uint16(header[6:]))
+		if size < perfEventHeaderSize {
+			return c.samples, fmt.Errorf("invalid record size %d at %d in %s", size, c.offset, c.path)
+		}
+		if _, err := r.Discard(size - perfEventHeaderSize); err != nil {
+			break
+		}
+
+		if typ == perfRecordSample {
+			c.samples++
+		}
+		c.offset += int64(size)
+	}
+	return c.samples, nil
+}
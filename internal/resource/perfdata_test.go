@@ -0,0 +1,85 @@
+package resource
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// perfRecord returns a record of the type with the body of the size.
+func perfRecord(typ uint32, bodySize int) []byte {
+	record := make([]byte, perfEventHeaderSize+bodySize)
+	binary.NativeEndian.PutUint32(record[0:], typ)
+	binary.NativeEndian.PutUint16(record[6:], uint16(len(record)))
+	return record
+}
+
+func TestSampleCounter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "perf.data")
+	c := &sampleCounter{path: path}
+
+	appendFile := func(data []byte) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() int {
+		t.Helper()
+		n, err := c.count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// The file does not exist yet.
+	assert.Equal(t, 0, count())
+
+	const dataOffset = 128
+	header := make([]byte, dataOffset)
+	copy(header, perfFileMagic)
+	binary.NativeEndian.PutUint64(header[perfDataOffset:], dataOffset)
+	appendFile(header[:16])
+	assert.Equal(t, 0, count())
+	appendFile(header[16:])
+	assert.Equal(t, 0, count())
+
+	mmap := perfRecord(1, 64)
+	sample := perfRecord(perfRecordSample, 100)
+	appendFile(mmap)
+	appendFile(sample)
+	appendFile(sample[:20])
+	assert.Equal(t, 1, count())
+
+	appendFile(sample[20:])
+	appendFile(sample)
+	appendFile(perfRecord(3, 16))
+	assert.Equal(t, 3, count())
+
+	appendFile(make([]byte, perfEventHeaderSize))
+	_, err := c.count()
+	assert.Error(t, err)
+}
+
+func TestSampleCounterInvalidFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "perf.data")
+	if err := os.WriteFile(path, make([]byte, perfFileHeaderSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &sampleCounter{path: path}
+	_, err := c.count()
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+)
+
+// MaxStatDuration is the upper limit of the time for which perf stat counts the events.
+const MaxStatDuration = time.Minute
+
+// DefaultStatEvents are counted by perf stat when no event is specified.
+var DefaultStatEvents = []string{
+	"task-clock",
+	"context-switches",
+	"cpu-migrations",
+	"page-faults",
+	"cycles",
+	"instructions",
+	"branches",
+	"branch-misses",
+	"cache-references",
+	"cache-misses",
+}
+
+var allowedStatEvents = map[string]bool{
+	"task-clock":              true,
+	"cpu-clock":               true,
+	"context-switches":        true,
+	"cpu-migrations":          true,
+	"page-faults":             true,
+	"major-faults":            true,
+	"minor-faults":            true,
+	"cycles":                  true,
+	"ref-cycles":              true,
+	"instructions":            true,
+	"branches":                true,
+	"branch-misses":           true,
+	"cache-references":        true,
+	"cache-misses":            true,
+	"stalled-cycles-frontend": true,
+	"stalled-cycles-backend":  true,
+	"L1-dcache-loads":         true,
+	"L1-dcache-load-misses":   true,
+	"L1-icache-load-misses":   true,
+	"LLC-loads":               true,
+	"LLC-load-misses":         true,
+	"dTLB-loads":              true,
+	"dTLB-load-misses":        true,
+	"iTLB-loads":              true,
+	"iTLB-load-misses":        true,
+}
+
+// ValidateStatEvents checks the events against the allowlist.
+func ValidateStatEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("no event is specified")
+	}
+	for _, e := range events {
+		if !allowedStatEvents[e] {
+			return fmt.Errorf("event %q is not allowed", e)
+		}
+	}
+	return nil
+}
+
+// StatCounter is the value of an event counted by perf stat.
+type StatCounter struct {
+	Event string
+	// Value is scaled by perf when the counter is multiplexed with the others.
+	Value float64
+	// Unit is empty for counts, and e.g. "msec" for task-clock.
+	Unit string
+	// Counted is false if the event is not supported or has never been scheduled on the PMU.
+	Counted   bool
+	Supported bool
+	// RunningRatio is the ratio of the time for which the counter was running to the counted time, from 0 to 1.
+	RunningRatio float64
+	// MetricValue and MetricUnit are the metric which perf derives from the counters, such as "insn per cycle".
+	MetricValue float64
+	MetricUnit  string
+}
+
+// ExecStat counts the events of the processes for the duration with perf stat.
+// If ctx is done, perf is stopped and the values counted so far are returned.
+func (p *PerfExecuter) ExecStat(ctx context.Context, pids []int, duration time.Duration, events []string) ([]StatCounter, error) {
+	if err := ValidateStatEvents(events); err != nil {
+		return nil, err
+	}
+	if len(pids) == 0 {
+		return nil, errors.New("no PID is specified")
+	}
+
+	perfArgs := []string{
+		constants.StatSubcommand,
+		"-x", ",",
+		"-e", strings.Join(events, ","),
+		"-p", joinPIDs(pids),
+		"--", "sleep", strconv.Itoa(int(duration.Seconds())),
+	}
+	c := p.command(ctx, perfArgs...)
+	// perf stat writes the counters to stderr.
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	p.logger.Info("Executing perf stat", "cmd", c.String())
+
+	err := c.Run()
+	if err != nil && !(ctx.Err() != nil && interrupted(err)) {
+		return nil, fmt.Errorf("perf stat failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseStat(&stderr)
+}
+
+// parseStat parses the CSV output of perf stat -x ,
+// Each line is "value,unit,event,running time,running percentage,metric value,metric unit".
+// The other lines such as warnings are skipped.
+func parseStat(r io.Reader) ([]StatCounter, error) {
+	var counters []StatCounter
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 5 || len(fields[2]) == 0 {
+			continue
+		}
+
+		c := StatCounter{
+			Event:     fields[2],
+			Unit:      fields[1],
+			Counted:   true,
+			Supported: true,
+		}
+		switch fields[0] {
+		case "<not supported>":
+			c.Counted = false
+			c.Supported = false
+		case "<not counted>":
+			c.Counted = false
+		default:
+			v, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				continue
+			}
+			c.Value = v
+		}
+		if pct, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			c.RunningRatio = pct / 100
+		}
+		if len(fields) >= 7 {
+			if v, err := strconv.ParseFloat(fields[5], 64); err == nil {
+				c.MetricValue = v
+				c.MetricUnit = strings.TrimSpace(fields[6])
+			}
+		}
+		counters = append(counters, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(counters) == 0 {
+		return nil, errors.New("no counter is found in the output of perf stat")
+	}
+	return counters, nil
+}
@@ -0,0 +1,446 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/necoperf/internal/constants"
+)
+
+// debuginfodTimeout is the timeout to download a debug file from the debuginfod server.
+const debuginfodTimeout = 5 * time.Minute
+
+// maxDebugInfoSize is the maximum size of a debug file downloaded from the debuginfod server.
+const maxDebugInfoSize = 2 << 30
+
+// ntGNUBuildID is the type of the ELF note containing the build ID.
+const ntGNUBuildID = 3
+
+var buildIDPattern = regexp.MustCompile(`^[0-9a-f]{2,}$`)
+
+// SymbolStore is the store of the debug files of the stripped binaries, looked up by build ID.
+type SymbolStore struct {
+	// Dir is a local directory laid out like debuginfod, in which the debug file is at buildid/<build ID>/debuginfo.
+	Dir string
+	// URL is the base URL of a debuginfod server.
+	URL string
+}
+
+// Validate checks that URL is an HTTP(S) URL.
+func (s SymbolStore) Validate() error {
+	if len(s.URL) == 0 {
+		return nil
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("debuginfod URL must be http or https: %s", s.URL)
+	}
+	return nil
+}
+
+// dso is a binary which perf recorded the samples in.
+type dso struct {
+	buildID string
+	path    string
+}
+
+// EnableSymbolization makes Symbolize add the binaries recorded in perf.data to the build-ID cache in buildIDDir,
+// from which perf looks up the symbols. The debug files in store are preferred to the binaries themselves.
+func (p *PerfExecuter) EnableSymbolization(buildIDDir string, store SymbolStore) error {
+	if err := store.Validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(buildIDDir, 0755); err != nil {
+		return err
+	}
+	p.buildIDDir = buildIDDir
+	p.store = store
+	p.httpClient = &http.Client{Timeout: debuginfodTimeout}
+	return nil
+}
+
+// globalArgs returns the options of perf given before the subcommand.
+func (p *PerfExecuter) globalArgs(subcommand string) []string {
+	if len(p.buildIDDir) == 0 {
+		return []string{subcommand}
+	}
+	return []string{"--buildid-dir", p.buildIDDir, subcommand}
+}
+
+// Symbolize adds the binaries which have samples in the perf.data file to the build-ID cache, so that
+// ExecScript can resolve their symbols even if they are stripped or their processes have exited.
+// For each build ID, the debug file is looked up in the local directory and then in the debuginfod server.
+// If it is not found, the binary is read through /proc/<pid>/root of the profiled processes, since
+// the path recorded by perf is the one in the mount namespace of the container.
+// The binaries which are not found are left to perf.
+// The sessions can symbolize concurrently, and a binary needed by several of them is looked up once.
+func (p *PerfExecuter) Symbolize(ctx context.Context, path string, pids []int) error {
+	if len(p.buildIDDir) == 0 {
+		return nil
+	}
+
+	dsos, err := p.listBuildIDs(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dsos {
+		// The other sessions wait for the one looking up the same build ID, which may take long to download it.
+		// The lookup is not canceled with the session which started it, since the others share its result.
+		ch := p.addGroup.DoChan(d.buildID, func() (any, error) {
+			if p.cached(d.buildID) {
+				return nil, nil
+			}
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), debuginfodTimeout)
+			defer cancel()
+			return nil, p.addDSO(ctx, d, pids)
+		})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case res := <-ch:
+			if res.Err != nil {
+				p.logger.Warn("failed to add the binary to the build-ID cache", "buildID", d.buildID, "path", d.path, "error", res.Err)
+			}
+		}
+	}
+	return nil
+}
+
+// listBuildIDs returns the binaries with samples in the perf.data file.
+// The kernel and the other pseudo binaries such as [vdso] are excluded.
+func (p *PerfExecuter) listBuildIDs(ctx context.Context, path string) ([]dso, error) {
+	c := p.command(ctx, append(p.globalArgs(constants.BuildIDListSubcommand), "--with-hits", "-i", path)...)
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseBuildIDList(bytes.NewReader(out))
+}
+
+func parseBuildIDList(r io.Reader) ([]dso, error) {
+	var dsos []dso
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		buildID, path, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok || strings.HasPrefix(path, "[") || !filepath.IsAbs(path) {
+			continue
+		}
+		// The build ID is used as a path, so anything other than hex digits is rejected.
+		if !buildIDPattern.MatchString(buildID) {
+			return nil, fmt.Errorf("invalid build ID %q of %s", buildID, path)
+		}
+		dsos = append(dsos, dso{buildID: buildID, path: path})
+	}
+	return dsos, scanner.Err()
+}
+
+// cached reports whether the build ID has been added to the cache.
+// The layout is the one of perf, which links .build-id/<first 2 digits>/<rest> to the cached file.
+// The modification time of the cached file is updated to the last use, by which SweepSymbols removes it.
+func (p *PerfExecuter) cached(buildID string) bool {
+	link := filepath.Join(p.buildIDDir, ".build-id", buildID[:2], buildID[2:])
+	if _, err := os.Stat(link); err != nil {
+		return false
+	}
+	now := time.Now()
+	if err := os.Chtimes(link, now, now); err != nil {
+		p.logger.Warn("failed to update the time of the cached binary", "buildID", buildID, "error", err)
+	}
+	return true
+}
+
+// SweepSymbols removes the binaries which have not been used by Symbolize for maxAge from the build-ID cache.
+// Nothing is removed if maxAge is not positive, except the debug files left by the interrupted downloads.
+func (p *PerfExecuter) SweepSymbols(now time.Time, maxAge time.Duration) error {
+	if len(p.buildIDDir) == 0 {
+		return nil
+	}
+	if err := p.removeDownloads(now); err != nil {
+		return err
+	}
+	if maxAge <= 0 {
+		return nil
+	}
+
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	root, err := filepath.EvalSymlinks(p.buildIDDir)
+	if err != nil {
+		return err
+	}
+	links, err := filepath.Glob(filepath.Join(p.buildIDDir, ".build-id", "*", "*"))
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		fi, err := os.Stat(link)
+		switch {
+		case os.IsNotExist(err):
+			// The cached file has been removed, so the link is broken.
+		case err != nil:
+			return err
+		case now.Sub(fi.ModTime()) < maxAge:
+			continue
+		default:
+			if err := p.removeCached(root, link); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		buildID := filepath.Base(filepath.Dir(link)) + filepath.Base(link)
+		p.logger.Info("binary is removed from the build-ID cache", "buildID", buildID)
+	}
+	return nil
+}
+
+// removeDownloads removes the debug files downloaded by fetchDebugInfo and left in buildIDDir.
+// A lookup does not take longer than debuginfodTimeout, so the older files are not used anymore.
+func (p *PerfExecuter) removeDownloads(now time.Time) error {
+	paths, err := filepath.Glob(filepath.Join(p.buildIDDir, "debuginfo-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if now.Sub(fi.ModTime()) < debuginfodTimeout {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		p.logger.Info("downloaded debug file is removed", "path", path)
+	}
+	return nil
+}
+
+// removeCached removes the cached file or directory which the link points to, and its parents left empty.
+func (p *PerfExecuter) removeCached(root, link string) error {
+	fi, err := os.Lstat(link)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return nil
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		// perf links to the files in the cache only, so the others are not removed.
+		p.logger.Warn("build-ID cache links outside of the cache", "link", link, "target", target)
+		return nil
+	}
+
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(target); dir != root; dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			// The directory has the files of the other build IDs.
+			break
+		}
+	}
+	return nil
+}
+
+func (p *PerfExecuter) addDSO(ctx context.Context, d dso, pids []int) error {
+	if len(p.store.Dir) != 0 {
+		path := filepath.Join(p.store.Dir, "buildid", d.buildID, "debuginfo")
+		if _, err := os.Stat(path); err == nil {
+			return p.addBuildIDCache(ctx, path)
+		}
+	}
+
+	if len(p.store.URL) != 0 {
+		path, err := p.fetchDebugInfo(ctx, d.buildID)
+		if err != nil {
+			return err
+		}
+		if len(path) != 0 {
+			defer os.Remove(path)
+			return p.addBuildIDCache(ctx, path)
+		}
+	}
+
+	for _, pid := range pids {
+		path := filepath.Join("/proc", strconv.Itoa(pid), "root", d.path)
+		buildID, err := readBuildID(path)
+		if err != nil {
+			// The binary may not exist in the container of this process.
+			continue
+		}
+		if buildID == d.buildID {
+			return p.addBuildIDCache(ctx, path)
+		}
+	}
+
+	p.logger.Info("binary to resolve the symbols is not found", "buildID", d.buildID, "path", d.path)
+	return nil
+}
+
+// fetchDebugInfo downloads the debug file from the debuginfod server and returns its path in buildIDDir.
+// The file is downloaded to a temporary file, and renamed after its build ID is checked to be the requested one.
+// It returns an empty path if the server does not have the debug file.
+func (p *PerfExecuter) fetchDebugInfo(ctx context.Context, buildID string) (string, error) {
+	u := strings.TrimSuffix(p.store.URL, "/") + "/buildid/" + buildID + "/debuginfo"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("debuginfod server returned %s for %s", resp.Status, buildID)
+	}
+
+	f, err := os.CreateTemp(p.buildIDDir, "debuginfo-"+buildID+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := f.Name()
+	if err := saveDebugInfo(f, resp.Body, buildID); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	path := filepath.Join(p.buildIDDir, "debuginfo-"+buildID)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return path, nil
+}
+
+// saveDebugInfo writes the debug file read from r to f and closes f.
+// It fails if the file is too large or is not of the binary with buildID.
+func saveDebugInfo(f *os.File, r io.Reader, buildID string) error {
+	n, err := io.Copy(f, io.LimitReader(r, maxDebugInfoSize+1))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if n > maxDebugInfoSize {
+		return fmt.Errorf("debug file of %s is larger than %d bytes", buildID, maxDebugInfoSize)
+	}
+
+	// The server may return the debug file of another binary, which would give wrong symbols.
+	actual, err := readBuildID(f.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read the build ID of the debug file of %s: %w", buildID, err)
+	}
+	if actual != buildID {
+		return fmt.Errorf("build ID of the debug file of %s is %s", buildID, actual)
+	}
+	return nil
+}
+
+// addBuildIDCache copies the file into the build-ID cache by perf buildid-cache.
+func (p *PerfExecuter) addBuildIDCache(ctx context.Context, path string) error {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	c := p.command(ctx, append(p.globalArgs(constants.BuildIDCacheSubcommand), "--add", path)...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	p.logger.Info("Executing perf buildid-cache", "cmd", c.String())
+	return c.Run()
+}
+
+// readBuildID returns the GNU build ID of the ELF file in hex.
+func readBuildID(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+		if id, ok := findBuildIDNote(data, f.ByteOrder); ok {
+			return id, nil
+		}
+	}
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return "", err
+		}
+		if id, ok := findBuildIDNote(data, f.ByteOrder); ok {
+			return id, nil
+		}
+	}
+	return "", errors.New("build ID is not found")
+}
+
+// findBuildIDNote finds the build ID in the ELF notes.
+// Each note consists of the sizes of the name and the descriptor, the type, and them padded to 4 bytes.
+func findBuildIDNote(data []byte, order binary.ByteOrder) (string, bool) {
+	align := func(n uint32) int { return (int(n) + 3) &^ 3 }
+	for len(data) >= 12 {
+		nameSize := order.Uint32(data[0:4])
+		descSize := order.Uint32(data[4:8])
+		typ := order.Uint32(data[8:12])
+		data = data[12:]
+		if align(nameSize)+align(descSize) > len(data) {
+			return "", false
+		}
+		name := data[:nameSize]
+		desc := data[align(nameSize) : align(nameSize)+int(descSize)]
+		data = data[align(nameSize)+align(descSize):]
+		if typ == ntGNUBuildID && string(name) == "GNU\x00" {
+			return hex.EncodeToString(desc), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,383 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuildIDList(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input string
+		dsos  []dso
+		err   string
+	}{
+		"binaries": {
+			input: "0a1b2c3d4e5f /usr/bin/app\n" +
+				"11223344 [kernel.kallsyms]\n" +
+				"55667788 [vdso]\n" +
+				"99aabbcc /usr/lib/x86_64-linux-gnu/libc.so.6\n" +
+				"ddeeff00 app\n",
+			dsos: []dso{
+				{buildID: "0a1b2c3d4e5f", path: "/usr/bin/app"},
+				{buildID: "99aabbcc", path: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+			},
+		},
+		"invalidBuildID": {
+			input: "../../etc /usr/bin/app\n",
+			err:   `invalid build ID "../../etc" of /usr/bin/app`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dsos, err := parseBuildIDList(strings.NewReader(tt.input))
+			if len(tt.err) != 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.dsos, dsos)
+		})
+	}
+}
+
+func TestSymbolStore(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, SymbolStore{}.Validate())
+	assert.NoError(t, SymbolStore{URL: "https://debuginfod.example.com"}.Validate())
+	assert.EqualError(t, SymbolStore{URL: "file:///debuginfo"}.Validate(), "debuginfod URL must be http or https: file:///debuginfo")
+}
+
+func TestSymbolizeWithFakePerf(t *testing.T) {
+	t.Parallel()
+
+	// The ELF binaries with different build IDs are needed to be found in the processes and downloaded.
+	const (
+		shell  = "/bin/sh"
+		remote = "/bin/ls"
+	)
+	shellBuildID, err := readBuildID(shell)
+	if err != nil {
+		t.Skipf("Skip test because the build ID of %s cannot be read: %v", shell, err)
+	}
+	remoteID, err := readBuildID(remote)
+	if err != nil || remoteID == shellBuildID {
+		t.Skipf("Skip test because the build ID of %s cannot be read or is the same as %s: %v", remote, shell, err)
+	}
+	remoteData, err := os.ReadFile(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		localID    = "1111111111111111111111111111111111111111"
+		wrongID    = "2222222222222222222222222222222222222222"
+		cachedID   = "3333333333333333333333333333333333333333"
+		missingID  = "4444444444444444444444444444444444444444"
+		mismatchID = "5555555555555555555555555555555555555555"
+	)
+
+	storeDir := t.TempDir()
+	for _, id := range []string{localID, cachedID} {
+		dir := filepath.Join(storeDir, "buildid", id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "debuginfo"), []byte("debuginfo of "+id+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The server returns the binary of another build ID for wrongID, which is rejected.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/buildid/"+remoteID+"/debuginfo" && r.URL.Path != "/buildid/"+wrongID+"/debuginfo" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(remoteData)
+	}))
+	defer server.Close()
+
+	p := newFakePerfExecuter(t)
+	buildIDDir := filepath.Join(t.TempDir(), "buildid")
+	err = p.EnableSymbolization(buildIDDir, SymbolStore{Dir: storeDir, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedLink := filepath.Join(buildIDDir, ".build-id", cachedID[:2], cachedID[2:])
+	if err := os.MkdirAll(filepath.Dir(cachedLink), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachedLink, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake perf buildid-list prints the content of the input file.
+	dataPath := filepath.Join(t.TempDir(), "perf.data")
+	list := []string{
+		localID + " /usr/bin/local",
+		remoteID + " /usr/bin/remote",
+		wrongID + " /usr/bin/wrong",
+		cachedID + " /usr/bin/cached",
+		missingID + " /usr/bin/missing",
+		mismatchID + " " + shell,
+		shellBuildID + " " + shell,
+		"abcdef [kernel.kallsyms]",
+	}
+	if err := os.WriteFile(dataPath, []byte(strings.Join(list, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := os.Getpid()
+	if err := p.Symbolize(context.Background(), dataPath, []int{pid}); err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := os.ReadFile(filepath.Join(buildIDDir, "added"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{
+		"debuginfo of " + localID,
+		"ELF " + filepath.Join(buildIDDir, "debuginfo-"+remoteID),
+		fmt.Sprintf("ELF /proc/%d/root%s", pid, shell),
+	}, strings.Split(strings.TrimSpace(string(added)), "\n"))
+
+	// The downloaded debug file is removed after it is added.
+	matches, err := filepath.Glob(filepath.Join(buildIDDir, "debuginfo-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, matches)
+}
+
+func TestSymbolizeDisabled(t *testing.T) {
+	t.Parallel()
+
+	p := newFakePerfExecuter(t)
+	// Symbolize does not run perf, which fails on the non-existent file.
+	assert.NoError(t, p.Symbolize(context.Background(), filepath.Join(t.TempDir(), "non-existent"), nil))
+}
+
+func TestSymbolizeDuringDownload(t *testing.T) {
+	t.Parallel()
+
+	const (
+		localID  = "1111111111111111111111111111111111111111"
+		remoteID = "2222222222222222222222222222222222222222"
+	)
+
+	storeDir := t.TempDir()
+	dir := filepath.Join(storeDir, "buildid", localID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "debuginfo"), []byte("debuginfo of "+localID+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	downloading := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(downloading)
+		<-release
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	p := newFakePerfExecuter(t)
+	buildIDDir := filepath.Join(t.TempDir(), "buildid")
+	if err := p.EnableSymbolization(buildIDDir, SymbolStore{Dir: storeDir, URL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeList := func(line string) string {
+		path := filepath.Join(t.TempDir(), "perf.data")
+		if err := os.WriteFile(path, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	remoteData := writeList(remoteID + " /usr/bin/remote")
+	localData := writeList(localID + " /usr/bin/local")
+
+	done := make(chan error)
+	go func() {
+		done <- p.Symbolize(context.Background(), remoteData, nil)
+	}()
+	<-downloading
+
+	// The other session is not blocked by the download.
+	if err := p.Symbolize(context.Background(), localData, nil); err != nil {
+		t.Fatal(err)
+	}
+	added, err := os.ReadFile(filepath.Join(buildIDDir, "added"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "debuginfo of "+localID+"\n", string(added))
+
+	close(release)
+	assert.NoError(t, <-done)
+}
+
+func TestSymbolizeCanceled(t *testing.T) {
+	t.Parallel()
+
+	const remoteID = "2222222222222222222222222222222222222222"
+
+	downloading := make(chan struct{})
+	release := make(chan struct{})
+	served := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(downloading)
+		<-release
+		served <- r.Context().Err()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	p := newFakePerfExecuter(t)
+	buildIDDir := filepath.Join(t.TempDir(), "buildid")
+	if err := p.EnableSymbolization(buildIDDir, SymbolStore{URL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "perf.data")
+	if err := os.WriteFile(path, []byte(remoteID+" /usr/bin/remote\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Symbolize(ctx, path, nil)
+	}()
+	<-downloading
+
+	// The session returns at once, but the download shared with the other sessions goes on.
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	close(release)
+	assert.NoError(t, <-served)
+}
+
+func TestSweepSymbols(t *testing.T) {
+	t.Parallel()
+
+	const (
+		oldID     = "1111111111111111111111111111111111111111"
+		newID     = "2222222222222222222222222222222222222222"
+		brokenID  = "3333333333333333333333333333333333333333"
+		outsideID = "4444444444444444444444444444444444444444"
+	)
+
+	p := newFakePerfExecuter(t)
+	buildIDDir := filepath.Join(t.TempDir(), "buildid")
+	if err := p.EnableSymbolization(buildIDDir, SymbolStore{}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	addCached := func(buildID, target string, mtime time.Time) {
+		if err := os.MkdirAll(filepath.Join(buildIDDir, target), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(buildIDDir, target, "elf"), []byte(buildID), 0644); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(buildIDDir, ".build-id", buildID[:2], buildID[2:])
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..", "..", target), link); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(link, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addCached(oldID, "usr/bin/old/"+oldID, old)
+	addCached(newID, "usr/bin/new/"+newID, now)
+	addCached(brokenID, "usr/bin/broken/"+brokenID, now)
+	if err := os.RemoveAll(filepath.Join(buildIDDir, "usr/bin/broken")); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Chtimes(outside, old, old); err != nil {
+		t.Fatal(err)
+	}
+	outsideLink := filepath.Join(buildIDDir, ".build-id", outsideID[:2], outsideID[2:])
+	if err := os.MkdirAll(filepath.Dir(outsideLink), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, outsideLink); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SweepSymbols(now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := filepath.Glob(filepath.Join(buildIDDir, ".build-id", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{filepath.Join(buildIDDir, ".build-id", newID[:2], newID[2:])}, links)
+	assert.FileExists(t, filepath.Join(buildIDDir, "usr/bin/new", newID, "elf"))
+	// The directory left empty by the removal is also removed.
+	assert.NoDirExists(t, filepath.Join(buildIDDir, "usr/bin/old"))
+	assert.DirExists(t, outside)
+
+	// The binaries are kept forever if maxAge is 0.
+	if err := p.SweepSymbols(now.Add(time.Hour), 0); err != nil {
+		t.Fatal(err)
+	}
+	assert.FileExists(t, filepath.Join(buildIDDir, "usr/bin/new", newID, "elf"))
+}
+
+func TestSweepDownloads(t *testing.T) {
+	t.Parallel()
+
+	p := newFakePerfExecuter(t)
+	buildIDDir := filepath.Join(t.TempDir(), "buildid")
+	if err := p.EnableSymbolization(buildIDDir, SymbolStore{}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	addFile := func(name string, mtime time.Time) string {
+		path := filepath.Join(buildIDDir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	interrupted := addFile("debuginfo-1111111111111111111111111111111111111111-123.tmp", now.Add(-debuginfodTimeout))
+	downloading := addFile("debuginfo-2222222222222222222222222222222222222222-456.tmp", now)
+	leftover := addFile("debuginfo-3333333333333333333333333333333333333333", now.Add(-debuginfodTimeout))
+
+	// The downloads are swept even if the binaries are kept forever.
+	if err := p.SweepSymbols(now, 0); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoFileExists(t, interrupted)
+	assert.FileExists(t, downloading)
+	assert.NoFileExists(t, leftover)
+}
@@ -10,6 +10,7 @@ import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -22,10 +23,248 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type ProfileType int32
+
+const (
+	// Samples of the stacks running on CPU at the frequency of record_options.
+	ProfileType_PROFILE_TYPE_CPU ProfileType = 0
+	// Time for which the threads are blocked, by the stacks on which they go off CPU.
+	// The scheduler tracepoints are recorded instead of sampling, so the frequency of record_options is ignored.
+	// In the folded format, the value of each stack is the blocked time in nanoseconds.
+	ProfileType_PROFILE_TYPE_OFF_CPU ProfileType = 1
+)
+
+// Enum value maps for ProfileType.
+var (
+	ProfileType_name = map[int32]string{
+		0: "PROFILE_TYPE_CPU",
+		1: "PROFILE_TYPE_OFF_CPU",
+	}
+	ProfileType_value = map[string]int32{
+		"PROFILE_TYPE_CPU":     0,
+		"PROFILE_TYPE_OFF_CPU": 1,
+	}
+)
+
+func (x ProfileType) Enum() *ProfileType {
+	p := new(ProfileType)
+	*p = x
+	return p
+}
+
+func (x ProfileType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ProfileType) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_rpc_necoperf_proto_enumTypes[0].Descriptor()
+}
+
+func (ProfileType) Type() protoreflect.EnumType {
+	return &file_internal_rpc_necoperf_proto_enumTypes[0]
+}
+
+func (x ProfileType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ProfileType.Descriptor instead.
+func (ProfileType) EnumDescriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{0}
+}
+
+type Compression int32
+
+const (
+	Compression_COMPRESSION_NONE Compression = 0
+	Compression_COMPRESSION_GZIP Compression = 1
+	Compression_COMPRESSION_ZSTD Compression = 2
+)
+
+// Enum value maps for Compression.
+var (
+	Compression_name = map[int32]string{
+		0: "COMPRESSION_NONE",
+		1: "COMPRESSION_GZIP",
+		2: "COMPRESSION_ZSTD",
+	}
+	Compression_value = map[string]int32{
+		"COMPRESSION_NONE": 0,
+		"COMPRESSION_GZIP": 1,
+		"COMPRESSION_ZSTD": 2,
+	}
+)
+
+func (x Compression) Enum() *Compression {
+	p := new(Compression)
+	*p = x
+	return p
+}
+
+func (x Compression) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compression) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_rpc_necoperf_proto_enumTypes[1].Descriptor()
+}
+
+func (Compression) Type() protoreflect.EnumType {
+	return &file_internal_rpc_necoperf_proto_enumTypes[1]
+}
+
+func (x Compression) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compression.Descriptor instead.
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{1}
+}
+
+type OutputFormat int32
+
+const (
+	// Text output of perf script.
+	OutputFormat_OUTPUT_FORMAT_SCRIPT OutputFormat = 0
+	// Gzipped profile.proto which can be read by pprof.
+	OutputFormat_OUTPUT_FORMAT_PPROF OutputFormat = 1
+	// Folded stacks compatible with the output of stackcollapse-perf.pl.
+	OutputFormat_OUTPUT_FORMAT_FOLDED OutputFormat = 2
+	// Tar archive of perf.data and the build-ID cache of the binaries with samples under .debug,
+	// with which perf report can be run on another machine by --buildid-dir.
+	OutputFormat_OUTPUT_FORMAT_RAW OutputFormat = 3
+)
+
+// Enum value maps for OutputFormat.
+var (
+	OutputFormat_name = map[int32]string{
+		0: "OUTPUT_FORMAT_SCRIPT",
+		1: "OUTPUT_FORMAT_PPROF",
+		2: "OUTPUT_FORMAT_FOLDED",
+		3: "OUTPUT_FORMAT_RAW",
+	}
+	OutputFormat_value = map[string]int32{
+		"OUTPUT_FORMAT_SCRIPT": 0,
+		"OUTPUT_FORMAT_PPROF":  1,
+		"OUTPUT_FORMAT_FOLDED": 2,
+		"OUTPUT_FORMAT_RAW":    3,
+	}
+)
+
+func (x OutputFormat) Enum() *OutputFormat {
+	p := new(OutputFormat)
+	*p = x
+	return p
+}
+
+func (x OutputFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (OutputFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_rpc_necoperf_proto_enumTypes[2].Descriptor()
+}
+
+func (OutputFormat) Type() protoreflect.EnumType {
+	return &file_internal_rpc_necoperf_proto_enumTypes[2]
+}
+
+func (x OutputFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use OutputFormat.Descriptor instead.
+func (OutputFormat) EnumDescriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{2}
+}
+
+type ProfilePhase int32
+
+const (
+	ProfilePhase_PROFILE_PHASE_UNSPECIFIED ProfilePhase = 0
+	// Waiting for other profiling to finish.
+	ProfilePhase_PROFILE_PHASE_PENDING    ProfilePhase = 1
+	ProfilePhase_PROFILE_PHASE_RECORDING  ProfilePhase = 2
+	ProfilePhase_PROFILE_PHASE_CONVERTING ProfilePhase = 3
+	ProfilePhase_PROFILE_PHASE_SUCCEEDED  ProfilePhase = 4
+	ProfilePhase_PROFILE_PHASE_FAILED     ProfilePhase = 5
+	ProfilePhase_PROFILE_PHASE_CANCELLED  ProfilePhase = 6
+)
+
+// Enum value maps for ProfilePhase.
+var (
+	ProfilePhase_name = map[int32]string{
+		0: "PROFILE_PHASE_UNSPECIFIED",
+		1: "PROFILE_PHASE_PENDING",
+		2: "PROFILE_PHASE_RECORDING",
+		3: "PROFILE_PHASE_CONVERTING",
+		4: "PROFILE_PHASE_SUCCEEDED",
+		5: "PROFILE_PHASE_FAILED",
+		6: "PROFILE_PHASE_CANCELLED",
+	}
+	ProfilePhase_value = map[string]int32{
+		"PROFILE_PHASE_UNSPECIFIED": 0,
+		"PROFILE_PHASE_PENDING":     1,
+		"PROFILE_PHASE_RECORDING":   2,
+		"PROFILE_PHASE_CONVERTING":  3,
+		"PROFILE_PHASE_SUCCEEDED":   4,
+		"PROFILE_PHASE_FAILED":      5,
+		"PROFILE_PHASE_CANCELLED":   6,
+	}
+)
+
+func (x ProfilePhase) Enum() *ProfilePhase {
+	p := new(ProfilePhase)
+	*p = x
+	return p
+}
+
+func (x ProfilePhase) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ProfilePhase) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_rpc_necoperf_proto_enumTypes[3].Descriptor()
+}
+
+func (ProfilePhase) Type() protoreflect.EnumType {
+	return &file_internal_rpc_necoperf_proto_enumTypes[3]
+}
+
+func (x ProfilePhase) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ProfilePhase.Descriptor instead.
+func (ProfilePhase) EnumDescriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{3}
+}
+
 type PerfProfileRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ContainerId   string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
-	Timeout       *durationpb.Duration   `protobuf:"bytes,2,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Timeout     *durationpb.Duration   `protobuf:"bytes,2,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	// Options passed to perf record. The default options are used when not set.
+	RecordOptions *PerfRecordOptions `protobuf:"bytes,3,opt,name=record_options,json=recordOptions,proto3" json:"record_options,omitempty"`
+	// Format of the profiling result.
+	OutputFormat OutputFormat `protobuf:"varint,4,opt,name=output_format,json=outputFormat,proto3,enum=necoperf.OutputFormat" json:"output_format,omitempty"`
+	// Maximum size of data in each response. 64 KiB is used when zero.
+	// It must be between 1 KiB and 1 MiB.
+	ChunkSize uint32 `protobuf:"varint,5,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	// Compression of the data sent in the responses.
+	Compression Compression `protobuf:"varint,6,opt,name=compression,proto3,enum=necoperf.Compression" json:"compression,omitempty"`
+	// IDs of the containers to be profiled together with container_id in one perf record session.
+	// When more than one container is profiled, the samples are tagged with the container names.
+	ContainerIds []string `protobuf:"bytes,7,rep,name=container_ids,json=containerIds,proto3" json:"container_ids,omitempty"`
+	// Containers specified by their names instead of their IDs. They are resolved by necoperf-daemon
+	// with the labels set by kubelet in the container runtime, so they must be running on the same node.
+	ContainerRefs []*ContainerRef `protobuf:"bytes,8,rep,name=container_refs,json=containerRefs,proto3" json:"container_refs,omitempty"`
+	// Type of the profile.
+	ProfileType ProfileType `protobuf:"varint,9,opt,name=profile_type,json=profileType,proto3,enum=necoperf.ProfileType" json:"profile_type,omitempty"`
+	// If true, all the processes in the cgroups of the containers are profiled instead of only their init processes.
+	// The processes are listed when perf record starts, and the processes forked by them later are also profiled.
+	Cgroup        bool `protobuf:"varint,10,opt,name=cgroup,proto3" json:"cgroup,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -74,16 +313,213 @@ func (x *PerfProfileRequest) GetTimeout() *durationpb.Duration {
 	return nil
 }
 
-type PerfProfileResponse struct {
+func (x *PerfProfileRequest) GetRecordOptions() *PerfRecordOptions {
+	if x != nil {
+		return x.RecordOptions
+	}
+	return nil
+}
+
+func (x *PerfProfileRequest) GetOutputFormat() OutputFormat {
+	if x != nil {
+		return x.OutputFormat
+	}
+	return OutputFormat_OUTPUT_FORMAT_SCRIPT
+}
+
+func (x *PerfProfileRequest) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *PerfProfileRequest) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_COMPRESSION_NONE
+}
+
+func (x *PerfProfileRequest) GetContainerIds() []string {
+	if x != nil {
+		return x.ContainerIds
+	}
+	return nil
+}
+
+func (x *PerfProfileRequest) GetContainerRefs() []*ContainerRef {
+	if x != nil {
+		return x.ContainerRefs
+	}
+	return nil
+}
+
+func (x *PerfProfileRequest) GetProfileType() ProfileType {
+	if x != nil {
+		return x.ProfileType
+	}
+	return ProfileType_PROFILE_TYPE_CPU
+}
+
+func (x *PerfProfileRequest) GetCgroup() bool {
+	if x != nil {
+		return x.Cgroup
+	}
+	return false
+}
+
+type ContainerRef struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Namespace     string                 `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	PodName       string                 `protobuf:"bytes,2,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	ContainerName string                 `protobuf:"bytes,3,opt,name=container_name,json=containerName,proto3" json:"container_name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ContainerRef) Reset() {
+	*x = ContainerRef{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ContainerRef) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContainerRef) ProtoMessage() {}
+
+func (x *ContainerRef) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContainerRef.ProtoReflect.Descriptor instead.
+func (*ContainerRef) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ContainerRef) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ContainerRef) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *ContainerRef) GetContainerName() string {
+	if x != nil {
+		return x.ContainerName
+	}
+	return ""
+}
+
+type PerfRecordOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sampling frequency in Hz. 99 is used when zero.
+	Frequency uint32 `protobuf:"varint,1,opt,name=frequency,proto3" json:"frequency,omitempty"`
+	// Call graph recording method; one of "fp", "dwarf" or "lbr". "dwarf" is used when empty.
+	CallGraph string `protobuf:"bytes,2,opt,name=call_graph,json=callGraph,proto3" json:"call_graph,omitempty"`
+	// Stack dump size in bytes for the dwarf call graph. 8192 is used when zero.
+	DwarfStackSize uint32 `protobuf:"varint,3,opt,name=dwarf_stack_size,json=dwarfStackSize,proto3" json:"dwarf_stack_size,omitempty"`
+	// Whether to collect samples from all CPUs. Enabled when not set.
+	SystemWide    *bool `protobuf:"varint,4,opt,name=system_wide,json=systemWide,proto3,oneof" json:"system_wide,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PerfRecordOptions) Reset() {
+	*x = PerfRecordOptions{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PerfRecordOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PerfRecordOptions) ProtoMessage() {}
+
+func (x *PerfRecordOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PerfRecordOptions.ProtoReflect.Descriptor instead.
+func (*PerfRecordOptions) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PerfRecordOptions) GetFrequency() uint32 {
+	if x != nil {
+		return x.Frequency
+	}
+	return 0
+}
+
+func (x *PerfRecordOptions) GetCallGraph() string {
+	if x != nil {
+		return x.CallGraph
+	}
+	return ""
+}
+
+func (x *PerfRecordOptions) GetDwarfStackSize() uint32 {
+	if x != nil {
+		return x.DwarfStackSize
+	}
+	return 0
+}
+
+func (x *PerfRecordOptions) GetSystemWide() bool {
+	if x != nil && x.SystemWide != nil {
+		return *x.SystemWide
+	}
+	return false
+}
+
+type PerfProfileResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Profile sends the progress events before the chunks of the result.
+	// FetchProfile sends only the chunks.
+	//
+	// Types that are valid to be assigned to Event:
+	//
+	//	*PerfProfileResponse_Data
+	//	*PerfProfileResponse_Queued
+	//	*PerfProfileResponse_Recording
+	//	*PerfProfileResponse_Converting
+	Event         isPerfProfileResponse_Event `protobuf_oneof:"event"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PerfProfileResponse) Reset() {
 	*x = PerfProfileResponse{}
-	mi := &file_internal_rpc_necoperf_proto_msgTypes[1]
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -95,7 +531,7 @@ func (x *PerfProfileResponse) String() string {
 func (*PerfProfileResponse) ProtoMessage() {}
 
 func (x *PerfProfileResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_rpc_necoperf_proto_msgTypes[1]
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -108,75 +544,1038 @@ func (x *PerfProfileResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PerfProfileResponse.ProtoReflect.Descriptor instead.
 func (*PerfProfileResponse) Descriptor() ([]byte, []int) {
-	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{1}
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PerfProfileResponse) GetEvent() isPerfProfileResponse_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
 }
 
 func (x *PerfProfileResponse) GetData() []byte {
 	if x != nil {
-		return x.Data
+		if x, ok := x.Event.(*PerfProfileResponse_Data); ok {
+			return x.Data
+		}
 	}
 	return nil
 }
 
-var File_internal_rpc_necoperf_proto protoreflect.FileDescriptor
+func (x *PerfProfileResponse) GetQueued() *ProfileQueued {
+	if x != nil {
+		if x, ok := x.Event.(*PerfProfileResponse_Queued); ok {
+			return x.Queued
+		}
+	}
+	return nil
+}
 
-const file_internal_rpc_necoperf_proto_rawDesc = "" +
-	"\n" +
-	"\x1binternal/rpc/necoperf.proto\x12\bnecoperf\x1a\x1egoogle/protobuf/duration.proto\"l\n" +
-	"\x12PerfProfileRequest\x12!\n" +
-	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x123\n" +
-	"\atimeout\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\atimeout\")\n" +
-	"\x13PerfProfileResponse\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\fR\x04data2T\n" +
-	"\bNecoPerf\x12H\n" +
-	"\aProfile\x12\x1c.necoperf.PerfProfileRequest\x1a\x1d.necoperf.PerfProfileResponse0\x01B,Z*github.com/cybozu-go/necoperf/internal/rpcb\x06proto3"
+func (x *PerfProfileResponse) GetRecording() *ProfileRecording {
+	if x != nil {
+		if x, ok := x.Event.(*PerfProfileResponse_Recording); ok {
+			return x.Recording
+		}
+	}
+	return nil
+}
 
-var (
-	file_internal_rpc_necoperf_proto_rawDescOnce sync.Once
-	file_internal_rpc_necoperf_proto_rawDescData []byte
-)
+func (x *PerfProfileResponse) GetConverting() *ProfileConverting {
+	if x != nil {
+		if x, ok := x.Event.(*PerfProfileResponse_Converting); ok {
+			return x.Converting
+		}
+	}
+	return nil
+}
 
-func file_internal_rpc_necoperf_proto_rawDescGZIP() []byte {
-	file_internal_rpc_necoperf_proto_rawDescOnce.Do(func() {
-		file_internal_rpc_necoperf_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_internal_rpc_necoperf_proto_rawDesc), len(file_internal_rpc_necoperf_proto_rawDesc)))
-	})
-	return file_internal_rpc_necoperf_proto_rawDescData
+type isPerfProfileResponse_Event interface {
+	isPerfProfileResponse_Event()
 }
 
-var file_internal_rpc_necoperf_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
-var file_internal_rpc_necoperf_proto_goTypes = []any{
-	(*PerfProfileRequest)(nil),  // 0: necoperf.PerfProfileRequest
-	(*PerfProfileResponse)(nil), // 1: necoperf.PerfProfileResponse
-	(*durationpb.Duration)(nil), // 2: google.protobuf.Duration
+type PerfProfileResponse_Data struct {
+	// A chunk of the profiling result, compressed as requested.
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3,oneof"`
 }
-var file_internal_rpc_necoperf_proto_depIdxs = []int32{
-	2, // 0: necoperf.PerfProfileRequest.timeout:type_name -> google.protobuf.Duration
-	0, // 1: necoperf.NecoPerf.Profile:input_type -> necoperf.PerfProfileRequest
-	1, // 2: necoperf.NecoPerf.Profile:output_type -> necoperf.PerfProfileResponse
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+
+type PerfProfileResponse_Queued struct {
+	// Sent when the session starts waiting for other profiling to finish, and whenever its position changes.
+	Queued *ProfileQueued `protobuf:"bytes,2,opt,name=queued,proto3,oneof"`
 }
 
-func init() { file_internal_rpc_necoperf_proto_init() }
-func file_internal_rpc_necoperf_proto_init() {
-	if File_internal_rpc_necoperf_proto != nil {
-		return
+type PerfProfileResponse_Recording struct {
+	// Sent when perf record starts, and periodically while recording.
+	Recording *ProfileRecording `protobuf:"bytes,3,opt,name=recording,proto3,oneof"`
+}
+
+type PerfProfileResponse_Converting struct {
+	// Sent when the recorded data starts to be converted.
+	Converting *ProfileConverting `protobuf:"bytes,4,opt,name=converting,proto3,oneof"`
+}
+
+func (*PerfProfileResponse_Data) isPerfProfileResponse_Event() {}
+
+func (*PerfProfileResponse_Queued) isPerfProfileResponse_Event() {}
+
+func (*PerfProfileResponse_Recording) isPerfProfileResponse_Event() {}
+
+func (*PerfProfileResponse_Converting) isPerfProfileResponse_Event() {}
+
+type ProfileQueued struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Position in the queue. 1 means the session runs next.
+	Position      uint32 `protobuf:"varint,1,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileQueued) Reset() {
+	*x = ProfileQueued{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileQueued) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileQueued) ProtoMessage() {}
+
+func (x *ProfileQueued) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileQueued.ProtoReflect.Descriptor instead.
+func (*ProfileQueued) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ProfileQueued) GetPosition() uint32 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type ProfileRecording struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Elapsed time since perf record started.
+	Elapsed *durationpb.Duration `protobuf:"bytes,1,opt,name=elapsed,proto3" json:"elapsed,omitempty"`
+	// Requested recording time.
+	Timeout *durationpb.Duration `protobuf:"bytes,2,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	// Number of samples collected so far.
+	Samples       uint64 `protobuf:"varint,3,opt,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileRecording) Reset() {
+	*x = ProfileRecording{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileRecording) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileRecording) ProtoMessage() {}
+
+func (x *ProfileRecording) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileRecording.ProtoReflect.Descriptor instead.
+func (*ProfileRecording) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ProfileRecording) GetElapsed() *durationpb.Duration {
+	if x != nil {
+		return x.Elapsed
+	}
+	return nil
+}
+
+func (x *ProfileRecording) GetTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.Timeout
+	}
+	return nil
+}
+
+func (x *ProfileRecording) GetSamples() uint64 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+type ProfileConverting struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of samples collected.
+	Samples       uint64 `protobuf:"varint,1,opt,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileConverting) Reset() {
+	*x = ProfileConverting{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileConverting) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileConverting) ProtoMessage() {}
+
+func (x *ProfileConverting) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileConverting.ProtoReflect.Descriptor instead.
+func (*ProfileConverting) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ProfileConverting) GetSamples() uint64 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+type StartProfileResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartProfileResponse) Reset() {
+	*x = StartProfileResponse{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartProfileResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartProfileResponse) ProtoMessage() {}
+
+func (x *StartProfileResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartProfileResponse.ProtoReflect.Descriptor instead.
+func (*StartProfileResponse) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StartProfileResponse) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+type ProfileJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileJobRequest) Reset() {
+	*x = ProfileJobRequest{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileJobRequest) ProtoMessage() {}
+
+func (x *ProfileJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileJobRequest.ProtoReflect.Descriptor instead.
+func (*ProfileJobRequest) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ProfileJobRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+type FetchProfileRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// Byte offset from which the result is sent. It is used to resume fetching.
+	// The offset is counted in the uncompressed result.
+	Offset int64 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	// Maximum size of data in each response. 64 KiB is used when zero.
+	// It must be between 1 KiB and 1 MiB.
+	ChunkSize uint32 `protobuf:"varint,3,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	// Compression of the data sent in the responses.
+	// The data from the offset is compressed as an independent stream.
+	Compression   Compression `protobuf:"varint,4,opt,name=compression,proto3,enum=necoperf.Compression" json:"compression,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FetchProfileRequest) Reset() {
+	*x = FetchProfileRequest{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchProfileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchProfileRequest) ProtoMessage() {}
+
+func (x *FetchProfileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchProfileRequest.ProtoReflect.Descriptor instead.
+func (*FetchProfileRequest) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FetchProfileRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *FetchProfileRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *FetchProfileRequest) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *FetchProfileRequest) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_COMPRESSION_NONE
+}
+
+type CancelProfileRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// If true, the job stops recording and converts the data recorded so far
+	// instead of discarding it. It has no effect unless the job is recording.
+	KeepPartial   bool `protobuf:"varint,2,opt,name=keep_partial,json=keepPartial,proto3" json:"keep_partial,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelProfileRequest) Reset() {
+	*x = CancelProfileRequest{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelProfileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelProfileRequest) ProtoMessage() {}
+
+func (x *CancelProfileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelProfileRequest.ProtoReflect.Descriptor instead.
+func (*CancelProfileRequest) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *CancelProfileRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *CancelProfileRequest) GetKeepPartial() bool {
+	if x != nil {
+		return x.KeepPartial
+	}
+	return false
+}
+
+type StatRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// IDs of the containers whose events are counted together.
+	ContainerIds []string `protobuf:"bytes,1,rep,name=container_ids,json=containerIds,proto3" json:"container_ids,omitempty"`
+	// Time for which the events are counted. It must be at most 1 minute.
+	Duration *durationpb.Duration `protobuf:"bytes,2,opt,name=duration,proto3" json:"duration,omitempty"`
+	// Events to count, which must be allowed by necoperf-daemon.
+	// task-clock, context-switches, cpu-migrations, page-faults, cycles, instructions,
+	// branches, branch-misses, cache-references and cache-misses are counted when empty.
+	Events        []string `protobuf:"bytes,3,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatRequest) Reset() {
+	*x = StatRequest{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatRequest) ProtoMessage() {}
+
+func (x *StatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatRequest.ProtoReflect.Descriptor instead.
+func (*StatRequest) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *StatRequest) GetContainerIds() []string {
+	if x != nil {
+		return x.ContainerIds
+	}
+	return nil
+}
+
+func (x *StatRequest) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+func (x *StatRequest) GetEvents() []string {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type StatResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Counters []*StatCounter         `protobuf:"bytes,1,rep,name=counters,proto3" json:"counters,omitempty"`
+	// Time for which the events were counted.
+	Elapsed       *durationpb.Duration `protobuf:"bytes,2,opt,name=elapsed,proto3" json:"elapsed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatResponse) Reset() {
+	*x = StatResponse{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatResponse) ProtoMessage() {}
+
+func (x *StatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatResponse.ProtoReflect.Descriptor instead.
+func (*StatResponse) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *StatResponse) GetCounters() []*StatCounter {
+	if x != nil {
+		return x.Counters
+	}
+	return nil
+}
+
+func (x *StatResponse) GetElapsed() *durationpb.Duration {
+	if x != nil {
+		return x.Elapsed
+	}
+	return nil
+}
+
+type StatCounter struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Event string                 `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	// Value of the counter. It is scaled by perf when the counter was multiplexed with the others.
+	Value float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	// Unit of the value, e.g. "msec" for task-clock. It is empty for counts.
+	Unit string `protobuf:"bytes,3,opt,name=unit,proto3" json:"unit,omitempty"`
+	// False if the event is not supported or has never been scheduled on the PMU.
+	Counted   bool `protobuf:"varint,4,opt,name=counted,proto3" json:"counted,omitempty"`
+	Supported bool `protobuf:"varint,5,opt,name=supported,proto3" json:"supported,omitempty"`
+	// Ratio of the time for which the counter was running, from 0 to 1.
+	RunningRatio float64 `protobuf:"fixed64,6,opt,name=running_ratio,json=runningRatio,proto3" json:"running_ratio,omitempty"`
+	// Metric derived from the counters by perf, e.g. 1.5 "insn per cycle" for instructions.
+	MetricValue   float64 `protobuf:"fixed64,7,opt,name=metric_value,json=metricValue,proto3" json:"metric_value,omitempty"`
+	MetricUnit    string  `protobuf:"bytes,8,opt,name=metric_unit,json=metricUnit,proto3" json:"metric_unit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatCounter) Reset() {
+	*x = StatCounter{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatCounter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatCounter) ProtoMessage() {}
+
+func (x *StatCounter) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatCounter.ProtoReflect.Descriptor instead.
+func (*StatCounter) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *StatCounter) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *StatCounter) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *StatCounter) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *StatCounter) GetCounted() bool {
+	if x != nil {
+		return x.Counted
+	}
+	return false
+}
+
+func (x *StatCounter) GetSupported() bool {
+	if x != nil {
+		return x.Supported
+	}
+	return false
+}
+
+func (x *StatCounter) GetRunningRatio() float64 {
+	if x != nil {
+		return x.RunningRatio
+	}
+	return 0
+}
+
+func (x *StatCounter) GetMetricValue() float64 {
+	if x != nil {
+		return x.MetricValue
+	}
+	return 0
+}
+
+func (x *StatCounter) GetMetricUnit() string {
+	if x != nil {
+		return x.MetricUnit
+	}
+	return ""
+}
+
+type ProfileStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	Phase ProfilePhase           `protobuf:"varint,2,opt,name=phase,proto3,enum=necoperf.ProfilePhase" json:"phase,omitempty"`
+	// Ratio of the elapsed recording time to the requested timeout, from 0 to 1.
+	Progress     float64      `protobuf:"fixed64,3,opt,name=progress,proto3" json:"progress,omitempty"`
+	OutputFormat OutputFormat `protobuf:"varint,4,opt,name=output_format,json=outputFormat,proto3,enum=necoperf.OutputFormat" json:"output_format,omitempty"`
+	// Size of the result in bytes. It is set when the job has succeeded.
+	Size int64 `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	// Reason of the failure.
+	Message    string                 `protobuf:"bytes,6,opt,name=message,proto3" json:"message,omitempty"`
+	CreateTime *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	FinishTime *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=finish_time,json=finishTime,proto3" json:"finish_time,omitempty"`
+	// Time after which the result is removed from the daemon.
+	ExpireTime *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=expire_time,json=expireTime,proto3" json:"expire_time,omitempty"`
+	// Position in the queue while the job is pending. 1 means the job runs next.
+	QueuePosition uint32 `protobuf:"varint,10,opt,name=queue_position,json=queuePosition,proto3" json:"queue_position,omitempty"`
+	// Number of samples collected so far.
+	Samples       uint64      `protobuf:"varint,11,opt,name=samples,proto3" json:"samples,omitempty"`
+	ProfileType   ProfileType `protobuf:"varint,12,opt,name=profile_type,json=profileType,proto3,enum=necoperf.ProfileType" json:"profile_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileStatus) Reset() {
+	*x = ProfileStatus{}
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileStatus) ProtoMessage() {}
+
+func (x *ProfileStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_rpc_necoperf_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileStatus.ProtoReflect.Descriptor instead.
+func (*ProfileStatus) Descriptor() ([]byte, []int) {
+	return file_internal_rpc_necoperf_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ProfileStatus) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *ProfileStatus) GetPhase() ProfilePhase {
+	if x != nil {
+		return x.Phase
+	}
+	return ProfilePhase_PROFILE_PHASE_UNSPECIFIED
+}
+
+func (x *ProfileStatus) GetProgress() float64 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *ProfileStatus) GetOutputFormat() OutputFormat {
+	if x != nil {
+		return x.OutputFormat
+	}
+	return OutputFormat_OUTPUT_FORMAT_SCRIPT
+}
+
+func (x *ProfileStatus) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ProfileStatus) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ProfileStatus) GetCreateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreateTime
+	}
+	return nil
+}
+
+func (x *ProfileStatus) GetFinishTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishTime
+	}
+	return nil
+}
+
+func (x *ProfileStatus) GetExpireTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpireTime
+	}
+	return nil
+}
+
+func (x *ProfileStatus) GetQueuePosition() uint32 {
+	if x != nil {
+		return x.QueuePosition
+	}
+	return 0
+}
+
+func (x *ProfileStatus) GetSamples() uint64 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+func (x *ProfileStatus) GetProfileType() ProfileType {
+	if x != nil {
+		return x.ProfileType
+	}
+	return ProfileType_PROFILE_TYPE_CPU
+}
+
+var File_internal_rpc_necoperf_proto protoreflect.FileDescriptor
+
+const file_internal_rpc_necoperf_proto_rawDesc = "" +
+	"\n" +
+	"\x1binternal/rpc/necoperf.proto\x12\bnecoperf\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xfb\x03\n" +
+	"\x12PerfProfileRequest\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x123\n" +
+	"\atimeout\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x12B\n" +
+	"\x0erecord_options\x18\x03 \x01(\v2\x1b.necoperf.PerfRecordOptionsR\rrecordOptions\x12;\n" +
+	"\routput_format\x18\x04 \x01(\x0e2\x16.necoperf.OutputFormatR\foutputFormat\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x05 \x01(\rR\tchunkSize\x127\n" +
+	"\vcompression\x18\x06 \x01(\x0e2\x15.necoperf.CompressionR\vcompression\x12#\n" +
+	"\rcontainer_ids\x18\a \x03(\tR\fcontainerIds\x12=\n" +
+	"\x0econtainer_refs\x18\b \x03(\v2\x16.necoperf.ContainerRefR\rcontainerRefs\x128\n" +
+	"\fprofile_type\x18\t \x01(\x0e2\x15.necoperf.ProfileTypeR\vprofileType\x12\x16\n" +
+	"\x06cgroup\x18\n" +
+	" \x01(\bR\x06cgroup\"n\n" +
+	"\fContainerRef\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x19\n" +
+	"\bpod_name\x18\x02 \x01(\tR\apodName\x12%\n" +
+	"\x0econtainer_name\x18\x03 \x01(\tR\rcontainerName\"\xb0\x01\n" +
+	"\x11PerfRecordOptions\x12\x1c\n" +
+	"\tfrequency\x18\x01 \x01(\rR\tfrequency\x12\x1d\n" +
+	"\n" +
+	"call_graph\x18\x02 \x01(\tR\tcallGraph\x12(\n" +
+	"\x10dwarf_stack_size\x18\x03 \x01(\rR\x0edwarfStackSize\x12$\n" +
+	"\vsystem_wide\x18\x04 \x01(\bH\x00R\n" +
+	"systemWide\x88\x01\x01B\x0e\n" +
+	"\f_system_wide\"\xe2\x01\n" +
+	"\x13PerfProfileResponse\x12\x14\n" +
+	"\x04data\x18\x01 \x01(\fH\x00R\x04data\x121\n" +
+	"\x06queued\x18\x02 \x01(\v2\x17.necoperf.ProfileQueuedH\x00R\x06queued\x12:\n" +
+	"\trecording\x18\x03 \x01(\v2\x1a.necoperf.ProfileRecordingH\x00R\trecording\x12=\n" +
+	"\n" +
+	"converting\x18\x04 \x01(\v2\x1b.necoperf.ProfileConvertingH\x00R\n" +
+	"convertingB\a\n" +
+	"\x05event\"+\n" +
+	"\rProfileQueued\x12\x1a\n" +
+	"\bposition\x18\x01 \x01(\rR\bposition\"\x96\x01\n" +
+	"\x10ProfileRecording\x123\n" +
+	"\aelapsed\x18\x01 \x01(\v2\x19.google.protobuf.DurationR\aelapsed\x123\n" +
+	"\atimeout\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x12\x18\n" +
+	"\asamples\x18\x03 \x01(\x04R\asamples\"-\n" +
+	"\x11ProfileConverting\x12\x18\n" +
+	"\asamples\x18\x01 \x01(\x04R\asamples\"-\n" +
+	"\x14StartProfileResponse\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\"*\n" +
+	"\x11ProfileJobRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\"\x9c\x01\n" +
+	"\x13FetchProfileRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x03 \x01(\rR\tchunkSize\x127\n" +
+	"\vcompression\x18\x04 \x01(\x0e2\x15.necoperf.CompressionR\vcompression\"P\n" +
+	"\x14CancelProfileRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12!\n" +
+	"\fkeep_partial\x18\x02 \x01(\bR\vkeepPartial\"\x81\x01\n" +
+	"\vStatRequest\x12#\n" +
+	"\rcontainer_ids\x18\x01 \x03(\tR\fcontainerIds\x125\n" +
+	"\bduration\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\bduration\x12\x16\n" +
+	"\x06events\x18\x03 \x03(\tR\x06events\"v\n" +
+	"\fStatResponse\x121\n" +
+	"\bcounters\x18\x01 \x03(\v2\x15.necoperf.StatCounterR\bcounters\x123\n" +
+	"\aelapsed\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\aelapsed\"\xee\x01\n" +
+	"\vStatCounter\x12\x14\n" +
+	"\x05event\x18\x01 \x01(\tR\x05event\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\x12\x12\n" +
+	"\x04unit\x18\x03 \x01(\tR\x04unit\x12\x18\n" +
+	"\acounted\x18\x04 \x01(\bR\acounted\x12\x1c\n" +
+	"\tsupported\x18\x05 \x01(\bR\tsupported\x12#\n" +
+	"\rrunning_ratio\x18\x06 \x01(\x01R\frunningRatio\x12!\n" +
+	"\fmetric_value\x18\a \x01(\x01R\vmetricValue\x12\x1f\n" +
+	"\vmetric_unit\x18\b \x01(\tR\n" +
+	"metricUnit\"\x8d\x04\n" +
+	"\rProfileStatus\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12,\n" +
+	"\x05phase\x18\x02 \x01(\x0e2\x16.necoperf.ProfilePhaseR\x05phase\x12\x1a\n" +
+	"\bprogress\x18\x03 \x01(\x01R\bprogress\x12;\n" +
+	"\routput_format\x18\x04 \x01(\x0e2\x16.necoperf.OutputFormatR\foutputFormat\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\x03R\x04size\x12\x18\n" +
+	"\amessage\x18\x06 \x01(\tR\amessage\x12;\n" +
+	"\vcreate_time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"createTime\x12;\n" +
+	"\vfinish_time\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishTime\x12;\n" +
+	"\vexpire_time\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"expireTime\x12%\n" +
+	"\x0equeue_position\x18\n" +
+	" \x01(\rR\rqueuePosition\x12\x18\n" +
+	"\asamples\x18\v \x01(\x04R\asamples\x128\n" +
+	"\fprofile_type\x18\f \x01(\x0e2\x15.necoperf.ProfileTypeR\vprofileType*=\n" +
+	"\vProfileType\x12\x14\n" +
+	"\x10PROFILE_TYPE_CPU\x10\x00\x12\x18\n" +
+	"\x14PROFILE_TYPE_OFF_CPU\x10\x01*O\n" +
+	"\vCompression\x12\x14\n" +
+	"\x10COMPRESSION_NONE\x10\x00\x12\x14\n" +
+	"\x10COMPRESSION_GZIP\x10\x01\x12\x14\n" +
+	"\x10COMPRESSION_ZSTD\x10\x02*r\n" +
+	"\fOutputFormat\x12\x18\n" +
+	"\x14OUTPUT_FORMAT_SCRIPT\x10\x00\x12\x17\n" +
+	"\x13OUTPUT_FORMAT_PPROF\x10\x01\x12\x18\n" +
+	"\x14OUTPUT_FORMAT_FOLDED\x10\x02\x12\x15\n" +
+	"\x11OUTPUT_FORMAT_RAW\x10\x03*\xd7\x01\n" +
+	"\fProfilePhase\x12\x1d\n" +
+	"\x19PROFILE_PHASE_UNSPECIFIED\x10\x00\x12\x19\n" +
+	"\x15PROFILE_PHASE_PENDING\x10\x01\x12\x1b\n" +
+	"\x17PROFILE_PHASE_RECORDING\x10\x02\x12\x1c\n" +
+	"\x18PROFILE_PHASE_CONVERTING\x10\x03\x12\x1b\n" +
+	"\x17PROFILE_PHASE_SUCCEEDED\x10\x04\x12\x18\n" +
+	"\x14PROFILE_PHASE_FAILED\x10\x05\x12\x1b\n" +
+	"\x17PROFILE_PHASE_CANCELLED\x10\x062\xbd\x03\n" +
+	"\bNecoPerf\x12H\n" +
+	"\aProfile\x12\x1c.necoperf.PerfProfileRequest\x1a\x1d.necoperf.PerfProfileResponse0\x01\x12L\n" +
+	"\fStartProfile\x12\x1c.necoperf.PerfProfileRequest\x1a\x1e.necoperf.StartProfileResponse\x12H\n" +
+	"\x10GetProfileStatus\x12\x1b.necoperf.ProfileJobRequest\x1a\x17.necoperf.ProfileStatus\x12N\n" +
+	"\fFetchProfile\x12\x1d.necoperf.FetchProfileRequest\x1a\x1d.necoperf.PerfProfileResponse0\x01\x12H\n" +
+	"\rCancelProfile\x12\x1e.necoperf.CancelProfileRequest\x1a\x17.necoperf.ProfileStatus\x125\n" +
+	"\x04Stat\x12\x15.necoperf.StatRequest\x1a\x16.necoperf.StatResponseB,Z*github.com/cybozu-go/necoperf/internal/rpcb\x06proto3"
+
+var (
+	file_internal_rpc_necoperf_proto_rawDescOnce sync.Once
+	file_internal_rpc_necoperf_proto_rawDescData []byte
+)
+
+func file_internal_rpc_necoperf_proto_rawDescGZIP() []byte {
+	file_internal_rpc_necoperf_proto_rawDescOnce.Do(func() {
+		file_internal_rpc_necoperf_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_internal_rpc_necoperf_proto_rawDesc), len(file_internal_rpc_necoperf_proto_rawDesc)))
+	})
+	return file_internal_rpc_necoperf_proto_rawDescData
+}
+
+var file_internal_rpc_necoperf_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
+var file_internal_rpc_necoperf_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_internal_rpc_necoperf_proto_goTypes = []any{
+	(ProfileType)(0),              // 0: necoperf.ProfileType
+	(Compression)(0),              // 1: necoperf.Compression
+	(OutputFormat)(0),             // 2: necoperf.OutputFormat
+	(ProfilePhase)(0),             // 3: necoperf.ProfilePhase
+	(*PerfProfileRequest)(nil),    // 4: necoperf.PerfProfileRequest
+	(*ContainerRef)(nil),          // 5: necoperf.ContainerRef
+	(*PerfRecordOptions)(nil),     // 6: necoperf.PerfRecordOptions
+	(*PerfProfileResponse)(nil),   // 7: necoperf.PerfProfileResponse
+	(*ProfileQueued)(nil),         // 8: necoperf.ProfileQueued
+	(*ProfileRecording)(nil),      // 9: necoperf.ProfileRecording
+	(*ProfileConverting)(nil),     // 10: necoperf.ProfileConverting
+	(*StartProfileResponse)(nil),  // 11: necoperf.StartProfileResponse
+	(*ProfileJobRequest)(nil),     // 12: necoperf.ProfileJobRequest
+	(*FetchProfileRequest)(nil),   // 13: necoperf.FetchProfileRequest
+	(*CancelProfileRequest)(nil),  // 14: necoperf.CancelProfileRequest
+	(*StatRequest)(nil),           // 15: necoperf.StatRequest
+	(*StatResponse)(nil),          // 16: necoperf.StatResponse
+	(*StatCounter)(nil),           // 17: necoperf.StatCounter
+	(*ProfileStatus)(nil),         // 18: necoperf.ProfileStatus
+	(*durationpb.Duration)(nil),   // 19: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 20: google.protobuf.Timestamp
+}
+var file_internal_rpc_necoperf_proto_depIdxs = []int32{
+	19, // 0: necoperf.PerfProfileRequest.timeout:type_name -> google.protobuf.Duration
+	6,  // 1: necoperf.PerfProfileRequest.record_options:type_name -> necoperf.PerfRecordOptions
+	2,  // 2: necoperf.PerfProfileRequest.output_format:type_name -> necoperf.OutputFormat
+	1,  // 3: necoperf.PerfProfileRequest.compression:type_name -> necoperf.Compression
+	5,  // 4: necoperf.PerfProfileRequest.container_refs:type_name -> necoperf.ContainerRef
+	0,  // 5: necoperf.PerfProfileRequest.profile_type:type_name -> necoperf.ProfileType
+	8,  // 6: necoperf.PerfProfileResponse.queued:type_name -> necoperf.ProfileQueued
+	9,  // 7: necoperf.PerfProfileResponse.recording:type_name -> necoperf.ProfileRecording
+	10, // 8: necoperf.PerfProfileResponse.converting:type_name -> necoperf.ProfileConverting
+	19, // 9: necoperf.ProfileRecording.elapsed:type_name -> google.protobuf.Duration
+	19, // 10: necoperf.ProfileRecording.timeout:type_name -> google.protobuf.Duration
+	1,  // 11: necoperf.FetchProfileRequest.compression:type_name -> necoperf.Compression
+	19, // 12: necoperf.StatRequest.duration:type_name -> google.protobuf.Duration
+	17, // 13: necoperf.StatResponse.counters:type_name -> necoperf.StatCounter
+	19, // 14: necoperf.StatResponse.elapsed:type_name -> google.protobuf.Duration
+	3,  // 15: necoperf.ProfileStatus.phase:type_name -> necoperf.ProfilePhase
+	2,  // 16: necoperf.ProfileStatus.output_format:type_name -> necoperf.OutputFormat
+	20, // 17: necoperf.ProfileStatus.create_time:type_name -> google.protobuf.Timestamp
+	20, // 18: necoperf.ProfileStatus.finish_time:type_name -> google.protobuf.Timestamp
+	20, // 19: necoperf.ProfileStatus.expire_time:type_name -> google.protobuf.Timestamp
+	0,  // 20: necoperf.ProfileStatus.profile_type:type_name -> necoperf.ProfileType
+	4,  // 21: necoperf.NecoPerf.Profile:input_type -> necoperf.PerfProfileRequest
+	4,  // 22: necoperf.NecoPerf.StartProfile:input_type -> necoperf.PerfProfileRequest
+	12, // 23: necoperf.NecoPerf.GetProfileStatus:input_type -> necoperf.ProfileJobRequest
+	13, // 24: necoperf.NecoPerf.FetchProfile:input_type -> necoperf.FetchProfileRequest
+	14, // 25: necoperf.NecoPerf.CancelProfile:input_type -> necoperf.CancelProfileRequest
+	15, // 26: necoperf.NecoPerf.Stat:input_type -> necoperf.StatRequest
+	7,  // 27: necoperf.NecoPerf.Profile:output_type -> necoperf.PerfProfileResponse
+	11, // 28: necoperf.NecoPerf.StartProfile:output_type -> necoperf.StartProfileResponse
+	18, // 29: necoperf.NecoPerf.GetProfileStatus:output_type -> necoperf.ProfileStatus
+	7,  // 30: necoperf.NecoPerf.FetchProfile:output_type -> necoperf.PerfProfileResponse
+	18, // 31: necoperf.NecoPerf.CancelProfile:output_type -> necoperf.ProfileStatus
+	16, // 32: necoperf.NecoPerf.Stat:output_type -> necoperf.StatResponse
+	27, // [27:33] is the sub-list for method output_type
+	21, // [21:27] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
+}
+
+func init() { file_internal_rpc_necoperf_proto_init() }
+func file_internal_rpc_necoperf_proto_init() {
+	if File_internal_rpc_necoperf_proto != nil {
+		return
+	}
+	file_internal_rpc_necoperf_proto_msgTypes[2].OneofWrappers = []any{}
+	file_internal_rpc_necoperf_proto_msgTypes[3].OneofWrappers = []any{
+		(*PerfProfileResponse_Data)(nil),
+		(*PerfProfileResponse_Queued)(nil),
+		(*PerfProfileResponse_Recording)(nil),
+		(*PerfProfileResponse_Converting)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_rpc_necoperf_proto_rawDesc), len(file_internal_rpc_necoperf_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      4,
+			NumMessages:   15,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_internal_rpc_necoperf_proto_goTypes,
 		DependencyIndexes: file_internal_rpc_necoperf_proto_depIdxs,
+		EnumInfos:         file_internal_rpc_necoperf_proto_enumTypes,
 		MessageInfos:      file_internal_rpc_necoperf_proto_msgTypes,
 	}.Build()
 	File_internal_rpc_necoperf_proto = out.File
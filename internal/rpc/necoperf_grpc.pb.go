@@ -19,7 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	NecoPerf_Profile_FullMethodName = "/necoperf.NecoPerf/Profile"
+	NecoPerf_Profile_FullMethodName          = "/necoperf.NecoPerf/Profile"
+	NecoPerf_StartProfile_FullMethodName     = "/necoperf.NecoPerf/StartProfile"
+	NecoPerf_GetProfileStatus_FullMethodName = "/necoperf.NecoPerf/GetProfileStatus"
+	NecoPerf_FetchProfile_FullMethodName     = "/necoperf.NecoPerf/FetchProfile"
+	NecoPerf_CancelProfile_FullMethodName    = "/necoperf.NecoPerf/CancelProfile"
+	NecoPerf_Stat_FullMethodName             = "/necoperf.NecoPerf/Stat"
 )
 
 // NecoPerfClient is the client API for NecoPerf service.
@@ -27,6 +32,14 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type NecoPerfClient interface {
 	Profile(ctx context.Context, in *PerfProfileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PerfProfileResponse], error)
+	// Starts a profiling job in the background and returns its ID.
+	StartProfile(ctx context.Context, in *PerfProfileRequest, opts ...grpc.CallOption) (*StartProfileResponse, error)
+	GetProfileStatus(ctx context.Context, in *ProfileJobRequest, opts ...grpc.CallOption) (*ProfileStatus, error)
+	// Streams the result of a succeeded job from the specified offset.
+	FetchProfile(ctx context.Context, in *FetchProfileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PerfProfileResponse], error)
+	CancelProfile(ctx context.Context, in *CancelProfileRequest, opts ...grpc.CallOption) (*ProfileStatus, error)
+	// Counts the hardware and software events of the containers by perf stat.
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error)
 }
 
 type necoPerfClient struct {
@@ -56,11 +69,78 @@ func (c *necoPerfClient) Profile(ctx context.Context, in *PerfProfileRequest, op
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type NecoPerf_ProfileClient = grpc.ServerStreamingClient[PerfProfileResponse]
 
+func (c *necoPerfClient) StartProfile(ctx context.Context, in *PerfProfileRequest, opts ...grpc.CallOption) (*StartProfileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StartProfileResponse)
+	err := c.cc.Invoke(ctx, NecoPerf_StartProfile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *necoPerfClient) GetProfileStatus(ctx context.Context, in *ProfileJobRequest, opts ...grpc.CallOption) (*ProfileStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProfileStatus)
+	err := c.cc.Invoke(ctx, NecoPerf_GetProfileStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *necoPerfClient) FetchProfile(ctx context.Context, in *FetchProfileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PerfProfileResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &NecoPerf_ServiceDesc.Streams[1], NecoPerf_FetchProfile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[FetchProfileRequest, PerfProfileResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type NecoPerf_FetchProfileClient = grpc.ServerStreamingClient[PerfProfileResponse]
+
+func (c *necoPerfClient) CancelProfile(ctx context.Context, in *CancelProfileRequest, opts ...grpc.CallOption) (*ProfileStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProfileStatus)
+	err := c.cc.Invoke(ctx, NecoPerf_CancelProfile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *necoPerfClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatResponse)
+	err := c.cc.Invoke(ctx, NecoPerf_Stat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // NecoPerfServer is the server API for NecoPerf service.
 // All implementations must embed UnimplementedNecoPerfServer
 // for forward compatibility.
 type NecoPerfServer interface {
 	Profile(*PerfProfileRequest, grpc.ServerStreamingServer[PerfProfileResponse]) error
+	// Starts a profiling job in the background and returns its ID.
+	StartProfile(context.Context, *PerfProfileRequest) (*StartProfileResponse, error)
+	GetProfileStatus(context.Context, *ProfileJobRequest) (*ProfileStatus, error)
+	// Streams the result of a succeeded job from the specified offset.
+	FetchProfile(*FetchProfileRequest, grpc.ServerStreamingServer[PerfProfileResponse]) error
+	CancelProfile(context.Context, *CancelProfileRequest) (*ProfileStatus, error)
+	// Counts the hardware and software events of the containers by perf stat.
+	Stat(context.Context, *StatRequest) (*StatResponse, error)
 	mustEmbedUnimplementedNecoPerfServer()
 }
 
@@ -74,6 +154,21 @@ type UnimplementedNecoPerfServer struct{}
 func (UnimplementedNecoPerfServer) Profile(*PerfProfileRequest, grpc.ServerStreamingServer[PerfProfileResponse]) error {
 	return status.Error(codes.Unimplemented, "method Profile not implemented")
 }
+func (UnimplementedNecoPerfServer) StartProfile(context.Context, *PerfProfileRequest) (*StartProfileResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method StartProfile not implemented")
+}
+func (UnimplementedNecoPerfServer) GetProfileStatus(context.Context, *ProfileJobRequest) (*ProfileStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetProfileStatus not implemented")
+}
+func (UnimplementedNecoPerfServer) FetchProfile(*FetchProfileRequest, grpc.ServerStreamingServer[PerfProfileResponse]) error {
+	return status.Error(codes.Unimplemented, "method FetchProfile not implemented")
+}
+func (UnimplementedNecoPerfServer) CancelProfile(context.Context, *CancelProfileRequest) (*ProfileStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method CancelProfile not implemented")
+}
+func (UnimplementedNecoPerfServer) Stat(context.Context, *StatRequest) (*StatResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Stat not implemented")
+}
 func (UnimplementedNecoPerfServer) mustEmbedUnimplementedNecoPerfServer() {}
 func (UnimplementedNecoPerfServer) testEmbeddedByValue()                  {}
 
@@ -106,19 +201,124 @@ func _NecoPerf_Profile_Handler(srv interface{}, stream grpc.ServerStream) error
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type NecoPerf_ProfileServer = grpc.ServerStreamingServer[PerfProfileResponse]
 
+func _NecoPerf_StartProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PerfProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NecoPerfServer).StartProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NecoPerf_StartProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NecoPerfServer).StartProfile(ctx, req.(*PerfProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NecoPerf_GetProfileStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProfileJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NecoPerfServer).GetProfileStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NecoPerf_GetProfileStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NecoPerfServer).GetProfileStatus(ctx, req.(*ProfileJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NecoPerf_FetchProfile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FetchProfileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NecoPerfServer).FetchProfile(m, &grpc.GenericServerStream[FetchProfileRequest, PerfProfileResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type NecoPerf_FetchProfileServer = grpc.ServerStreamingServer[PerfProfileResponse]
+
+func _NecoPerf_CancelProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NecoPerfServer).CancelProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NecoPerf_CancelProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NecoPerfServer).CancelProfile(ctx, req.(*CancelProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NecoPerf_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NecoPerfServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NecoPerf_Stat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NecoPerfServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // NecoPerf_ServiceDesc is the grpc.ServiceDesc for NecoPerf service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var NecoPerf_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "necoperf.NecoPerf",
 	HandlerType: (*NecoPerfServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartProfile",
+			Handler:    _NecoPerf_StartProfile_Handler,
+		},
+		{
+			MethodName: "GetProfileStatus",
+			Handler:    _NecoPerf_GetProfileStatus_Handler,
+		},
+		{
+			MethodName: "CancelProfile",
+			Handler:    _NecoPerf_CancelProfile_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _NecoPerf_Stat_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Profile",
 			Handler:       _NecoPerf_Profile_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "FetchProfile",
+			Handler:       _NecoPerf_FetchProfile_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "internal/rpc/necoperf.proto",
 }
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir is a Sink which saves the profiles as files in a directory.
+// The profile of a container is saved to NAMESPACE/POD/CONTAINER-TIMESTAMP.pb.gz.
+type Dir struct {
+	dir string
+}
+
+var _ Sink = &Dir{}
+
+func NewDir(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{dir: dir}, nil
+}
+
+// Put saves the profile. The file is written atomically so that readers never see a partial profile.
+func (d *Dir) Put(ctx context.Context, p *Profile) error {
+	podDir := filepath.Join(d.dir, p.Namespace, p.Pod)
+	if err := os.MkdirAll(podDir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.pb.gz", p.Container, p.Start.UTC().Format("20060102T150405Z"))
+	f, err := os.CreateTemp(podDir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(p.Data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(podDir, name))
+}
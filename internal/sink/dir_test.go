@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testProfile() *Profile {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &Profile{
+		Namespace: "tenant",
+		Pod:       "app-pod",
+		Container: "app",
+		Start:     start,
+		End:       start.Add(10 * time.Second),
+		Frequency: 19,
+		Data:      []byte("profile"),
+	}
+}
+
+func TestDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := NewDir(filepath.Join(dir, "profiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(context.Background(), testProfile()); err != nil {
+		t.Fatal(err)
+	}
+
+	podDir := filepath.Join(dir, "profiles", "tenant", "app-pod")
+	data, err := os.ReadFile(filepath.Join(podDir, "app-20250102T030405Z.pb.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "profile", string(data))
+
+	// No temporary file is left.
+	entries, err := os.ReadDir(podDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 1)
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	s, err := New("http://pyroscope:4040", "necoperf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, &Pyroscope{}, s)
+
+	s, err = New(t.TempDir(), "necoperf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, &Dir{}, s)
+}
@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	pyroscopeTimeout = 30 * time.Second
+	// maxErrorBodySize is the maximum size of the response body included in the error.
+	maxErrorBodySize = 1024
+)
+
+// Pyroscope is a Sink which pushes the profiles to the ingest API of a Pyroscope-compatible server.
+// The containers are identified by the namespace, pod and container labels of the series.
+type Pyroscope struct {
+	url     *url.URL
+	appName string
+	client  *http.Client
+}
+
+var _ Sink = &Pyroscope{}
+
+// NewPyroscope returns Pyroscope pushing to the server at serverURL.
+// If serverURL contains the user information, it is sent by the basic authentication.
+func NewPyroscope(serverURL, appName string) (*Pyroscope, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme of the Pyroscope URL: %q", u.Scheme)
+	}
+	if len(appName) == 0 {
+		return nil, errors.New("application name is empty")
+	}
+
+	return &Pyroscope{
+		url:     u.JoinPath("ingest"),
+		appName: appName,
+		client:  &http.Client{Timeout: pyroscopeTimeout},
+	}, nil
+}
+
+// name returns the name of the series, which consists of the application name and the labels.
+func (s *Pyroscope) name(p *Profile) string {
+	return fmt.Sprintf("%s.cpu{namespace=%s,pod=%s,container=%s}", s.appName, p.Namespace, p.Pod, p.Container)
+}
+
+// Put sends the profile in the pprof format as a multipart form, as the Pyroscope clients do.
+func (s *Pyroscope) Put(ctx context.Context, p *Profile) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(p.Data); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	u := *s.url
+	q := u.Query()
+	q.Set("name", s.name(p))
+	q.Set("from", strconv.FormatInt(p.Start.Unix(), 10))
+	q.Set("until", strconv.FormatInt(p.End.Unix(), 10))
+	q.Set("format", "pprof")
+	q.Set("sampleRate", strconv.Itoa(p.Frequency))
+	q.Set("spyName", "necoperf")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return fmt.Errorf("failed to push the profile to %s: %s: %s", s.url.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
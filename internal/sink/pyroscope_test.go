@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPyroscope(t *testing.T) {
+	t.Parallel()
+
+	var query url.Values
+	var data []byte
+	var user, password string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/pyroscope/ingest" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.Query()
+		user, password, _ = r.BasicAuth()
+
+		f, _, err := r.FormFile("profile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		data, _ = io.ReadAll(f)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.User = url.UserPassword("tenant", "secret")
+	u.Path = "/pyroscope"
+	s, err := NewPyroscope(u.String(), "necoperf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := testProfile()
+	if err := s.Put(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "necoperf.cpu{namespace=tenant,pod=app-pod,container=app}", query.Get("name"))
+	assert.Equal(t, "1735787045", query.Get("from"))
+	assert.Equal(t, "1735787055", query.Get("until"))
+	assert.Equal(t, "pprof", query.Get("format"))
+	assert.Equal(t, "19", query.Get("sampleRate"))
+	assert.Equal(t, "profile", string(data))
+	assert.Equal(t, "tenant", user)
+	assert.Equal(t, "secret", password)
+}
+
+func TestPyroscopeError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid profile", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	s, err := NewPyroscope(srv.URL, "necoperf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put(context.Background(), testProfile())
+	assert.ErrorContains(t, err, "400 Bad Request: invalid profile")
+
+	_, err = NewPyroscope("ftp://example.com", "necoperf")
+	assert.Error(t, err)
+	_, err = NewPyroscope(srv.URL, "")
+	assert.Error(t, err)
+}
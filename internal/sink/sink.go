@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Profile is the profile of a container recorded in a sampling window of the continuous or triggered profiling.
+type Profile struct {
+	Namespace string
+	Pod       string
+	Container string
+	// Start and End are the time range of the sampling window.
+	Start time.Time
+	End   time.Time
+	// Frequency is the sampling frequency in Hz.
+	Frequency int
+	// Data is the gzipped profile.proto.
+	Data []byte
+}
+
+// Sink stores the profiles recorded by the continuous or triggered profiling.
+type Sink interface {
+	Put(ctx context.Context, p *Profile) error
+}
+
+// New returns the sink of the target.
+// If the target is an HTTP(S) URL, the profiles are pushed to the Pyroscope-compatible server with the application name.
+// Otherwise, the target is the directory in which the profiles are saved.
+func New(target, appName string) (Sink, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return NewPyroscope(target, appName)
+	}
+	return NewDir(target)
+}
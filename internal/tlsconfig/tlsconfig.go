@@ -0,0 +1,167 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Reloader provides the TLS configuration of the server from the certificate files.
+// The files are reloaded when they are updated, e.g. by cert-manager,
+// so that the server does not need to be restarted to rotate the certificates.
+type Reloader struct {
+	logger   *slog.Logger
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu     sync.Mutex
+	stamp  string
+	config *tls.Config
+}
+
+// NewReloader loads the server certificate and key, and the client CA if caFile is not empty.
+// If the client CA is given, the server requires and verifies client certificates.
+func NewReloader(logger *slog.Logger, certFile, keyFile, caFile string) (*Reloader, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, errors.New("both certificate and key must be specified")
+	}
+
+	r := &Reloader{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	stamp, err := r.currentStamp()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(stamp); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// ServerConfig returns the TLS configuration which uses the latest certificates for each connection.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+func (r *Reloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp, err := r.currentStamp()
+	if err != nil {
+		r.logger.Error("failed to check certificate files", "error", err)
+		return r.config
+	}
+	if stamp == r.stamp {
+		return r.config
+	}
+
+	// The stamp is updated even on failure so that the files are not reloaded until they are updated again.
+	// The files may be updated one by one, so a failure is often recovered by the next update.
+	if err := r.load(stamp); err != nil {
+		r.stamp = stamp
+		r.logger.Error("failed to reload certificates, so the previous ones are used", "error", err)
+		return r.config
+	}
+	r.logger.Info("certificates are reloaded")
+	return r.config
+}
+
+// currentStamp returns a string which changes when any of the files is updated.
+func (r *Reloader) currentStamp() (string, error) {
+	var b strings.Builder
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if len(name) == 0 {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", name, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String(), nil
+}
+
+func (r *Reloader) load(stamp string) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if len(r.caFile) != 0 {
+		pool, err := loadCertPool(r.caFile)
+		if err != nil {
+			return err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.stamp = stamp
+	r.config = config
+	return nil
+}
+
+// ClientConfig returns the TLS configuration of the client.
+// If caFile is empty, the system CAs are used to verify the server certificate.
+// The client certificate is presented if certFile and keyFile are given.
+// If serverName is not empty, it is used to verify the server certificate instead of the address.
+func ClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	if (len(certFile) == 0) != (len(keyFile) == 0) {
+		return nil, errors.New("both certificate and key must be specified")
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if len(caFile) != 0 {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if len(certFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate is found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,194 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const serverName = "necoperf-daemon"
+
+// writeCount is used to advance the modification time of the written files.
+var writeCount int
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeCA(t *testing.T, path string) {
+	writePEM(t, path, "CERTIFICATE", ca.cert.Raw)
+}
+
+// issue writes a certificate signed by the CA and its key to certFile and keyFile.
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure that the modification time changes even on file systems with coarse timestamps.
+	writeCount++
+	mtime := time.Now().Add(time.Duration(writeCount) * time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects the client to the server and returns the serial number of the server certificate.
+func handshake(serverConfig, clientConfig *tls.Config) (int64, error) {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	server := tls.Server(sc, serverConfig)
+	go func() {
+		server.Handshake()
+		server.Close()
+	}()
+
+	client := tls.Client(cc, clientConfig)
+	if err := client.Handshake(); err != nil {
+		return 0, err
+	}
+	// The server verifies the client certificate after the client finishes the handshake in TLS 1.3.
+	if _, err := client.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	return client.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	serverCert := filepath.Join(dir, "tls.crt")
+	serverKey := filepath.Join(dir, "tls.key")
+	clientCert := filepath.Join(dir, "client.crt")
+	clientKey := filepath.Join(dir, "client.key")
+
+	ca := newTestCA(t)
+	ca.writeCA(t, caFile)
+	ca.issue(t, 10, x509.ExtKeyUsageServerAuth, serverCert, serverKey)
+	ca.issue(t, 20, x509.ExtKeyUsageClientAuth, clientCert, clientKey)
+
+	r, err := NewReloader(slog.Default(), serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := r.ServerConfig()
+
+	clientConfig, err := ClientConfig(caFile, clientCert, clientKey, serverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := handshake(serverConfig, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), serial)
+
+	noCertConfig, err := ClientConfig(caFile, "", "", serverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = handshake(serverConfig, noCertConfig)
+	assert.Error(t, err, "client without certificate should be rejected")
+
+	ca.issue(t, 11, x509.ExtKeyUsageServerAuth, serverCert, serverKey)
+	serial, err = handshake(serverConfig, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), serial, "renewed certificate should be used")
+
+	// An invalid key is not loaded and the previous certificate is kept.
+	writePEM(t, serverKey, "EC PRIVATE KEY", []byte("invalid"))
+	serial, err = handshake(serverConfig, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), serial)
+}
+
+func TestNewReloader(t *testing.T) {
+	dir := t.TempDir()
+
+	testCases := map[string]struct {
+		certFile string
+		keyFile  string
+	}{
+		"no key": {
+			certFile: filepath.Join(dir, "tls.crt"),
+		},
+		"no file": {
+			certFile: filepath.Join(dir, "tls.crt"),
+			keyFile:  filepath.Join(dir, "tls.key"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewReloader(slog.Default(), tc.certFile, tc.keyFile, "")
+			assert.Error(t, err)
+		})
+	}
+}